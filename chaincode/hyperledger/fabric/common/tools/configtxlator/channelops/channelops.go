@@ -0,0 +1,286 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package channelops
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/common/cauthdsl"
+	"github.com/hyperledger/fabric/common/config"
+	mspconfig "github.com/hyperledger/fabric/common/config/msp"
+	"github.com/hyperledger/fabric/common/configtx"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/update"
+	"github.com/hyperledger/fabric/msp"
+	cb "github.com/hyperledger/fabric/protos/common"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ConfigFromBlock returns the config held by the config block block and the
+// ID of its channel
+func ConfigFromBlock(block *cb.Block) (*cb.Config, string, error) {
+	env, err := utils.ExtractEnvelope(block, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("error extracting envelope from block: %s", err)
+	}
+	payload, err := utils.ExtractPayload(env)
+	if err != nil {
+		return nil, "", fmt.Errorf("error extracting payload from block: %s", err)
+	}
+	if payload.Header == nil {
+		return nil, "", fmt.Errorf("block payload has no header")
+	}
+	chdr, err := utils.UnmarshalChannelHeader(payload.Header.ChannelHeader)
+	if err != nil {
+		return nil, "", fmt.Errorf("error unmarshaling channel header: %s", err)
+	}
+	if chdr.Type != int32(cb.HeaderType_CONFIG) {
+		return nil, "", fmt.Errorf("block is of type %s, not %s", cb.HeaderType(chdr.Type), cb.HeaderType_CONFIG)
+	}
+
+	configEnv, err := configtx.UnmarshalConfigEnvelope(payload.Data)
+	if err != nil {
+		return nil, "", err
+	}
+	if configEnv.Config == nil || configEnv.Config.ChannelGroup == nil {
+		return nil, "", fmt.Errorf("block holds no config")
+	}
+
+	return configEnv.Config, chdr.ChannelId, nil
+}
+
+// ComputeUpdate applies edit to a copy of the config held by the config
+// block block and returns the config update for the channel of block which
+// transitions to the edited config
+func ComputeUpdate(block *cb.Block, edit func(conf *cb.Config) error) (*cb.ConfigUpdate, error) {
+	original, channelID, err := ConfigFromBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := proto.Clone(original).(*cb.Config)
+	err = edit(updated)
+	if err != nil {
+		return nil, err
+	}
+
+	configUpdate, err := update.Compute(original, updated)
+	if err != nil {
+		return nil, err
+	}
+	configUpdate.ChannelId = channelID
+
+	return configUpdate, nil
+}
+
+// subGroup returns the group under the channel group of conf at path
+func subGroup(conf *cb.Config, path ...string) (*cb.ConfigGroup, error) {
+	group := conf.ChannelGroup
+	for i, name := range path {
+		subGroup, ok := group.Groups[name]
+		if !ok {
+			return nil, fmt.Errorf("config has no %v group", path[:i+1])
+		}
+		group = subGroup
+	}
+	return group, nil
+}
+
+// OrgMSPConfig returns the config of the verifying MSP saved in mspDir, such
+// as the msp folder of an org written by cryptogen, under the ID mspID
+func OrgMSPConfig(mspDir, mspID string) (*mspprotos.MSPConfig, error) {
+	mspConf, err := msp.GetVerifyingMspConfig(mspDir, mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure the MSP can be set up before it goes into the config
+	orgMSP, err := msp.NewBccspMsp()
+	if err != nil {
+		return nil, err
+	}
+	err = orgMSP.Setup(mspConf)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up MSP %s: %s", mspID, err)
+	}
+
+	return mspConf, nil
+}
+
+// AddOrg adds an org named name with the MSP mspConf to the application
+// group of conf, with the Readers and Writers policies satisfied by its
+// members and the Admins policy by its admins, like configtxgen does
+func AddOrg(conf *cb.Config, name string, mspConf *mspprotos.MSPConfig) error {
+	application, err := subGroup(conf, config.ApplicationGroupKey)
+	if err != nil {
+		return err
+	}
+	if _, ok := application.Groups[name]; ok {
+		return fmt.Errorf("org %s already exists", name)
+	}
+
+	fabricConf := &mspprotos.FabricMSPConfig{}
+	err = proto.Unmarshal(mspConf.Config, fabricConf)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling MSP config: %s", err)
+	}
+	mspID := fabricConf.Name
+
+	signaturePolicy := func(env *cb.SignaturePolicyEnvelope) *cb.ConfigPolicy {
+		return &cb.ConfigPolicy{
+			ModPolicy: mspconfig.AdminsPolicyKey,
+			Policy: &cb.Policy{
+				Type:  int32(cb.Policy_SIGNATURE),
+				Value: utils.MarshalOrPanic(env),
+			},
+		}
+	}
+
+	org := cb.NewConfigGroup()
+	org.ModPolicy = mspconfig.AdminsPolicyKey
+	org.Values[mspconfig.MSPKey] = &cb.ConfigValue{
+		ModPolicy: mspconfig.AdminsPolicyKey,
+		Value:     utils.MarshalOrPanic(mspConf),
+	}
+	org.Policies[mspconfig.AdminsPolicyKey] = signaturePolicy(cauthdsl.SignedByMspAdmin(mspID))
+	org.Policies[mspconfig.ReadersPolicyKey] = signaturePolicy(cauthdsl.SignedByMspMember(mspID))
+	org.Policies[mspconfig.WritersPolicyKey] = signaturePolicy(cauthdsl.SignedByMspMember(mspID))
+
+	if application.Groups == nil {
+		application.Groups = make(map[string]*cb.ConfigGroup)
+	}
+	application.Groups[name] = org
+	return nil
+}
+
+// RemoveOrg removes the org named name from the application group of conf
+func RemoveOrg(conf *cb.Config, name string) error {
+	application, err := subGroup(conf, config.ApplicationGroupKey)
+	if err != nil {
+		return err
+	}
+	if _, ok := application.Groups[name]; !ok {
+		return fmt.Errorf("org %s does not exist", name)
+	}
+
+	delete(application.Groups, name)
+	return nil
+}
+
+// SetBatchSize sets the batch size of the orderer group of conf. Limits
+// which are 0 are left unchanged.
+func SetBatchSize(conf *cb.Config, maxMessageCount, absoluteMaxBytes, preferredMaxBytes uint32) error {
+	orderer, err := subGroup(conf, config.OrdererGroupKey)
+	if err != nil {
+		return err
+	}
+	value, ok := orderer.Values[config.BatchSizeKey]
+	if !ok {
+		return fmt.Errorf("orderer group has no %s value", config.BatchSizeKey)
+	}
+
+	batchSize := &ab.BatchSize{}
+	err = proto.Unmarshal(value.Value, batchSize)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling %s: %s", config.BatchSizeKey, err)
+	}
+
+	if maxMessageCount != 0 {
+		batchSize.MaxMessageCount = maxMessageCount
+	}
+	if absoluteMaxBytes != 0 {
+		batchSize.AbsoluteMaxBytes = absoluteMaxBytes
+	}
+	if preferredMaxBytes != 0 {
+		batchSize.PreferredMaxBytes = preferredMaxBytes
+	}
+	if batchSize.PreferredMaxBytes > batchSize.AbsoluteMaxBytes {
+		return fmt.Errorf("preferred max bytes %d is greater than absolute max bytes %d",
+			batchSize.PreferredMaxBytes, batchSize.AbsoluteMaxBytes)
+	}
+
+	value.Value = utils.MarshalOrPanic(batchSize)
+	return nil
+}
+
+// SetBatchTimeout sets the batch timeout of the orderer group of conf
+func SetBatchTimeout(conf *cb.Config, timeout string) error {
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return fmt.Errorf("invalid batch timeout %s: %s", timeout, err)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("batch timeout must be positive, not %s", timeout)
+	}
+
+	orderer, err := subGroup(conf, config.OrdererGroupKey)
+	if err != nil {
+		return err
+	}
+	value, ok := orderer.Values[config.BatchTimeoutKey]
+	if !ok {
+		return fmt.Errorf("orderer group has no %s value", config.BatchTimeoutKey)
+	}
+
+	value.Value = utils.MarshalOrPanic(&ab.BatchTimeout{Timeout: timeout})
+	return nil
+}
+
+// ParseAnchorPeer parses an anchor peer given as host:port
+func ParseAnchorPeer(address string) (*pb.AnchorPeer, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid anchor peer %s: %s", address, err)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port in anchor peer %s", address)
+	}
+	return &pb.AnchorPeer{Host: host, Port: int32(port)}, nil
+}
+
+// SetAnchorPeers sets the anchor peers of the application org named org of
+// conf. With no anchor peers, the anchor peers of the org are removed.
+func SetAnchorPeers(conf *cb.Config, org string, anchorPeers []*pb.AnchorPeer) error {
+	orgGroup, err := subGroup(conf, config.ApplicationGroupKey, org)
+	if err != nil {
+		return err
+	}
+
+	if len(anchorPeers) == 0 {
+		delete(orgGroup.Values, config.AnchorPeersKey)
+		return nil
+	}
+
+	value, ok := orgGroup.Values[config.AnchorPeersKey]
+	if !ok {
+		value = &cb.ConfigValue{ModPolicy: mspconfig.AdminsPolicyKey}
+		if orgGroup.Values == nil {
+			orgGroup.Values = make(map[string]*cb.ConfigValue)
+		}
+		orgGroup.Values[config.AnchorPeersKey] = value
+	}
+	value.Value = utils.MarshalOrPanic(&pb.AnchorPeers{AnchorPeers: anchorPeers})
+	return nil
+}
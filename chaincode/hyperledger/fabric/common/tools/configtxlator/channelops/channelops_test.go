@@ -0,0 +1,223 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package channelops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/config"
+	mspconfig "github.com/hyperledger/fabric/common/config/msp"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/ca"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
+	cryptomsp "github.com/hyperledger/fabric/common/tools/cryptogen/msp"
+	cb "github.com/hyperledger/fabric/protos/common"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func testBlock(t *testing.T) *cb.Block {
+	channelGroup := cb.NewConfigGroup()
+	channelGroup.ModPolicy = mspconfig.AdminsPolicyKey
+
+	orderer := cb.NewConfigGroup()
+	orderer.ModPolicy = mspconfig.AdminsPolicyKey
+	orderer.Values[config.BatchSizeKey] = &cb.ConfigValue{
+		ModPolicy: mspconfig.AdminsPolicyKey,
+		Value: utils.MarshalOrPanic(&ab.BatchSize{
+			MaxMessageCount:   10,
+			AbsoluteMaxBytes:  99 * 1024 * 1024,
+			PreferredMaxBytes: 512 * 1024,
+		}),
+	}
+	orderer.Values[config.BatchTimeoutKey] = &cb.ConfigValue{
+		ModPolicy: mspconfig.AdminsPolicyKey,
+		Value:     utils.MarshalOrPanic(&ab.BatchTimeout{Timeout: "2s"}),
+	}
+	channelGroup.Groups[config.OrdererGroupKey] = orderer
+
+	application := cb.NewConfigGroup()
+	application.ModPolicy = mspconfig.AdminsPolicyKey
+	org1 := cb.NewConfigGroup()
+	org1.ModPolicy = mspconfig.AdminsPolicyKey
+	application.Groups["Org1MSP"] = org1
+	channelGroup.Groups[config.ApplicationGroupKey] = application
+
+	env, err := utils.CreateSignedEnvelope(cb.HeaderType_CONFIG, "foochannel", nil,
+		&cb.ConfigEnvelope{Config: &cb.Config{ChannelGroup: channelGroup}}, 0, 0)
+	assert.NoError(t, err)
+
+	block := cb.NewBlock(0, nil)
+	block.Data.Data = [][]byte{utils.MarshalOrPanic(env)}
+	return block
+}
+
+func TestConfigFromBlock(t *testing.T) {
+	conf, channelID, err := ConfigFromBlock(testBlock(t))
+	assert.NoError(t, err)
+	assert.Equal(t, "foochannel", channelID)
+	assert.Contains(t, conf.ChannelGroup.Groups, config.OrdererGroupKey)
+
+	env, err := utils.CreateSignedEnvelope(cb.HeaderType_ENDORSER_TRANSACTION, "foochannel", nil, &cb.ConfigEnvelope{}, 0, 0)
+	assert.NoError(t, err)
+	block := cb.NewBlock(1, nil)
+	block.Data.Data = [][]byte{utils.MarshalOrPanic(env)}
+	_, _, err = ConfigFromBlock(block)
+	assert.Error(t, err)
+
+	_, _, err = ConfigFromBlock(cb.NewBlock(1, nil))
+	assert.Error(t, err)
+}
+
+func TestAddRemoveOrg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "channelops")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	signCA, err := ca.NewCA(filepath.Join(dir, "ca"), "org2.example.com", "ca.org2.example.com",
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err)
+	tlsCA, err := ca.NewCA(filepath.Join(dir, "tlsca"), "org2.example.com", "tlsca.org2.example.com",
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err)
+	mspDir := filepath.Join(dir, "msp")
+	err = cryptomsp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, false)
+	assert.NoError(t, err)
+	// a verifying MSP needs an admin certificate
+	err = cryptomsp.GenerateLocalMSP(filepath.Join(dir, "admin"), "Admin@org2.example.com", nil,
+		signCA, tlsCA, cryptomsp.CLIENT, false, csp.DefaultKeyAlgorithm, ca.CertOptions{})
+	assert.NoError(t, err)
+	adminCert, err := ioutil.ReadFile(filepath.Join(dir, "admin", "msp", "signcerts", "Admin@org2.example.com-cert.pem"))
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(mspDir, "admincerts", "Admin@org2.example.com-cert.pem"), adminCert, 0644)
+	assert.NoError(t, err)
+
+	mspConf, err := OrgMSPConfig(mspDir, "Org2MSP")
+	assert.NoError(t, err)
+
+	_, err = OrgMSPConfig(filepath.Join(dir, "missing"), "Org2MSP")
+	assert.Error(t, err)
+
+	cu, err := ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return AddOrg(conf, "Org2MSP", mspConf)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "foochannel", cu.ChannelId)
+	application := cu.WriteSet.Groups[config.ApplicationGroupKey]
+	assert.Equal(t, uint64(1), application.Version)
+	org2 := application.Groups["Org2MSP"]
+	if assert.NotNil(t, org2) {
+		assert.Equal(t, mspconfig.AdminsPolicyKey, org2.ModPolicy)
+		assert.Equal(t, utils.MarshalOrPanic(mspConf), org2.Values[mspconfig.MSPKey].Value)
+		assert.Len(t, org2.Policies, 3)
+	}
+
+	_, err = ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return AddOrg(conf, "Org1MSP", mspConf)
+	})
+	assert.EqualError(t, err, "org Org1MSP already exists")
+
+	cu, err = ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return RemoveOrg(conf, "Org1MSP")
+	})
+	assert.NoError(t, err)
+	application = cu.WriteSet.Groups[config.ApplicationGroupKey]
+	assert.Equal(t, uint64(1), application.Version)
+	assert.NotContains(t, application.Groups, "Org1MSP")
+
+	_, err = ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return RemoveOrg(conf, "Org2MSP")
+	})
+	assert.EqualError(t, err, "org Org2MSP does not exist")
+}
+
+func TestBatchSettings(t *testing.T) {
+	cu, err := ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return SetBatchSize(conf, 20, 0, 0)
+	})
+	assert.NoError(t, err)
+	value := cu.WriteSet.Groups[config.OrdererGroupKey].Values[config.BatchSizeKey]
+	assert.Equal(t, uint64(1), value.Version)
+	batchSize := &ab.BatchSize{}
+	assert.NoError(t, proto.Unmarshal(value.Value, batchSize))
+	assert.Equal(t, &ab.BatchSize{
+		MaxMessageCount:   20,
+		AbsoluteMaxBytes:  99 * 1024 * 1024,
+		PreferredMaxBytes: 512 * 1024,
+	}, batchSize)
+
+	_, err = ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return SetBatchSize(conf, 0, 1024, 0)
+	})
+	assert.Error(t, err)
+
+	cu, err = ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return SetBatchTimeout(conf, "500ms")
+	})
+	assert.NoError(t, err)
+	value = cu.WriteSet.Groups[config.OrdererGroupKey].Values[config.BatchTimeoutKey]
+	batchTimeout := &ab.BatchTimeout{}
+	assert.NoError(t, proto.Unmarshal(value.Value, batchTimeout))
+	assert.Equal(t, "500ms", batchTimeout.Timeout)
+
+	for _, timeout := range []string{"soon", "-1s", "0s"} {
+		_, err = ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+			return SetBatchTimeout(conf, timeout)
+		})
+		assert.Error(t, err, timeout)
+	}
+
+	// nothing changes
+	_, err = ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return SetBatchTimeout(conf, "2s")
+	})
+	assert.Error(t, err)
+}
+
+func TestAnchorPeers(t *testing.T) {
+	anchorPeer, err := ParseAnchorPeer("peer0.org1.example.com:7051")
+	assert.NoError(t, err)
+	assert.Equal(t, &pb.AnchorPeer{Host: "peer0.org1.example.com", Port: 7051}, anchorPeer)
+
+	for _, address := range []string{"peer0", "peer0:port", "peer0:0", "peer0:70000"} {
+		_, err = ParseAnchorPeer(address)
+		assert.Error(t, err, address)
+	}
+
+	cu, err := ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return SetAnchorPeers(conf, "Org1MSP", []*pb.AnchorPeer{anchorPeer})
+	})
+	assert.NoError(t, err)
+	org1 := cu.WriteSet.Groups[config.ApplicationGroupKey].Groups["Org1MSP"]
+	assert.Equal(t, uint64(1), org1.Version)
+	value := org1.Values[config.AnchorPeersKey]
+	assert.Equal(t, mspconfig.AdminsPolicyKey, value.ModPolicy)
+	anchorPeers := &pb.AnchorPeers{}
+	assert.NoError(t, proto.Unmarshal(value.Value, anchorPeers))
+	assert.Equal(t, []*pb.AnchorPeer{anchorPeer}, anchorPeers.AnchorPeers)
+
+	_, err = ComputeUpdate(testBlock(t), func(conf *cb.Config) error {
+		return SetAnchorPeers(conf, "Org2MSP", []*pb.AnchorPeer{anchorPeer})
+	})
+	assert.Error(t, err)
+}
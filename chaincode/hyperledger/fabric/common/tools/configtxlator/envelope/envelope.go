@@ -0,0 +1,342 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package envelope
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/common/configtx"
+	"github.com/hyperledger/fabric/common/policies"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/msp"
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/utils"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Wrap returns an unsigned CONFIG_UPDATE envelope for the channel channelID
+// carrying update. The channel ID of update is set to channelID, unless
+// channelID is empty in which case the channel ID of update is used.
+func Wrap(update *cb.ConfigUpdate, channelID string) (*cb.Envelope, error) {
+	if channelID == "" {
+		channelID = update.ChannelId
+	}
+	if channelID == "" {
+		return nil, fmt.Errorf("no channel ID given and none set in the config update")
+	}
+	if update.ChannelId != "" && update.ChannelId != channelID {
+		return nil, fmt.Errorf("config update is for channel %s, not %s", update.ChannelId, channelID)
+	}
+	update.ChannelId = channelID
+
+	updateBytes, err := proto.Marshal(update)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling config update: %s", err)
+	}
+
+	return utils.CreateSignedEnvelope(cb.HeaderType_CONFIG_UPDATE, channelID, nil, &cb.ConfigUpdateEnvelope{
+		ConfigUpdate: updateBytes,
+	}, 0, 0)
+}
+
+// Unwrap returns the ConfigUpdateEnvelope carried by env and the channel
+// header of env
+func Unwrap(env *cb.Envelope) (*cb.ConfigUpdateEnvelope, *cb.ChannelHeader, error) {
+	payload, err := utils.UnmarshalPayload(env.Payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error unmarshaling payload: %s", err)
+	}
+	if payload.Header == nil {
+		return nil, nil, fmt.Errorf("payload has no header")
+	}
+
+	chdr, err := utils.UnmarshalChannelHeader(payload.Header.ChannelHeader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error unmarshaling channel header: %s", err)
+	}
+	if chdr.Type != int32(cb.HeaderType_CONFIG_UPDATE) {
+		return nil, nil, fmt.Errorf("envelope is of type %s, not %s", cb.HeaderType(chdr.Type), cb.HeaderType_CONFIG_UPDATE)
+	}
+
+	configUpdateEnv, err := configtx.UnmarshalConfigUpdateEnvelope(payload.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return configUpdateEnv, chdr, nil
+}
+
+// rewrap returns an unsigned envelope with the channel header chdr carrying
+// configUpdateEnv
+func rewrap(configUpdateEnv *cb.ConfigUpdateEnvelope, chdr *cb.ChannelHeader) (*cb.Envelope, error) {
+	return utils.CreateSignedEnvelope(cb.HeaderType_CONFIG_UPDATE, chdr.ChannelId, nil, configUpdateEnv, chdr.Version, chdr.Epoch)
+}
+
+// LoadSigningIdentity returns the default signing identity of the local MSP
+// saved in mspDir, as written by cryptogen, under the ID mspID. The BCCSP
+// factories are initialized once per process, so only the keystore of the
+// first MSP loaded is used.
+func LoadSigningIdentity(mspDir, mspID string) (msp.SigningIdentity, error) {
+	conf, err := msp.GetLocalMspConfig(mspDir, nil, mspID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading MSP from %s: %s", mspDir, err)
+	}
+
+	localMSP, err := msp.NewBccspMsp()
+	if err != nil {
+		return nil, err
+	}
+	err = localMSP.Setup(conf)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up MSP from %s: %s", mspDir, err)
+	}
+
+	return localMSP.GetDefaultSigningIdentity()
+}
+
+// Sign returns env with a ConfigSignature of signer added to its
+// ConfigUpdateEnvelope. The returned envelope itself is unsigned, it is
+// signed by whoever submits it.
+func Sign(env *cb.Envelope, signer msp.SigningIdentity) (*cb.Envelope, error) {
+	configUpdateEnv, chdr, err := Unwrap(env)
+	if err != nil {
+		return nil, err
+	}
+
+	creator, err := signer.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("error serializing signing identity: %s", err)
+	}
+	nonce, err := utils.CreateNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	configSig := &cb.ConfigSignature{
+		SignatureHeader: utils.MarshalOrPanic(utils.MakeSignatureHeader(creator, nonce)),
+	}
+	configSig.Signature, err = signer.Sign(util.ConcatenateBytes(configSig.SignatureHeader, configUpdateEnv.ConfigUpdate))
+	if err != nil {
+		return nil, fmt.Errorf("error signing config update: %s", err)
+	}
+
+	configUpdateEnv.Signatures = append(configUpdateEnv.Signatures, configSig)
+
+	return rewrap(configUpdateEnv, chdr)
+}
+
+// Merge returns an unsigned envelope carrying the config update of envs with
+// the signatures of all of envs. All of envs must carry the same config
+// update for the same channel.
+func Merge(envs ...*cb.Envelope) (*cb.Envelope, error) {
+	if len(envs) == 0 {
+		return nil, fmt.Errorf("no envelopes to merge")
+	}
+
+	merged, chdr, err := Unwrap(envs[0])
+	if err != nil {
+		return nil, fmt.Errorf("error with envelope 0: %s", err)
+	}
+	signatures := merged.Signatures
+	merged.Signatures = nil
+
+	for i, env := range envs[1:] {
+		configUpdateEnv, envChdr, err := Unwrap(env)
+		if err != nil {
+			return nil, fmt.Errorf("error with envelope %d: %s", i+1, err)
+		}
+		if envChdr.ChannelId != chdr.ChannelId {
+			return nil, fmt.Errorf("envelope %d is for channel %s, not %s", i+1, envChdr.ChannelId, chdr.ChannelId)
+		}
+		if !bytes.Equal(configUpdateEnv.ConfigUpdate, merged.ConfigUpdate) {
+			return nil, fmt.Errorf("envelope %d carries a different config update", i+1)
+		}
+		signatures = append(signatures, configUpdateEnv.Signatures...)
+	}
+
+	// the same signature may have been collected in several envelopes
+	for _, signature := range signatures {
+		duplicate := false
+		for _, kept := range merged.Signatures {
+			if proto.Equal(signature, kept) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged.Signatures = append(merged.Signatures, signature)
+		}
+	}
+
+	return rewrap(merged, chdr)
+}
+
+// MissingSignature is a modified element of a config update whose
+// modification policy is not satisfied by the signatures of the update
+type MissingSignature struct {
+	Path      string `json:"path"`
+	ModPolicy string `json:"mod_policy"`
+	Message   string `json:"message"`
+}
+
+// MissingSignatures returns the elements modified by the config update which
+// env carries whose modification policy in config is not satisfied by the
+// signatures collected so far. Elements which do not exist in config yet are
+// governed by the policy of the group they are added to.
+func MissingSignatures(config *cb.Config, env *cb.Envelope) ([]*MissingSignature, error) {
+	configUpdateEnv, chdr, err := Unwrap(env)
+	if err != nil {
+		return nil, err
+	}
+
+	configUpdate, err := configtx.UnmarshalConfigUpdate(configUpdateEnv.ConfigUpdate)
+	if err != nil {
+		return nil, err
+	}
+	if configUpdate.WriteSet == nil {
+		return nil, fmt.Errorf("config update has no write set")
+	}
+	if config.ChannelGroup == nil {
+		return nil, fmt.Errorf("config has no channel group")
+	}
+
+	signedData, err := configUpdateEnv.AsSignedData()
+	if err != nil {
+		return nil, fmt.Errorf("error reading signatures: %s", err)
+	}
+
+	envConfig, err := utils.CreateSignedEnvelope(cb.HeaderType_CONFIG, chdr.ChannelId, nil, &cb.ConfigEnvelope{Config: config}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := configtx.NewManagerImpl(envConfig, configtx.NewInitializer(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error processing config: %s", err)
+	}
+
+	c := &missingSignatures{
+		policyManager: cm.PolicyManager(),
+		signedData:    signedData,
+	}
+	c.checkGroup("channel_group", nil, configUpdate.ReadSet, configUpdate.WriteSet, config.ChannelGroup)
+
+	sort.Slice(c.missing, func(i, j int) bool {
+		return c.missing[i].Path < c.missing[j].Path
+	})
+	return c.missing, nil
+}
+
+type missingSignatures struct {
+	policyManager policies.Manager
+	signedData    []*cb.SignedData
+	missing       []*MissingSignature
+}
+
+// checkGroup checks the elements of the write set group writeGroup, found
+// under path in the config, and reads readGroup from the read set and
+// existing from the config, both of which may be nil. policyPath is the
+// path to the policy manager of the group, relative to the channel group.
+func (c *missingSignatures) checkGroup(path string, policyPath []string, readGroup, writeGroup, existing *cb.ConfigGroup) {
+	if existing != nil && (readGroup == nil || readGroup.Version != writeGroup.Version) {
+		c.check(path, policyPath, existing.ModPolicy)
+	}
+
+	for key, value := range writeGroup.Values {
+		var read *cb.ConfigValue
+		if readGroup != nil {
+			read = readGroup.Values[key]
+		}
+		var current *cb.ConfigValue
+		if existing != nil {
+			current = existing.Values[key]
+		}
+		if current != nil && (read == nil || read.Version != value.Version) {
+			c.check(path+".values."+key, policyPath, current.ModPolicy)
+		}
+	}
+
+	for key, policy := range writeGroup.Policies {
+		var read *cb.ConfigPolicy
+		if readGroup != nil {
+			read = readGroup.Policies[key]
+		}
+		var current *cb.ConfigPolicy
+		if existing != nil {
+			current = existing.Policies[key]
+		}
+		if current != nil && (read == nil || read.Version != policy.Version) {
+			c.check(path+".policies."+key, policyPath, current.ModPolicy)
+		}
+	}
+
+	for key, group := range writeGroup.Groups {
+		var read *cb.ConfigGroup
+		if readGroup != nil {
+			read = readGroup.Groups[key]
+		}
+		var current *cb.ConfigGroup
+		if existing != nil {
+			current = existing.Groups[key]
+		}
+		subPolicyPath := append(append([]string{}, policyPath...), key)
+		c.checkGroup(path+".groups."+key, subPolicyPath, read, group, current)
+	}
+}
+
+// check evaluates the policy modPolicy, relative to the group at policyPath,
+// against the signatures and records the element at path if it fails
+func (c *missingSignatures) check(path string, policyPath []string, modPolicy string) {
+	if modPolicy == "" {
+		c.missing = append(c.missing, &MissingSignature{
+			Path:    path,
+			Message: "no mod_policy set, the element cannot be modified",
+		})
+		return
+	}
+
+	manager, ok := c.policyManager.Manager(policyPath)
+	if !ok {
+		c.missing = append(c.missing, &MissingSignature{
+			Path:      path,
+			ModPolicy: modPolicy,
+			Message:   "no policies found for the group",
+		})
+		return
+	}
+
+	policy, ok := manager.GetPolicy(modPolicy)
+	if !ok {
+		c.missing = append(c.missing, &MissingSignature{
+			Path:      path,
+			ModPolicy: modPolicy,
+			Message:   "mod_policy does not exist",
+		})
+		return
+	}
+
+	err := policy.Evaluate(c.signedData)
+	if err != nil {
+		c.missing = append(c.missing, &MissingSignature{
+			Path:      path,
+			ModPolicy: modPolicy,
+			Message:   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package envelope
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/config"
+	mspconfig "github.com/hyperledger/fabric/common/config/msp"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/ca"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
+	cryptomsp "github.com/hyperledger/fabric/common/tools/cryptogen/msp"
+	"github.com/hyperledger/fabric/msp"
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMSPID = "Org1MSP"
+
+// generateAdmin writes the local MSP of an admin of a fresh org to a
+// temporary directory and returns the directory of the MSP
+func generateAdmin(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "envelope")
+	assert.NoError(t, err)
+
+	signCA, err := ca.NewCA(filepath.Join(dir, "ca"), "org1.example.com", "ca.org1.example.com",
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err)
+	tlsCA, err := ca.NewCA(filepath.Join(dir, "tlsca"), "org1.example.com", "tlsca.org1.example.com",
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err)
+
+	// the signing certificate of a local MSP is its admin certificate too
+	err = cryptomsp.GenerateLocalMSP(filepath.Join(dir, "admin"), "Admin@org1.example.com", nil,
+		signCA, tlsCA, cryptomsp.CLIENT, false, csp.DefaultKeyAlgorithm, ca.CertOptions{})
+	assert.NoError(t, err)
+
+	return filepath.Join(dir, "admin", "msp")
+}
+
+func testConfig(t *testing.T, mspDir string) *cb.Config {
+	mspConf, err := msp.GetVerifyingMspConfig(mspDir, testMSPID)
+	assert.NoError(t, err)
+
+	channelGroup := mspconfig.TemplateGroupMSP([]string{"Application", testMSPID}, mspConf)
+	channelGroup.Groups["Application"].Groups[testMSPID].ModPolicy = mspconfig.AdminsPolicyKey
+	for _, group := range []*cb.ConfigGroup{config.DefaultHashingAlgorithm(), config.DefaultBlockDataHashingStructure(), config.DefaultOrdererAddresses()} {
+		for key, value := range group.Values {
+			channelGroup.Values[key] = value
+		}
+	}
+	return &cb.Config{ChannelGroup: channelGroup}
+}
+
+func testUpdate(channelID string) *cb.ConfigUpdate {
+	readSet := cb.NewConfigGroup()
+	readSet.Groups["Application"] = cb.NewConfigGroup()
+	writeSet := cb.NewConfigGroup()
+	writeSet.Groups["Application"] = cb.NewConfigGroup()
+	writeSet.Groups["Application"].Groups[testMSPID] = &cb.ConfigGroup{
+		Version:   1,
+		ModPolicy: mspconfig.AdminsPolicyKey,
+	}
+	return &cb.ConfigUpdate{
+		ChannelId: channelID,
+		ReadSet:   readSet,
+		WriteSet:  writeSet,
+	}
+}
+
+func TestWrap(t *testing.T) {
+	env, err := Wrap(testUpdate(""), "foochannel")
+	assert.NoError(t, err)
+
+	configUpdateEnv, chdr, err := Unwrap(env)
+	assert.NoError(t, err)
+	assert.Equal(t, "foochannel", chdr.ChannelId)
+	assert.Empty(t, configUpdateEnv.Signatures)
+	assert.Equal(t, utils.MarshalOrPanic(testUpdate("foochannel")), configUpdateEnv.ConfigUpdate)
+
+	// the channel ID of the update is used if none is given
+	env, err = Wrap(testUpdate("barchannel"), "")
+	assert.NoError(t, err)
+	_, chdr, err = Unwrap(env)
+	assert.NoError(t, err)
+	assert.Equal(t, "barchannel", chdr.ChannelId)
+
+	_, err = Wrap(testUpdate(""), "")
+	assert.Error(t, err)
+	_, err = Wrap(testUpdate("barchannel"), "foochannel")
+	assert.Error(t, err)
+
+	_, _, err = Unwrap(&cb.Envelope{Payload: []byte("garbage")})
+	assert.Error(t, err)
+}
+
+func TestSignatures(t *testing.T) {
+	mspDir := generateAdmin(t)
+	defer os.RemoveAll(filepath.Dir(filepath.Dir(mspDir)))
+
+	conf := testConfig(t, mspDir)
+	env, err := Wrap(testUpdate(""), "foochannel")
+	assert.NoError(t, err)
+
+	// nobody signed yet
+	missing, err := MissingSignatures(conf, env)
+	assert.NoError(t, err)
+	if assert.Len(t, missing, 1) {
+		assert.Equal(t, "channel_group.groups.Application.groups."+testMSPID, missing[0].Path)
+		assert.Equal(t, mspconfig.AdminsPolicyKey, missing[0].ModPolicy)
+	}
+
+	signer, err := LoadSigningIdentity(mspDir, testMSPID)
+	assert.NoError(t, err)
+	signed, err := Sign(env, signer)
+	assert.NoError(t, err)
+
+	configUpdateEnv, _, err := Unwrap(signed)
+	assert.NoError(t, err)
+	assert.Len(t, configUpdateEnv.Signatures, 1)
+
+	missing, err = MissingSignatures(conf, signed)
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+
+	// a second signature collected separately is merged, the same one only
+	// once
+	signedAgain, err := Sign(env, signer)
+	assert.NoError(t, err)
+	merged, err := Merge(signed, signedAgain, signed, env)
+	assert.NoError(t, err)
+	configUpdateEnv, _, err = Unwrap(merged)
+	assert.NoError(t, err)
+	assert.Len(t, configUpdateEnv.Signatures, 2)
+
+	other, err := Wrap(testUpdate(""), "barchannel")
+	assert.NoError(t, err)
+	_, err = Merge(signed, other)
+	assert.Error(t, err)
+
+	otherUpdate := testUpdate("foochannel")
+	otherUpdate.WriteSet.Groups["Application"].Groups[testMSPID].ModPolicy = "Writers"
+	other, err = Wrap(otherUpdate, "")
+	assert.NoError(t, err)
+	_, err = Merge(signed, other)
+	assert.Error(t, err)
+
+	_, err = Merge()
+	assert.Error(t, err)
+}
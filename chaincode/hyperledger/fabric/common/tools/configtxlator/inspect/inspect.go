@@ -0,0 +1,432 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inspect decodes whole blocks and envelopes to JSON, including the
+// transactions which protolator cannot decode by itself, such as endorser
+// transactions with their proposal responses and read/write sets.
+package inspect
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/fabric/common/tools/protolator"
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/rwset"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	"github.com/golang/protobuf/proto"
+
+	// Import these to register the proto types
+	_ "github.com/hyperledger/fabric/protos/orderer"
+)
+
+// Block writes the JSON form of block to w, with the envelopes of its data
+// and its metadata decoded according to their types
+func Block(w io.Writer, block *cb.Block) error {
+	tree, err := blockTree(block)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, tree)
+}
+
+// Envelope writes the JSON form of env to w, with its payload decoded
+// according to the type in its channel header
+func Envelope(w io.Writer, env *cb.Envelope) error {
+	tree, err := envelopeTree(env)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, tree)
+}
+
+// Message writes the JSON form of msg, a common.Block or a common.Envelope,
+// to w
+func Message(w io.Writer, msg proto.Message) error {
+	switch msg := msg.(type) {
+	case *cb.Block:
+		return Block(w, msg)
+	case *cb.Envelope:
+		return Envelope(w, msg)
+	default:
+		return fmt.Errorf("cannot inspect messages of type %s, only %s and %s", proto.MessageName(msg),
+			proto.MessageName(&cb.Block{}), proto.MessageName(&cb.Envelope{}))
+	}
+}
+
+func writeJSON(w io.Writer, tree interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(tree)
+}
+
+// decoder returns the JSON form of the marshaled message data
+type decoder func(data []byte) (interface{}, error)
+
+// message returns the decoder of messages which protolator decodes as msg
+func message(msg proto.Message) decoder {
+	return func(data []byte) (interface{}, error) {
+		err := proto.Unmarshal(data, msg)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling %s: %s", proto.MessageName(msg), err)
+		}
+		return messageTree(msg)
+	}
+}
+
+// messageTree returns the protolator JSON form of msg decoded into maps,
+// slices and json.Numbers
+func messageTree(msg proto.Message) (map[string]interface{}, error) {
+	var buffer bytes.Buffer
+	err := protolator.DeepMarshalJSON(&buffer, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(&buffer)
+	decoder.UseNumber()
+	tree := map[string]interface{}{}
+	err = decoder.Decode(&tree)
+	return tree, err
+}
+
+// setField sets the field name of tree to the JSON form of the marshaled
+// message data, unless data is empty
+func setField(tree map[string]interface{}, name string, data []byte, decode decoder) error {
+	if len(data) == 0 {
+		return nil
+	}
+	value, err := decode(data)
+	if err != nil {
+		return fmt.Errorf("error decoding %s: %s", name, err)
+	}
+	tree[name] = value
+	return nil
+}
+
+func blockTree(block *cb.Block) (map[string]interface{}, error) {
+	tree, err := messageTree(&cb.Block{Header: block.Header})
+	if err != nil {
+		return nil, err
+	}
+
+	envelopes := []interface{}{}
+	for i, data := range block.GetData().GetData() {
+		env := &cb.Envelope{}
+		err = proto.Unmarshal(data, env)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling envelope %d: %s", i, err)
+		}
+		envTree, err := envelopeTree(env)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding envelope %d: %s", i, err)
+		}
+		envelopes = append(envelopes, envTree)
+	}
+	tree["data"] = map[string]interface{}{"data": envelopes}
+
+	metadata := []interface{}{}
+	for i, data := range block.GetMetadata().GetMetadata() {
+		metadataTree, err := blockMetadataTree(cb.BlockMetadataIndex(i), data)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s metadata: %s", cb.BlockMetadataIndex(i), err)
+		}
+		metadata = append(metadata, metadataTree)
+	}
+	tree["metadata"] = map[string]interface{}{"metadata": metadata}
+
+	return tree, nil
+}
+
+// blockMetadataTree returns the JSON form of the block metadata data at index
+func blockMetadataTree(index cb.BlockMetadataIndex, data []byte) (interface{}, error) {
+	switch index {
+	case cb.BlockMetadataIndex_TRANSACTIONS_FILTER:
+		// one validation code per transaction
+		codes := []interface{}{}
+		for _, code := range data {
+			codes = append(codes, pb.TxValidationCode(code).String())
+		}
+		return codes, nil
+	case cb.BlockMetadataIndex_SIGNATURES, cb.BlockMetadataIndex_LAST_CONFIG, cb.BlockMetadataIndex_ORDERER:
+		if len(data) == 0 {
+			return map[string]interface{}{}, nil
+		}
+	default:
+		return base64.StdEncoding.EncodeToString(data), nil
+	}
+
+	metadata := &cb.Metadata{}
+	err := proto.Unmarshal(data, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling metadata: %s", err)
+	}
+	tree, err := messageTree(&cb.Metadata{Value: metadata.Value})
+	if err != nil {
+		return nil, err
+	}
+	if index == cb.BlockMetadataIndex_LAST_CONFIG {
+		err = setField(tree, "value", metadata.Value, message(&cb.LastConfig{}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signatures := []interface{}{}
+	for _, signature := range metadata.Signatures {
+		signatureTree, err := messageTree(&cb.MetadataSignature{Signature: signature.Signature})
+		if err != nil {
+			return nil, err
+		}
+		err = setField(signatureTree, "signature_header", signature.SignatureHeader, signatureHeaderTree)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, signatureTree)
+	}
+	if len(signatures) > 0 {
+		tree["signatures"] = signatures
+	}
+
+	return tree, nil
+}
+
+func envelopeTree(env *cb.Envelope) (map[string]interface{}, error) {
+	tree, err := messageTree(&cb.Envelope{Signature: env.Signature})
+	if err != nil {
+		return nil, err
+	}
+	err = setField(tree, "payload", env.Payload, payloadTree)
+	return tree, err
+}
+
+// payloadTree decodes the data of a payload according to the type in its
+// channel header, leaving it marshaled if the type is not known
+func payloadTree(data []byte) (interface{}, error) {
+	payload := &cb.Payload{}
+	err := proto.Unmarshal(data, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling payload: %s", err)
+	}
+	if payload.Header == nil {
+		return nil, fmt.Errorf("payload has no header")
+	}
+
+	chdr := &cb.ChannelHeader{}
+	err = proto.Unmarshal(payload.Header.ChannelHeader, chdr)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling channel header: %s", err)
+	}
+
+	header, err := messageTree(&cb.Header{ChannelHeader: payload.Header.ChannelHeader})
+	if err != nil {
+		return nil, err
+	}
+	err = setField(header, "signature_header", payload.Header.SignatureHeader, signatureHeaderTree)
+	if err != nil {
+		return nil, err
+	}
+
+	var dataDecoder decoder
+	switch cb.HeaderType(chdr.Type) {
+	case cb.HeaderType_CONFIG:
+		dataDecoder = message(&cb.ConfigEnvelope{})
+	case cb.HeaderType_CONFIG_UPDATE:
+		dataDecoder = message(&cb.ConfigUpdateEnvelope{})
+	case cb.HeaderType_ENDORSER_TRANSACTION:
+		dataDecoder = transactionTree
+	case cb.HeaderType_ORDERER_TRANSACTION:
+		// the data of an orderer transaction is the envelope it carries
+		dataDecoder = func(data []byte) (interface{}, error) {
+			env := &cb.Envelope{}
+			err := proto.Unmarshal(data, env)
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshaling envelope: %s", err)
+			}
+			return envelopeTree(env)
+		}
+	default:
+		dataDecoder = func(data []byte) (interface{}, error) {
+			return base64.StdEncoding.EncodeToString(data), nil
+		}
+	}
+
+	tree := map[string]interface{}{"header": header}
+	err = setField(tree, "data", payload.Data, dataDecoder)
+	return tree, err
+}
+
+// signatureHeaderTree decodes a signature header with the identity of its
+// creator
+func signatureHeaderTree(data []byte) (interface{}, error) {
+	header := &cb.SignatureHeader{}
+	err := proto.Unmarshal(data, header)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling signature header: %s", err)
+	}
+
+	tree, err := messageTree(&cb.SignatureHeader{Nonce: header.Nonce})
+	if err != nil {
+		return nil, err
+	}
+	err = setField(tree, "creator", header.Creator, message(&mspprotos.SerializedIdentity{}))
+	return tree, err
+}
+
+func transactionTree(data []byte) (interface{}, error) {
+	tx := &pb.Transaction{}
+	err := proto.Unmarshal(data, tx)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling transaction: %s", err)
+	}
+
+	actions := []interface{}{}
+	for i, action := range tx.Actions {
+		actionTree := map[string]interface{}{}
+		err = setField(actionTree, "header", action.Header, signatureHeaderTree)
+		if err == nil {
+			err = setField(actionTree, "payload", action.Payload, chaincodeActionPayloadTree)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding action %d: %s", i, err)
+		}
+		actions = append(actions, actionTree)
+	}
+
+	return map[string]interface{}{"actions": actions}, nil
+}
+
+func chaincodeActionPayloadTree(data []byte) (interface{}, error) {
+	payload := &pb.ChaincodeActionPayload{}
+	err := proto.Unmarshal(data, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling chaincode action payload: %s", err)
+	}
+
+	tree := map[string]interface{}{}
+	err = setField(tree, "chaincode_proposal_payload", payload.ChaincodeProposalPayload, chaincodeProposalPayloadTree)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Action == nil {
+		return tree, nil
+	}
+
+	action := map[string]interface{}{}
+	err = setField(action, "proposal_response_payload", payload.Action.ProposalResponsePayload, proposalResponsePayloadTree)
+	if err != nil {
+		return nil, err
+	}
+	endorsements := []interface{}{}
+	for _, endorsement := range payload.Action.Endorsements {
+		endorsementTree, err := messageTree(&pb.Endorsement{Signature: endorsement.Signature})
+		if err != nil {
+			return nil, err
+		}
+		err = setField(endorsementTree, "endorser", endorsement.Endorser, message(&mspprotos.SerializedIdentity{}))
+		if err != nil {
+			return nil, err
+		}
+		endorsements = append(endorsements, endorsementTree)
+	}
+	action["endorsements"] = endorsements
+	tree["action"] = action
+
+	return tree, nil
+}
+
+func chaincodeProposalPayloadTree(data []byte) (interface{}, error) {
+	payload := &pb.ChaincodeProposalPayload{}
+	err := proto.Unmarshal(data, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling chaincode proposal payload: %s", err)
+	}
+
+	tree, err := messageTree(&pb.ChaincodeProposalPayload{TransientMap: payload.TransientMap})
+	if err != nil {
+		return nil, err
+	}
+	err = setField(tree, "input", payload.Input, message(&pb.ChaincodeInvocationSpec{}))
+	return tree, err
+}
+
+func proposalResponsePayloadTree(data []byte) (interface{}, error) {
+	payload := &pb.ProposalResponsePayload{}
+	err := proto.Unmarshal(data, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling proposal response payload: %s", err)
+	}
+
+	tree, err := messageTree(&pb.ProposalResponsePayload{ProposalHash: payload.ProposalHash})
+	if err != nil {
+		return nil, err
+	}
+	err = setField(tree, "extension", payload.Extension, chaincodeActionTree)
+	return tree, err
+}
+
+func chaincodeActionTree(data []byte) (interface{}, error) {
+	action := &pb.ChaincodeAction{}
+	err := proto.Unmarshal(data, action)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling chaincode action: %s", err)
+	}
+
+	tree, err := messageTree(&pb.ChaincodeAction{Response: action.Response, ChaincodeId: action.ChaincodeId})
+	if err != nil {
+		return nil, err
+	}
+	err = setField(tree, "results", action.Results, txReadWriteSetTree)
+	if err != nil {
+		return nil, err
+	}
+	err = setField(tree, "events", action.Events, message(&pb.ChaincodeEvent{}))
+	return tree, err
+}
+
+func txReadWriteSetTree(data []byte) (interface{}, error) {
+	txRWSet := &rwset.TxReadWriteSet{}
+	err := proto.Unmarshal(data, txRWSet)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling read/write set: %s", err)
+	}
+
+	tree, err := messageTree(&rwset.TxReadWriteSet{DataModel: txRWSet.DataModel})
+	if err != nil {
+		return nil, err
+	}
+	nsRWSets := []interface{}{}
+	for _, nsRWSet := range txRWSet.NsRwset {
+		nsTree, err := messageTree(&rwset.NsReadWriteSet{Namespace: nsRWSet.Namespace})
+		if err != nil {
+			return nil, err
+		}
+		err = setField(nsTree, "rwset", nsRWSet.Rwset, message(&kvrwset.KVRWSet{}))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding namespace %s: %s", nsRWSet.Namespace, err)
+		}
+		nsRWSets = append(nsRWSets, nsTree)
+	}
+	tree["ns_rwset"] = nsRWSets
+
+	return tree, nil
+}
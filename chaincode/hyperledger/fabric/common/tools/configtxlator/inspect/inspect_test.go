@@ -0,0 +1,206 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/rwset"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func endorserTransaction() *cb.Envelope {
+	creator := utils.MarshalOrPanic(&mspprotos.SerializedIdentity{Mspid: "Org1MSP", IdBytes: []byte("cert")})
+
+	results := utils.MarshalOrPanic(&rwset.TxReadWriteSet{
+		DataModel: rwset.TxReadWriteSet_KV,
+		NsRwset: []*rwset.NsReadWriteSet{{
+			Namespace: "mycc",
+			Rwset: utils.MarshalOrPanic(&kvrwset.KVRWSet{
+				Reads:  []*kvrwset.KVRead{{Key: "a", Version: &kvrwset.Version{BlockNum: 3, TxNum: 1}}},
+				Writes: []*kvrwset.KVWrite{{Key: "a", Value: []byte("90")}},
+			}),
+		}},
+	})
+
+	payload := &pb.ChaincodeActionPayload{
+		ChaincodeProposalPayload: utils.MarshalOrPanic(&pb.ChaincodeProposalPayload{
+			Input: utils.MarshalOrPanic(&pb.ChaincodeInvocationSpec{
+				ChaincodeSpec: &pb.ChaincodeSpec{
+					ChaincodeId: &pb.ChaincodeID{Name: "mycc"},
+					Input:       &pb.ChaincodeInput{Args: [][]byte{[]byte("invoke")}},
+				},
+			}),
+		}),
+		Action: &pb.ChaincodeEndorsedAction{
+			ProposalResponsePayload: utils.MarshalOrPanic(&pb.ProposalResponsePayload{
+				ProposalHash: []byte("hash"),
+				Extension: utils.MarshalOrPanic(&pb.ChaincodeAction{
+					Results:     results,
+					Events:      utils.MarshalOrPanic(&pb.ChaincodeEvent{ChaincodeId: "mycc", EventName: "moved"}),
+					Response:    &pb.Response{Status: 200},
+					ChaincodeId: &pb.ChaincodeID{Name: "mycc", Version: "1.0"},
+				}),
+			}),
+			Endorsements: []*pb.Endorsement{{Endorser: creator, Signature: []byte("endorsement")}},
+		},
+	}
+
+	tx := &pb.Transaction{
+		Actions: []*pb.TransactionAction{{
+			Header:  utils.MarshalOrPanic(&cb.SignatureHeader{Creator: creator}),
+			Payload: utils.MarshalOrPanic(payload),
+		}},
+	}
+
+	return &cb.Envelope{
+		Payload: utils.MarshalOrPanic(&cb.Payload{
+			Header: &cb.Header{
+				ChannelHeader: utils.MarshalOrPanic(&cb.ChannelHeader{
+					Type:      int32(cb.HeaderType_ENDORSER_TRANSACTION),
+					ChannelId: "foochannel",
+					TxId:      "tx1",
+				}),
+				SignatureHeader: utils.MarshalOrPanic(&cb.SignatureHeader{Creator: creator}),
+			},
+			Data: utils.MarshalOrPanic(tx),
+		}),
+		Signature: []byte("signature"),
+	}
+}
+
+func inspectBlock(t *testing.T, block *cb.Block) map[string]interface{} {
+	var buffer bytes.Buffer
+	err := Block(&buffer, block)
+	assert.NoError(t, err)
+
+	tree := map[string]interface{}{}
+	err = json.Unmarshal(buffer.Bytes(), &tree)
+	assert.NoError(t, err)
+	return tree
+}
+
+// member returns the member of tree at the path of keys, which are strings
+// for objects and ints for arrays, or nil
+func member(tree interface{}, keys ...interface{}) interface{} {
+	for _, key := range keys {
+		switch key := key.(type) {
+		case string:
+			object, ok := tree.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			tree = object[key]
+		case int:
+			array, ok := tree.([]interface{})
+			if !ok || key >= len(array) {
+				return nil
+			}
+			tree = array[key]
+		}
+	}
+	return tree
+}
+
+func TestInspectEndorserTransaction(t *testing.T) {
+	block := cb.NewBlock(4, []byte("previous"))
+	block.Data.Data = [][]byte{utils.MarshalOrPanic(endorserTransaction())}
+	block.Metadata.Metadata[cb.BlockMetadataIndex_LAST_CONFIG] = utils.MarshalOrPanic(&cb.Metadata{
+		Value: utils.MarshalOrPanic(&cb.LastConfig{Index: 2}),
+	})
+	block.Metadata.Metadata[cb.BlockMetadataIndex_TRANSACTIONS_FILTER] = []byte{byte(pb.TxValidationCode_MVCC_READ_CONFLICT)}
+
+	tree := inspectBlock(t, block)
+	assert.Equal(t, "4", member(tree, "header", "number"))
+
+	payload := member(tree, "data", "data", 0, "payload")
+	assert.Equal(t, float64(cb.HeaderType_ENDORSER_TRANSACTION), member(payload, "header", "channel_header", "type"))
+	assert.Equal(t, "Org1MSP", member(payload, "header", "signature_header", "creator", "mspid"))
+
+	action := member(payload, "data", "actions", 0)
+	assert.Equal(t, "Org1MSP", member(action, "header", "creator", "mspid"))
+	assert.Equal(t, "mycc", member(action, "payload", "chaincode_proposal_payload", "input", "chaincode_spec", "chaincode_id", "name"))
+	assert.Equal(t, "Org1MSP", member(action, "payload", "action", "endorsements", 0, "endorser", "mspid"))
+
+	chaincodeAction := member(action, "payload", "action", "proposal_response_payload", "extension")
+	assert.Equal(t, "1.0", member(chaincodeAction, "chaincode_id", "version"))
+	assert.Equal(t, float64(200), member(chaincodeAction, "response", "status"))
+	assert.Equal(t, "moved", member(chaincodeAction, "events", "event_name"))
+
+	nsRWSet := member(chaincodeAction, "results", "ns_rwset", 0)
+	assert.Equal(t, "mycc", member(nsRWSet, "namespace"))
+	assert.Equal(t, "a", member(nsRWSet, "rwset", "reads", 0, "key"))
+	assert.Equal(t, "3", member(nsRWSet, "rwset", "reads", 0, "version", "block_num"))
+	assert.Equal(t, "OTA=", member(nsRWSet, "rwset", "writes", 0, "value"))
+
+	assert.Equal(t, "2", member(tree, "metadata", "metadata", int(cb.BlockMetadataIndex_LAST_CONFIG), "value", "index"))
+	assert.Equal(t, []interface{}{"MVCC_READ_CONFLICT"},
+		member(tree, "metadata", "metadata", int(cb.BlockMetadataIndex_TRANSACTIONS_FILTER)))
+}
+
+func TestInspectConfig(t *testing.T) {
+	configEnv, err := utils.CreateSignedEnvelope(cb.HeaderType_CONFIG, "foochannel", nil,
+		&cb.ConfigEnvelope{Config: &cb.Config{Sequence: 3, ChannelGroup: &cb.ConfigGroup{ModPolicy: "Admins"}}}, 0, 0)
+	assert.NoError(t, err)
+	// an orderer transaction carries the envelope of a channel creation
+	ordererEnv, err := utils.CreateSignedEnvelope(cb.HeaderType_ORDERER_TRANSACTION, "testchainid", nil, configEnv, 0, 0)
+	assert.NoError(t, err)
+
+	block := cb.NewBlock(0, nil)
+	block.Data.Data = [][]byte{utils.MarshalOrPanic(configEnv), utils.MarshalOrPanic(ordererEnv)}
+
+	tree := inspectBlock(t, block)
+	config := member(tree, "data", "data", 0, "payload", "data", "config")
+	assert.Equal(t, "3", member(config, "sequence"))
+	assert.Equal(t, "Admins", member(config, "channel_group", "mod_policy"))
+	assert.Equal(t, "Admins", member(tree, "data", "data", 1, "payload", "data", "payload", "data", "config", "channel_group", "mod_policy"))
+}
+
+func TestInspectEnvelope(t *testing.T) {
+	var buffer bytes.Buffer
+	err := Message(&buffer, endorserTransaction())
+	assert.NoError(t, err)
+	tree := map[string]interface{}{}
+	err = json.Unmarshal(buffer.Bytes(), &tree)
+	assert.NoError(t, err)
+	assert.Equal(t, "tx1", member(tree, "payload", "header", "channel_header", "tx_id"))
+
+	// unknown types are left marshaled
+	env, err := utils.CreateSignedEnvelope(cb.HeaderType_MESSAGE, "foochannel", nil, &cb.Envelope{Signature: []byte("bar")}, 0, 0)
+	assert.NoError(t, err)
+	buffer.Reset()
+	err = Envelope(&buffer, env)
+	assert.NoError(t, err)
+	tree = map[string]interface{}{}
+	err = json.Unmarshal(buffer.Bytes(), &tree)
+	assert.NoError(t, err)
+	assert.Equal(t, "EgNiYXI=", member(tree, "payload", "data"))
+
+	err = Envelope(&buffer, &cb.Envelope{Payload: []byte("garbage")})
+	assert.Error(t, err)
+
+	err = Message(&buffer, &cb.Config{})
+	assert.Error(t, err)
+}
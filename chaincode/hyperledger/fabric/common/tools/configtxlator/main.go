@@ -17,12 +17,37 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
 
+	"github.com/hyperledger/fabric/common/tools/configtxlator/channelops"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/envelope"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/inspect"
 	"github.com/hyperledger/fabric/common/tools/configtxlator/metadata"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/patch"
 	"github.com/hyperledger/fabric/common/tools/configtxlator/rest"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/sanitycheck"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/update"
+	"github.com/hyperledger/fabric/common/tools/protolator"
+	cb "github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	// Import these to register the proto types
+	_ "github.com/hyperledger/fabric/protos/msp"
+	_ "github.com/hyperledger/fabric/protos/orderer"
+
+	"github.com/golang/protobuf/proto"
 	"github.com/op/go-logging"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -33,9 +58,106 @@ var logger = logging.MustGetLogger("configtxlator")
 var (
 	app = kingpin.New("configtxlator", "Utility for generating Hyperledger Fabric channel configurations")
 
-	start    = app.Command("start", "Start the configtxlator REST server")
-	hostname = start.Flag("hostname", "The hostname or IP on which the REST server will listen").Default("0.0.0.0").String()
-	port     = start.Flag("port", "The port on which the REST server will listen").Default("7059").Int()
+	start           = app.Command("start", "Start the configtxlator REST server")
+	hostname        = start.Flag("hostname", "The hostname or IP on which the REST server will listen").Default("0.0.0.0").String()
+	port            = start.Flag("port", "The port on which the REST server will listen").Default("7059").Int()
+	tlsCert         = start.Flag("tls.cert", "The PEM encoded certificate of the server, which serves HTTPS if set").String()
+	tlsKey          = start.Flag("tls.key", "The PEM encoded private key of the server").String()
+	tlsCAs          = start.Flag("tls.clientCAs", "A PEM file, or a directory such as the tlscacerts of an MSP, of CA certificates issuing the accepted client certificates. Clients must present a certificate if set. May be repeated.").Strings()
+	maxBody         = start.Flag("max_body_bytes", "The maximum size of request bodies, larger requests being rejected").Default(fmt.Sprint(rest.DefaultMaxBodyBytes)).Int64()
+	shutdownTimeout = start.Flag("shutdown_timeout", "The time given to the requests in flight to complete on SIGTERM").Default("10s").Duration()
+
+	protoEncode       = app.Command("proto_encode", "Converts a JSON document to protobuf")
+	protoEncodeType   = protoEncode.Flag("type", "The type of protobuf structure to encode to, e.g. common.Config").Required().String()
+	protoEncodeSource = protoEncode.Flag("input", "A file containing the JSON document").Default(os.Stdin.Name()).File()
+	protoEncodeDest   = protoEncode.Flag("output", "A file to write the output to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	protoDecode       = app.Command("proto_decode", "Converts a proto message to JSON")
+	protoDecodeType   = protoDecode.Flag("type", "The type of protobuf structure to decode from, e.g. common.Config").Required().String()
+	protoDecodeSource = protoDecode.Flag("input", "A file containing the proto message").Default(os.Stdin.Name()).File()
+	protoDecodeDest   = protoDecode.Flag("output", "A file to write the JSON document to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	inspectProto       = app.Command("inspect", "Converts a marshaled common.Block or common.Envelope to JSON, decoding the payload of each envelope, including endorser transactions and their read/write sets, according to its header type")
+	inspectProtoType   = inspectProto.Flag("type", "The type of the message, common.Block or common.Envelope").Default("common.Block").Enum("common.Block", "common.Envelope")
+	inspectProtoSource = inspectProto.Flag("input", "A file containing the message").Default(os.Stdin.Name()).File()
+	inspectProtoDest   = inspectProto.Flag("output", "A file to write the JSON document to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	computeUpdate          = app.Command("compute_update", "Takes two marshaled common.Config messages and computes the config update which transitions between the two")
+	computeUpdateOriginal  = computeUpdate.Flag("original", "The original config message").Required().File()
+	computeUpdateUpdated   = computeUpdate.Flag("updated", "The updated config message").Required().File()
+	computeUpdateChannelID = computeUpdate.Flag("channel_id", "The name of the channel for this update").String()
+	computeUpdateDest      = computeUpdate.Flag("output", "A file to write the marshaled common.ConfigUpdate to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	patchUpdate          = app.Command("compute_update_from_patch", "Applies an RFC 6902 JSON Patch, or an RFC 7386 merge patch, to the JSON form of a common.Config and computes the config update which transitions to the patched config")
+	patchUpdateOriginal  = patchUpdate.Flag("original", "The original config message, marshaled or in JSON").Required().File()
+	patchUpdatePatch     = patchUpdate.Flag("patch", "The patch, a JSON array of operations for a JSON Patch, a JSON object for a merge patch").Required().File()
+	patchUpdateChannelID = patchUpdate.Flag("channel_id", "The name of the channel for this update").String()
+	patchUpdateDest      = patchUpdate.Flag("output", "A file to write the marshaled common.ConfigUpdate to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	applyUpdate         = app.Command("apply_update", "Applies a marshaled common.ConfigUpdate to a marshaled common.Config, with the version checks of the orderer, and outputs the resulting config")
+	applyUpdateOriginal = applyUpdate.Flag("original", "The original config message").Required().File()
+	applyUpdateUpdate   = applyUpdate.Flag("update", "The config update message").Required().File()
+	applyUpdateDest     = applyUpdate.Flag("output", "A file to write the marshaled common.Config to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	addOrg       = app.Command("add_org", "Computes the config update which adds an application org to the channel of a config block")
+	addOrgBlock  = addOrg.Flag("block", "The marshaled config block of the channel").Required().File()
+	addOrgMSPDir = addOrg.Flag("msp_dir", "The verifying MSP of the org, such as the msp folder of an org written by cryptogen").Required().ExistingDir()
+	addOrgMSPID  = addOrg.Flag("msp_id", "The MSP ID of the org").Required().String()
+	addOrgName   = addOrg.Flag("name", "The name of the group of the org, the MSP ID by default").String()
+	addOrgDest   = addOrg.Flag("output", "A file to write the marshaled common.ConfigUpdate to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	removeOrg      = app.Command("remove_org", "Computes the config update which removes an application org from the channel of a config block")
+	removeOrgBlock = removeOrg.Flag("block", "The marshaled config block of the channel").Required().File()
+	removeOrgName  = removeOrg.Flag("name", "The name of the group of the org").Required().String()
+	removeOrgDest  = removeOrg.Flag("output", "A file to write the marshaled common.ConfigUpdate to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	batchSize                  = app.Command("set_batch_size", "Computes the config update which changes the orderer batch size of the channel of a config block")
+	batchSizeBlock             = batchSize.Flag("block", "The marshaled config block of the channel").Required().File()
+	batchSizeMaxMessageCount   = batchSize.Flag("max_message_count", "The maximum number of messages in a batch, unchanged if 0").Uint32()
+	batchSizeAbsoluteMaxBytes  = batchSize.Flag("absolute_max_bytes", "The absolute maximum number of bytes of the messages in a batch, unchanged if 0").Uint32()
+	batchSizePreferredMaxBytes = batchSize.Flag("preferred_max_bytes", "The preferred maximum number of bytes of the messages in a batch, unchanged if 0").Uint32()
+	batchSizeDest              = batchSize.Flag("output", "A file to write the marshaled common.ConfigUpdate to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	batchTimeout        = app.Command("set_batch_timeout", "Computes the config update which changes the orderer batch timeout of the channel of a config block")
+	batchTimeoutBlock   = batchTimeout.Flag("block", "The marshaled config block of the channel").Required().File()
+	batchTimeoutTimeout = batchTimeout.Flag("timeout", "The batch timeout, such as 2s").Required().String()
+	batchTimeoutDest    = batchTimeout.Flag("output", "A file to write the marshaled common.ConfigUpdate to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	anchorPeers      = app.Command("set_anchor_peers", "Computes the config update which sets the anchor peers of an application org of the channel of a config block")
+	anchorPeersBlock = anchorPeers.Flag("block", "The marshaled config block of the channel").Required().File()
+	anchorPeersOrg   = anchorPeers.Flag("org", "The name of the group of the org").Required().String()
+	anchorPeersPeers = anchorPeers.Flag("anchor_peer", "The host:port of an anchor peer. May be repeated, none removes the anchor peers.").Strings()
+	anchorPeersDest  = anchorPeers.Flag("output", "A file to write the marshaled common.ConfigUpdate to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	diff         = app.Command("diff", "Compares two marshaled common.Config messages and lists the groups, values and policies which differ")
+	diffOriginal = diff.Flag("original", "The original config message").Required().File()
+	diffUpdated  = diff.Flag("updated", "The updated config message").Required().File()
+	diffFormat   = diff.Flag("format", "The format of the output, text or json, unlike the REST API which defaults to json").Default("text").Enum("text", "json")
+	diffDest     = diff.Flag("output", "A file to write the differences to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	wrapUpdate          = app.Command("wrap_update", "Wraps a marshaled common.ConfigUpdate in an unsigned CONFIG_UPDATE common.Envelope for a channel")
+	wrapUpdateSource    = wrapUpdate.Flag("input", "A file containing the config update").Default(os.Stdin.Name()).File()
+	wrapUpdateChannelID = wrapUpdate.Flag("channel_id", "The name of the channel, the channel of the config update by default").String()
+	wrapUpdateDest      = wrapUpdate.Flag("output", "A file to write the marshaled common.Envelope to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	signUpdate       = app.Command("sign_update", "Adds a signature of an MSP signing identity to the config update of a CONFIG_UPDATE common.Envelope")
+	signUpdateSource = signUpdate.Flag("input", "A file containing the envelope").Default(os.Stdin.Name()).File()
+	signUpdateMSPDir = signUpdate.Flag("msp_dir", "The local MSP of the signer, such as the msp folder of a user written by cryptogen").Required().ExistingDir()
+	signUpdateMSPID  = signUpdate.Flag("msp_id", "The MSP ID of the org of the signer").Required().String()
+	signUpdateDest   = signUpdate.Flag("output", "A file to write the marshaled common.Envelope to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	mergeSignatures       = app.Command("merge_signatures", "Merges the signatures of several CONFIG_UPDATE common.Envelope messages carrying the same config update")
+	mergeSignaturesSource = mergeSignatures.Flag("input", "A file containing an envelope. May be repeated.").Required().ExistingFiles()
+	mergeSignaturesDest   = mergeSignatures.Flag("output", "A file to write the marshaled common.Envelope to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	missingSignatures       = app.Command("missing_signatures", "Lists the elements of the config update of a CONFIG_UPDATE common.Envelope whose mod_policy is not satisfied by its signatures yet")
+	missingSignaturesConfig = missingSignatures.Flag("config", "The current marshaled common.Config of the channel").Required().File()
+	missingSignaturesSource = missingSignatures.Flag("input", "A file containing the envelope").Default(os.Stdin.Name()).File()
+	missingSignaturesDest   = missingSignatures.Flag("output", "A file to write the JSON result to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	sanityCheck       = app.Command("sanity_check", "Checks a marshaled common.Config message for errors and warnings")
+	sanityCheckSource = sanityCheck.Flag("input", "A file containing the config message").Default(os.Stdin.Name()).File()
+	sanityCheckDest   = sanityCheck.Flag("output", "A file to write the JSON result to, standard output by default").OpenFile(os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 
 	version = app.Command("version", "Show version information")
 )
@@ -47,6 +169,151 @@ func main() {
 	case start.FullCommand():
 		startServer(fmt.Sprintf("%s:%d", *hostname, *port))
 
+	// "proto_encode" command
+	case protoEncode.FullCommand():
+		err := encodeProto(*protoEncodeType, *protoEncodeSource, outputFile(*protoEncodeDest))
+		if err != nil {
+			app.Fatalf("Error encoding: %s", err)
+		}
+
+	// "proto_decode" command
+	case protoDecode.FullCommand():
+		err := decodeProto(*protoDecodeType, *protoDecodeSource, outputFile(*protoDecodeDest))
+		if err != nil {
+			app.Fatalf("Error decoding: %s", err)
+		}
+
+	// "inspect" command
+	case inspectProto.FullCommand():
+		err := inspectMsg(*inspectProtoType, *inspectProtoSource, outputFile(*inspectProtoDest))
+		if err != nil {
+			app.Fatalf("Error inspecting: %s", err)
+		}
+
+	// "compute_update" command
+	case computeUpdate.FullCommand():
+		err := computeUpdt(*computeUpdateOriginal, *computeUpdateUpdated, outputFile(*computeUpdateDest), *computeUpdateChannelID)
+		if err != nil {
+			app.Fatalf("Error computing update: %s", err)
+		}
+
+	// "compute_update_from_patch" command
+	case patchUpdate.FullCommand():
+		err := computeUpdateFromPatch(*patchUpdateOriginal, *patchUpdatePatch, outputFile(*patchUpdateDest), *patchUpdateChannelID)
+		if err != nil {
+			app.Fatalf("Error computing update: %s", err)
+		}
+
+	// "apply_update" command
+	case applyUpdate.FullCommand():
+		err := applyUpdt(*applyUpdateOriginal, *applyUpdateUpdate, outputFile(*applyUpdateDest))
+		if err != nil {
+			app.Fatalf("Error applying update: %s", err)
+		}
+
+	// "add_org" command
+	case addOrg.FullCommand():
+		name := *addOrgName
+		if name == "" {
+			name = *addOrgMSPID
+		}
+		mspConf, err := channelops.OrgMSPConfig(*addOrgMSPDir, *addOrgMSPID)
+		if err != nil {
+			app.Fatalf("Error loading MSP: %s", err)
+		}
+		err = channelUpdate(*addOrgBlock, outputFile(*addOrgDest), func(conf *cb.Config) error {
+			return channelops.AddOrg(conf, name, mspConf)
+		})
+		if err != nil {
+			app.Fatalf("Error adding org: %s", err)
+		}
+
+	// "remove_org" command
+	case removeOrg.FullCommand():
+		err := channelUpdate(*removeOrgBlock, outputFile(*removeOrgDest), func(conf *cb.Config) error {
+			return channelops.RemoveOrg(conf, *removeOrgName)
+		})
+		if err != nil {
+			app.Fatalf("Error removing org: %s", err)
+		}
+
+	// "set_batch_size" command
+	case batchSize.FullCommand():
+		err := channelUpdate(*batchSizeBlock, outputFile(*batchSizeDest), func(conf *cb.Config) error {
+			return channelops.SetBatchSize(conf, *batchSizeMaxMessageCount, *batchSizeAbsoluteMaxBytes, *batchSizePreferredMaxBytes)
+		})
+		if err != nil {
+			app.Fatalf("Error setting batch size: %s", err)
+		}
+
+	// "set_batch_timeout" command
+	case batchTimeout.FullCommand():
+		err := channelUpdate(*batchTimeoutBlock, outputFile(*batchTimeoutDest), func(conf *cb.Config) error {
+			return channelops.SetBatchTimeout(conf, *batchTimeoutTimeout)
+		})
+		if err != nil {
+			app.Fatalf("Error setting batch timeout: %s", err)
+		}
+
+	// "set_anchor_peers" command
+	case anchorPeers.FullCommand():
+		var peers []*pb.AnchorPeer
+		for _, address := range *anchorPeersPeers {
+			peer, err := channelops.ParseAnchorPeer(address)
+			if err != nil {
+				app.Fatalf("Error setting anchor peers: %s", err)
+			}
+			peers = append(peers, peer)
+		}
+		err := channelUpdate(*anchorPeersBlock, outputFile(*anchorPeersDest), func(conf *cb.Config) error {
+			return channelops.SetAnchorPeers(conf, *anchorPeersOrg, peers)
+		})
+		if err != nil {
+			app.Fatalf("Error setting anchor peers: %s", err)
+		}
+
+	// "diff" command
+	case diff.FullCommand():
+		err := diffConfigs(*diffOriginal, *diffUpdated, outputFile(*diffDest), *diffFormat)
+		if err != nil {
+			app.Fatalf("Error comparing configs: %s", err)
+		}
+
+	// "wrap_update" command
+	case wrapUpdate.FullCommand():
+		err := wrapConfigUpdate(*wrapUpdateSource, outputFile(*wrapUpdateDest), *wrapUpdateChannelID)
+		if err != nil {
+			app.Fatalf("Error wrapping update: %s", err)
+		}
+
+	// "sign_update" command
+	case signUpdate.FullCommand():
+		err := signConfigUpdate(*signUpdateSource, outputFile(*signUpdateDest), *signUpdateMSPDir, *signUpdateMSPID)
+		if err != nil {
+			app.Fatalf("Error signing update: %s", err)
+		}
+
+	// "merge_signatures" command
+	case mergeSignatures.FullCommand():
+		err := mergeConfigSignatures(*mergeSignaturesSource, outputFile(*mergeSignaturesDest))
+		if err != nil {
+			app.Fatalf("Error merging signatures: %s", err)
+		}
+
+	// "missing_signatures" command
+	case missingSignatures.FullCommand():
+		err := missingConfigSignatures(*missingSignaturesConfig, *missingSignaturesSource, outputFile(*missingSignaturesDest))
+		if err != nil {
+			app.Fatalf("Error checking signatures: %s", err)
+		}
+
+	// "sanity_check" command
+	case sanityCheck.FullCommand():
+		err := sanityCheckConfig(*sanityCheckSource, outputFile(*sanityCheckDest))
+		if err != nil {
+			app.Fatalf("Error performing sanity check: %s", err)
+		}
+
 	// "version" command
 	case version.FullCommand():
 		printVersion()
@@ -55,12 +322,527 @@ func main() {
 }
 
 func startServer(address string) {
-	logger.Infof("Serving HTTP requests on %s", address)
-	err := http.ListenAndServe(address, rest.NewRouter())
+	server := &http.Server{
+		Addr:    address,
+		Handler: rest.NewRouterWithMaxBodyBytes(*maxBody),
+	}
+
+	if *tlsCert == "" {
+		if *tlsKey != "" || len(*tlsCAs) > 0 {
+			app.Fatalf("--tls.key and --tls.clientCAs require --tls.cert")
+		}
+		logger.Infof("Serving HTTP requests on %s", address)
+		serve(server, server.ListenAndServe)
+		return
+	}
+
+	tlsConfig, err := serverTLSConfig(*tlsCert, *tlsKey, *tlsCAs)
+	if err != nil {
+		app.Fatalf("Error configuring TLS: %s", err)
+	}
+	server.TLSConfig = tlsConfig
+
+	logger.Infof("Serving HTTPS requests on %s", address)
+	if tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+		logger.Infof("Requiring client certificates issued by %d CAs", len(tlsConfig.ClientCAs.Subjects()))
+	}
+	serve(server, func() error { return server.ListenAndServeTLS("", "") })
+}
+
+// serve runs listen until server is shut down on SIGTERM or SIGINT, which
+// lets the requests in flight complete for up to --shutdown_timeout
+func serve(server *http.Server, listen func() error) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-signals
+		logger.Infof("Received %s, shutting down", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			logger.Warningf("Error shutting down server: %s", err)
+		}
+	}()
+
+	err := listen()
+	if err != http.ErrServerClosed {
+		app.Fatalf("Error starting server:[%s]\n", err)
+	}
+	<-done
+	logger.Infof("Server stopped")
+}
+
+// serverTLSConfig returns the TLS configuration of the server presenting the
+// key pair in certFile and keyFile. Unless clientCAs is empty, clients must
+// present a certificate issued by one of the CAs found in the clientCAs
+// files or directories, such as the tlscacerts of the MSPs of the allowed
+// orgs.
+func serverTLSConfig(certFile, keyFile string, clientCAs []string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading server key pair: %s", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(clientCAs) == 0 {
+		return tlsConfig, nil
+	}
+
+	pool := x509.NewCertPool()
+	for _, path := range clientCAs {
+		files := []string{path}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			entries, err := filepath.Glob(filepath.Join(path, "*"))
+			if err != nil {
+				return nil, err
+			}
+			// only the files of a directory hold certificates
+			files = nil
+			for _, entry := range entries {
+				if info, err := os.Stat(entry); err == nil && info.Mode().IsRegular() {
+					files = append(files, entry)
+				}
+			}
+		}
+		for _, file := range files {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("error reading client CA certificates: %s", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM encoded certificate found in %s", file)
+			}
+		}
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 
-	app.Fatalf("Error starting server:[%s]\n", err)
+	return tlsConfig, nil
 }
 
 func printVersion() {
 	fmt.Println(metadata.GetVersionInfo())
 }
+
+// outputFile returns the file opened for an --output flag, or the standard
+// output if the flag was not set
+func outputFile(file *os.File) *os.File {
+	if file == nil {
+		return os.Stdout
+	}
+	return file
+}
+
+// msgForType returns a new message of the registered proto type msgName
+func msgForType(msgName string) (proto.Message, error) {
+	msgType := proto.MessageType(msgName)
+	if msgType == nil {
+		return nil, fmt.Errorf("message of type %s unknown", msgName)
+	}
+	return reflect.New(msgType.Elem()).Interface().(proto.Message), nil
+}
+
+func encodeProto(msgName string, input io.Reader, output io.Writer) error {
+	msg, err := msgForType(msgName)
+	if err != nil {
+		return err
+	}
+
+	err = protolator.DeepUnmarshalJSON(input, msg)
+	if err != nil {
+		return fmt.Errorf("error decoding input: %s", err)
+	}
+
+	out, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error marshaling: %s", err)
+	}
+
+	_, err = output.Write(out)
+	if err != nil {
+		return fmt.Errorf("error writing output: %s", err)
+	}
+
+	return nil
+}
+
+func decodeProto(msgName string, input io.Reader, output io.Writer) error {
+	msg, err := msgForType(msgName)
+	if err != nil {
+		return err
+	}
+
+	in, err := ioutil.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("error reading input: %s", err)
+	}
+
+	err = proto.Unmarshal(in, msg)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling: %s", err)
+	}
+
+	err = protolator.DeepMarshalJSON(output, msg)
+	if err != nil {
+		return fmt.Errorf("error encoding output: %s", err)
+	}
+
+	return nil
+}
+
+func inspectMsg(msgName string, input io.Reader, output io.Writer) error {
+	msg, err := msgForType(msgName)
+	if err != nil {
+		return err
+	}
+
+	in, err := ioutil.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("error reading input: %s", err)
+	}
+
+	err = proto.Unmarshal(in, msg)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling: %s", err)
+	}
+
+	err = inspect.Message(output, msg)
+	if err != nil {
+		return fmt.Errorf("error encoding output: %s", err)
+	}
+
+	return nil
+}
+
+func computeUpdt(original, updated io.Reader, output io.Writer, channelID string) error {
+	origConf, err := readConfig(original)
+	if err != nil {
+		return fmt.Errorf("error with original config: %s", err)
+	}
+
+	updtConf, err := readConfig(updated)
+	if err != nil {
+		return fmt.Errorf("error with updated config: %s", err)
+	}
+
+	cu, err := update.Compute(origConf, updtConf)
+	if err != nil {
+		return err
+	}
+
+	cu.ChannelId = channelID
+
+	outBytes, err := proto.Marshal(cu)
+	if err != nil {
+		return fmt.Errorf("error marshaling computed config update: %s", err)
+	}
+
+	_, err = output.Write(outBytes)
+	if err != nil {
+		return fmt.Errorf("error writing config update to output: %s", err)
+	}
+
+	return nil
+}
+
+func computeUpdateFromPatch(original, patchInput io.Reader, output io.Writer, channelID string) error {
+	in, err := ioutil.ReadAll(original)
+	if err != nil {
+		return fmt.Errorf("error reading original config: %s", err)
+	}
+	origConf, err := patch.UnmarshalConfig(in)
+	if err != nil {
+		return fmt.Errorf("error with original config: %s", err)
+	}
+
+	patchBytes, err := ioutil.ReadAll(patchInput)
+	if err != nil {
+		return fmt.Errorf("error reading patch: %s", err)
+	}
+
+	cu, err := patch.ComputeUpdate(origConf, patchBytes)
+	if err != nil {
+		return err
+	}
+
+	cu.ChannelId = channelID
+
+	outBytes, err := proto.Marshal(cu)
+	if err != nil {
+		return fmt.Errorf("error marshaling computed config update: %s", err)
+	}
+
+	_, err = output.Write(outBytes)
+	if err != nil {
+		return fmt.Errorf("error writing config update to output: %s", err)
+	}
+
+	return nil
+}
+
+func applyUpdt(original, configUpdate io.Reader, output io.Writer) error {
+	origConf, err := readConfig(original)
+	if err != nil {
+		return fmt.Errorf("error with original config: %s", err)
+	}
+
+	in, err := ioutil.ReadAll(configUpdate)
+	if err != nil {
+		return fmt.Errorf("error reading config update: %s", err)
+	}
+	cu := &cb.ConfigUpdate{}
+	err = proto.Unmarshal(in, cu)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling config update: %s", err)
+	}
+
+	config, err := update.Apply(origConf, cu)
+	if err != nil {
+		return err
+	}
+
+	outBytes, err := proto.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("error marshaling config: %s", err)
+	}
+
+	_, err = output.Write(outBytes)
+	if err != nil {
+		return fmt.Errorf("error writing config to output: %s", err)
+	}
+
+	return nil
+}
+
+// channelUpdate applies edit to the config of the config block read from
+// input and writes the resulting config update to output
+func channelUpdate(input io.Reader, output io.Writer, edit func(conf *cb.Config) error) error {
+	in, err := ioutil.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("error reading block: %s", err)
+	}
+	block := &cb.Block{}
+	err = proto.Unmarshal(in, block)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling block: %s", err)
+	}
+
+	cu, err := channelops.ComputeUpdate(block, edit)
+	if err != nil {
+		return err
+	}
+
+	outBytes, err := proto.Marshal(cu)
+	if err != nil {
+		return fmt.Errorf("error marshaling computed config update: %s", err)
+	}
+
+	_, err = output.Write(outBytes)
+	if err != nil {
+		return fmt.Errorf("error writing config update to output: %s", err)
+	}
+
+	return nil
+}
+
+func diffConfigs(original, updated io.Reader, output io.Writer, format string) error {
+	origConf, err := readConfig(original)
+	if err != nil {
+		return fmt.Errorf("error with original config: %s", err)
+	}
+
+	updtConf, err := readConfig(updated)
+	if err != nil {
+		return fmt.Errorf("error with updated config: %s", err)
+	}
+
+	changes, err := update.Diff(origConf, updtConf)
+	if err != nil {
+		return err
+	}
+
+	if format == "text" {
+		return update.WriteText(output, changes)
+	}
+
+	resBytes, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling result to JSON: %s", err)
+	}
+
+	_, err = output.Write(resBytes)
+	if err != nil {
+		return fmt.Errorf("error writing result to output: %s", err)
+	}
+
+	return nil
+}
+
+func wrapConfigUpdate(input io.Reader, output io.Writer, channelID string) error {
+	in, err := ioutil.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("error reading config update: %s", err)
+	}
+
+	cu := &cb.ConfigUpdate{}
+	err = proto.Unmarshal(in, cu)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling config update: %s", err)
+	}
+
+	env, err := envelope.Wrap(cu, channelID)
+	if err != nil {
+		return err
+	}
+
+	return writeEnvelope(output, env)
+}
+
+func signConfigUpdate(input io.Reader, output io.Writer, mspDir, mspID string) error {
+	env, err := readEnvelope(input)
+	if err != nil {
+		return err
+	}
+
+	signer, err := envelope.LoadSigningIdentity(mspDir, mspID)
+	if err != nil {
+		return err
+	}
+
+	env, err = envelope.Sign(env, signer)
+	if err != nil {
+		return err
+	}
+
+	return writeEnvelope(output, env)
+}
+
+func mergeConfigSignatures(files []string, output io.Writer) error {
+	var envs []*cb.Envelope
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		env, err := readEnvelope(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error with %s: %s", file, err)
+		}
+		envs = append(envs, env)
+	}
+
+	env, err := envelope.Merge(envs...)
+	if err != nil {
+		return err
+	}
+
+	return writeEnvelope(output, env)
+}
+
+func missingConfigSignatures(configInput, input io.Reader, output io.Writer) error {
+	config, err := readConfig(configInput)
+	if err != nil {
+		return fmt.Errorf("error with config: %s", err)
+	}
+
+	env, err := readEnvelope(input)
+	if err != nil {
+		return err
+	}
+
+	missing, err := envelope.MissingSignatures(config, env)
+	if err != nil {
+		return err
+	}
+
+	resBytes, err := json.Marshal(missing)
+	if err != nil {
+		return fmt.Errorf("error marshaling result to JSON: %s", err)
+	}
+
+	_, err = output.Write(resBytes)
+	if err != nil {
+		return fmt.Errorf("error writing result to output: %s", err)
+	}
+
+	return nil
+}
+
+func sanityCheckConfig(input io.Reader, output io.Writer) error {
+	config, err := readConfig(input)
+	if err != nil {
+		return err
+	}
+
+	sanityCheckMessages, err := sanitycheck.Check(config)
+	if err != nil {
+		return err
+	}
+
+	resBytes, err := json.Marshal(sanityCheckMessages)
+	if err != nil {
+		return fmt.Errorf("error marshaling result to JSON: %s", err)
+	}
+
+	_, err = output.Write(resBytes)
+	if err != nil {
+		return fmt.Errorf("error writing result to output: %s", err)
+	}
+
+	return nil
+}
+
+// readConfig reads a marshaled common.Config from input
+func readConfig(input io.Reader) (*cb.Config, error) {
+	in, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("error reading: %s", err)
+	}
+
+	config := &cb.Config{}
+	err = proto.Unmarshal(in, config)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling: %s", err)
+	}
+
+	return config, nil
+}
+
+// readEnvelope reads a marshaled common.Envelope from input
+func readEnvelope(input io.Reader) (*cb.Envelope, error) {
+	in, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("error reading envelope: %s", err)
+	}
+
+	env := &cb.Envelope{}
+	err = proto.Unmarshal(in, env)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling envelope: %s", err)
+	}
+
+	return env, nil
+}
+
+// writeEnvelope writes env, marshaled, to output
+func writeEnvelope(output io.Writer, env *cb.Envelope) error {
+	outBytes, err := proto.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("error marshaling envelope: %s", err)
+	}
+
+	_, err = output.Write(outBytes)
+	if err != nil {
+		return fmt.Errorf("error writing envelope to output: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/tools/configtxlator/sanitycheck"
+	cb "github.com/hyperledger/fabric/protos/common"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeProto(t *testing.T) {
+	msgs := map[string]proto.Message{
+		"common.Block": &cb.Block{
+			Header: &cb.BlockHeader{Number: 1, PreviousHash: []byte("foo")},
+			Data: &cb.BlockData{
+				Data: [][]byte{utils.MarshalOrPanic(&cb.Envelope{Signature: []byte("bar")})},
+			},
+		},
+		"common.Envelope": &cb.Envelope{Signature: []byte("bar")},
+		"common.Config": &cb.Config{
+			Sequence:     3,
+			ChannelGroup: &cb.ConfigGroup{Version: 1, ModPolicy: "Admins"},
+		},
+		"common.ConfigUpdate": &cb.ConfigUpdate{
+			ChannelId: "foo",
+			WriteSet:  &cb.ConfigGroup{ModPolicy: "Admins"},
+		},
+		"msp.MSPConfig": &mspprotos.MSPConfig{
+			Config: utils.MarshalOrPanic(&mspprotos.FabricMSPConfig{Name: "Org1MSP"}),
+		},
+		"orderer.BatchSize": &ab.BatchSize{MaxMessageCount: 10, AbsoluteMaxBytes: 1 << 20},
+		"protos.AnchorPeers": &pb.AnchorPeers{
+			AnchorPeers: []*pb.AnchorPeer{{Host: "peer0.org1.example.com", Port: 7051}},
+		},
+	}
+
+	for msgName, msg := range msgs {
+		doc := &bytes.Buffer{}
+		err := decodeProto(msgName, bytes.NewReader(utils.MarshalOrPanic(msg)), doc)
+		assert.NoError(t, err, msgName)
+
+		encoded := &bytes.Buffer{}
+		err = encodeProto(msgName, bytes.NewReader(doc.Bytes()), encoded)
+		assert.NoError(t, err, msgName)
+
+		roundTripped, err := msgForType(msgName)
+		assert.NoError(t, err)
+		assert.NoError(t, proto.Unmarshal(encoded.Bytes(), roundTripped), msgName)
+		assert.True(t, proto.Equal(msg, roundTripped), "%s does not round trip through %s", msgName, doc)
+	}
+}
+
+func TestEncodeDecodeProtoErrors(t *testing.T) {
+	err := encodeProto("common.Bogus", bytes.NewReader([]byte("{}")), &bytes.Buffer{})
+	assert.EqualError(t, err, "message of type common.Bogus unknown")
+	err = decodeProto("common.Bogus", bytes.NewReader(nil), &bytes.Buffer{})
+	assert.EqualError(t, err, "message of type common.Bogus unknown")
+
+	err = encodeProto("common.Config", bytes.NewReader([]byte("{")), &bytes.Buffer{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "error decoding input")
+	err = decodeProto("common.Config", bytes.NewReader([]byte("Garbage")), &bytes.Buffer{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "error unmarshaling")
+}
+
+func TestComputeUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtxlator")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	original := filepath.Join(dir, "original.pb")
+	updated := filepath.Join(dir, "updated.pb")
+	output := filepath.Join(dir, "update.pb")
+	assert.NoError(t, ioutil.WriteFile(original, utils.MarshalOrPanic(&cb.Config{
+		ChannelGroup: &cb.ConfigGroup{ModPolicy: "foo"},
+	}), 0644))
+	assert.NoError(t, ioutil.WriteFile(updated, utils.MarshalOrPanic(&cb.Config{
+		ChannelGroup: &cb.ConfigGroup{ModPolicy: "bar"},
+	}), 0644))
+
+	// the files are opened by the flags like on the command line
+	command, err := app.Parse([]string{"compute_update",
+		"--original", original, "--updated", updated, "--channel_id", "foo", "--output", output})
+	assert.NoError(t, err)
+	assert.Equal(t, computeUpdate.FullCommand(), command)
+	defer (*computeUpdateOriginal).Close()
+	defer (*computeUpdateUpdated).Close()
+	err = computeUpdt(*computeUpdateOriginal, *computeUpdateUpdated, *computeUpdateDest, *computeUpdateChannelID)
+	assert.NoError(t, err)
+	(*computeUpdateDest).Close()
+
+	data, err := ioutil.ReadFile(output)
+	assert.NoError(t, err)
+	cu := &cb.ConfigUpdate{}
+	assert.NoError(t, proto.Unmarshal(data, cu))
+	assert.Equal(t, "foo", cu.ChannelId)
+	assert.Equal(t, uint64(0), cu.ReadSet.Version)
+	assert.Equal(t, "bar", cu.WriteSet.ModPolicy)
+	assert.Equal(t, uint64(1), cu.WriteSet.Version)
+
+	// identical configs have no update
+	(*computeUpdateOriginal).Seek(0, 0)
+	err = computeUpdt(*computeUpdateOriginal, bytes.NewReader(utils.MarshalOrPanic(&cb.Config{
+		ChannelGroup: &cb.ConfigGroup{ModPolicy: "foo"},
+	})), &bytes.Buffer{}, "foo")
+	assert.Error(t, err)
+
+	err = computeUpdt(bytes.NewReader([]byte("Garbage")), bytes.NewReader(data), &bytes.Buffer{}, "foo")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "error with original config")
+
+	_, err = app.Parse([]string{"compute_update",
+		"--original", filepath.Join(dir, "missing.pb"), "--updated", updated})
+	assert.Error(t, err)
+}
+
+func TestSanityCheckConfig(t *testing.T) {
+	output := &bytes.Buffer{}
+	err := sanityCheckConfig(bytes.NewReader(utils.MarshalOrPanic(&cb.Config{})), output)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(output.Bytes(), &sanitycheck.Messages{}), output.String())
+
+	err = sanityCheckConfig(bytes.NewReader([]byte("Garbage")), &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+// testCA issues the certificates of the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCA{cert, key}
+}
+
+// issue returns a key pair for name, usable by servers and clients
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writePEM(t *testing.T, path, pemType string, der []byte) {
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), 0600)
+	assert.NoError(t, err)
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtxlator")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	serverCA := newTestCA(t, "tlsca.example.com")
+	clientCA := newTestCA(t, "tlsca.org1.example.com")
+	otherCA := newTestCA(t, "tlsca.org2.example.com")
+
+	// the key pair of the server, and the tlscacerts of an MSP
+	server := serverCA.issue(t, "configtxlator")
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writePEM(t, certFile, "CERTIFICATE", server.Certificate[0])
+	keyDER, err := x509.MarshalECPrivateKey(server.PrivateKey.(*ecdsa.PrivateKey))
+	assert.NoError(t, err)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	tlsCACerts := filepath.Join(dir, "tlscacerts")
+	assert.NoError(t, os.Mkdir(tlsCACerts, 0755))
+	writePEM(t, filepath.Join(tlsCACerts, "tlsca.org1.example.com-cert.pem"), "CERTIFICATE", clientCA.cert.Raw)
+	// subdirectories of the folder are skipped
+	assert.NoError(t, os.Mkdir(filepath.Join(tlsCACerts, "archive"), 0755))
+
+	tlsConfig, err := serverTLSConfig(certFile, keyFile, []string{tlsCACerts})
+	assert.NoError(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	get := func(certs ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		resp, err := client.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, get(clientCA.issue(t, "User1@org1.example.com")))
+	assert.Error(t, get(), "a client without certificate was accepted")
+	assert.Error(t, get(otherCA.issue(t, "User1@org2.example.com")), "a client of an unknown CA was accepted")
+
+	// clients need no certificate without client CAs
+	tlsConfig, err = serverTLSConfig(certFile, keyFile, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+
+	_, err = serverTLSConfig(certFile, keyFile, []string{filepath.Join(dir, "missing")})
+	assert.Error(t, err)
+	_, err = serverTLSConfig(certFile, keyFile, []string{keyFile})
+	assert.Error(t, err)
+	_, err = serverTLSConfig(keyFile, certFile, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,456 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/common/tools/configtxlator/update"
+	"github.com/hyperledger/fabric/common/tools/protolator"
+	cb "github.com/hyperledger/fabric/protos/common"
+
+	// Import these to register the proto types
+	_ "github.com/hyperledger/fabric/protos/msp"
+	_ "github.com/hyperledger/fabric/protos/orderer"
+	_ "github.com/hyperledger/fabric/protos/peer"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Apply applies patch to the JSON document doc and returns the patched
+// document. A patch which is a JSON array is an RFC 6902 JSON Patch, any other
+// patch is an RFC 7386 JSON Merge Patch.
+func Apply(doc, patch []byte) ([]byte, error) {
+	docTree, err := decode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding document: %s", err)
+	}
+	patchTree, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding patch: %s", err)
+	}
+
+	if ops, ok := patchTree.([]interface{}); ok {
+		docTree, err = applyJSONPatch(docTree, ops)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		docTree = applyMergePatch(docTree, patchTree)
+	}
+
+	return json.Marshal(docTree)
+}
+
+// ApplyToConfig applies patch, as accepted by Apply, to the protolator JSON
+// form of config and returns the patched config
+func ApplyToConfig(config *cb.Config, patch []byte) (*cb.Config, error) {
+	var buffer bytes.Buffer
+	err := protolator.DeepMarshalJSON(&buffer, config)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding config to JSON: %s", err)
+	}
+
+	patched, err := Apply(buffer.Bytes(), patch)
+	if err != nil {
+		return nil, err
+	}
+
+	patchedConfig := &cb.Config{}
+	err = protolator.DeepUnmarshalJSON(bytes.NewReader(patched), patchedConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding patched config: %s", err)
+	}
+
+	return patchedConfig, nil
+}
+
+// ComputeUpdate returns the config update transitioning config to the config
+// obtained by applying patch to it with ApplyToConfig
+func ComputeUpdate(config *cb.Config, patch []byte) (*cb.ConfigUpdate, error) {
+	patchedConfig, err := ApplyToConfig(config, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return update.Compute(config, patchedConfig)
+}
+
+// UnmarshalConfig decodes a common.Config given either as a marshaled
+// protobuf message or in its protolator JSON form
+func UnmarshalConfig(data []byte) (*cb.Config, error) {
+	config := &cb.Config{}
+
+	// a marshaled common.Config never starts with '{'
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err := protolator.DeepUnmarshalJSON(bytes.NewReader(trimmed), config)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding JSON config: %s", err)
+		}
+		return config, nil
+	}
+
+	err := proto.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling config: %s", err)
+	}
+	return config, nil
+}
+
+func decode(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// keep numbers as they are written
+	decoder.UseNumber()
+
+	var tree interface{}
+	err := decoder.Decode(&tree)
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// applyMergePatch applies the merge patch patch to doc as described in
+// RFC 7386
+func applyMergePatch(doc, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	docObject, ok := doc.(map[string]interface{})
+	if !ok {
+		docObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(docObject, key)
+			continue
+		}
+		docObject[key] = applyMergePatch(docObject[key], value)
+	}
+	return docObject
+}
+
+// applyJSONPatch applies the operations of a JSON Patch to doc in order as
+// described in RFC 6902. Nothing is applied unless every operation succeeds.
+func applyJSONPatch(doc interface{}, ops []interface{}) (interface{}, error) {
+	// operations modify the document in place
+	doc = deepCopy(doc)
+
+	for i, rawOp := range ops {
+		op, ok := rawOp.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operation %d is not a JSON object", i)
+		}
+		name, _ := op["op"].(string)
+		path, ok := op["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("operation %d has no path", i)
+		}
+
+		var err error
+		switch name {
+		case "add", "replace", "test":
+			value, ok := op["value"]
+			if !ok {
+				return nil, fmt.Errorf("operation %d (%s %s) has no value", i, name, path)
+			}
+			switch name {
+			case "add":
+				doc, err = add(doc, path, deepCopy(value))
+			case "replace":
+				doc, _, err = remove(doc, path)
+				if err == nil {
+					doc, err = add(doc, path, deepCopy(value))
+				}
+			case "test":
+				var current interface{}
+				current, err = get(doc, path)
+				if err == nil && !equal(current, value) {
+					err = fmt.Errorf("value differs")
+				}
+			}
+		case "remove":
+			doc, _, err = remove(doc, path)
+		case "move", "copy":
+			from, ok := op["from"].(string)
+			if !ok {
+				return nil, fmt.Errorf("operation %d (%s %s) has no from", i, name, path)
+			}
+			var value interface{}
+			if name == "move" {
+				if strings.HasPrefix(path, from+"/") {
+					return nil, fmt.Errorf("operation %d (%s %s) moves %s into itself", i, name, path, from)
+				}
+				doc, value, err = remove(doc, from)
+			} else {
+				value, err = get(doc, from)
+				value = deepCopy(value)
+			}
+			if err == nil {
+				doc, err = add(doc, path, value)
+			}
+		default:
+			return nil, fmt.Errorf("operation %d has unknown op '%s'", i, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s) failed: %s", i, name, path, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// parsePointer splits the JSON Pointer path into its unescaped reference
+// tokens, as described in RFC 6901
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if path[0] != '/' {
+		return nil, fmt.Errorf("pointer %s does not start with /", path)
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// arrayIndex returns the index of array designated by token, which may be
+// len(array) if end is set
+func arrayIndex(array []interface{}, token string, end bool) (int, error) {
+	if end && token == "-" {
+		return len(array), nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	max := len(array) - 1
+	if end {
+		max = len(array)
+	}
+	if index < 0 || index > max {
+		return 0, fmt.Errorf("array index %d out of bounds", index)
+	}
+	return index, nil
+}
+
+// get returns the value of doc designated by path
+func get(doc interface{}, path string) (interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member '%s' not found", token)
+			}
+			doc = value
+		case []interface{}:
+			index, err := arrayIndex(node, token, false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[index]
+		default:
+			return nil, fmt.Errorf("cannot reference '%s' in a scalar value", token)
+		}
+	}
+	return doc, nil
+}
+
+// add adds value to doc at path and returns the modified document
+func add(doc interface{}, path string, value interface{}) (interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parentPath := path[:strings.LastIndex(path, "/")]
+	parent, err := get(doc, parentPath)
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		index, err := arrayIndex(node, last, true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[index+1:], node[index:])
+		node[index] = value
+		// the array grew, so it must be replaced in its parent
+		return replace(doc, parentPath, node)
+	default:
+		return nil, fmt.Errorf("cannot add '%s' to a scalar value", last)
+	}
+}
+
+// remove removes the value at path from doc and returns the modified document
+// along with the removed value
+func remove(doc interface{}, path string) (interface{}, interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+
+	parentPath := path[:strings.LastIndex(path, "/")]
+	parent, err := get(doc, parentPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("member '%s' not found", last)
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		index, err := arrayIndex(node, last, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[index]
+		node = append(node[:index:index], node[index+1:]...)
+		doc, err = replace(doc, parentPath, node)
+		return doc, value, err
+	default:
+		return nil, nil, fmt.Errorf("cannot remove '%s' from a scalar value", last)
+	}
+}
+
+// replace sets the existing value of doc at path to value and returns the
+// modified document
+func replace(doc interface{}, path string, value interface{}) (interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, path[:strings.LastIndex(path, "/")])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		index, err := arrayIndex(node, last, false)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = value
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("cannot replace '%s' in a scalar value", last)
+	}
+}
+
+// equal compares two decoded JSON values, numbers by value
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		nb, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fa, errA := a.Float64()
+		fb, errB := nb.Float64()
+		return errA == nil && errB == nil && fa == fb
+	case map[string]interface{}:
+		ob, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(ob) {
+			return false
+		}
+		for key, member := range a {
+			if other, ok := ob[key]; !ok || !equal(member, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		ab, ok := b.([]interface{})
+		if !ok || len(a) != len(ab) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], ab[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, member := range value {
+			result[key] = deepCopy(member)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, element := range value {
+			result[i] = deepCopy(element)
+		}
+		return result
+	default:
+		return value
+	}
+}
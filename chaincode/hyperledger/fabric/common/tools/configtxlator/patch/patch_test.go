@@ -0,0 +1,159 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/tools/protolator"
+	cb "github.com/hyperledger/fabric/protos/common"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPatch(t *testing.T) {
+	// examples of RFC 6902
+	tests := []struct {
+		doc, patch, expected string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"copy","from":"/~01","path":"/a~1b"}]`, `{"/":9,"a/b":10,"~1":10}`},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+		// arrays of arrays are resized in place
+		{`{"a":[[1,2],[3]]}`, `[{"op":"add","path":"/a/0/1","value":9}]`, `{"a":[[1,9,2],[3]]}`},
+		{`{"a":[[1,2],[3]]}`, `[{"op":"remove","path":"/a/0/1"}]`, `{"a":[[1],[3]]}`},
+		{`[[1],[2,3]]`, `[{"op":"move","from":"/1/0","path":"/0/-"}]`, `[[1,2],[3]]`},
+	}
+	for _, test := range tests {
+		patched, err := Apply([]byte(test.doc), []byte(test.patch))
+		assert.NoError(t, err, test.patch)
+		assert.JSONEq(t, test.expected, string(patched), test.patch)
+	}
+
+	failures := []struct {
+		doc, patch string
+	}{
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{`{"foo":[1]}`, `[{"op":"add","path":"/foo/2","value":2}]`},
+		{`{"foo":[1]}`, `[{"op":"remove","path":"/foo/01"}]`},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`},
+		{`{"foo":"bar"}`, `[{"op":"frobnicate","path":"/foo"}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"foo","value":1}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`},
+		{`{"foo":"bar"}`, `[1]`},
+		{`{"foo":"bar"}`, `[`},
+	}
+	for _, failure := range failures {
+		_, err := Apply([]byte(failure.doc), []byte(failure.patch))
+		assert.Error(t, err, failure.patch)
+	}
+
+	// a failing operation leaves the document unchanged
+	doc := []byte(`{"foo":"bar"}`)
+	_, err := Apply(doc, []byte(`[{"op":"add","path":"/baz","value":1},{"op":"remove","path":"/qux"}]`))
+	assert.Error(t, err)
+	assert.Equal(t, `{"foo":"bar"}`, string(doc))
+}
+
+func TestMergePatch(t *testing.T) {
+	// example of RFC 7386
+	doc := `{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`
+	patch := `{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`
+	expected := `{"title":"Hello!","author":{"givenName":"John"},"tags":["example"],"content":"This will be unchanged","phoneNumber":"+01-123-456-7890"}`
+
+	patched, err := Apply([]byte(doc), []byte(patch))
+	assert.NoError(t, err)
+	assert.JSONEq(t, expected, string(patched))
+
+	patched, err = Apply([]byte(`{"a":"b"}`), []byte(`{"a":{"b":"c"}}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a":{"b":"c"}}`, string(patched))
+}
+
+func TestComputeUpdate(t *testing.T) {
+	config := &cb.Config{
+		Sequence: 3,
+		ChannelGroup: &cb.ConfigGroup{
+			ModPolicy: "Admins",
+			Groups: map[string]*cb.ConfigGroup{
+				"Application": {
+					Version:   1,
+					ModPolicy: "Admins",
+				},
+			},
+		},
+	}
+
+	cu, err := ComputeUpdate(config, []byte(`{"channel_group":{"groups":{"Application":{"mod_policy":"Writers"}}}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), cu.ReadSet.Groups["Application"].Version)
+	assert.Equal(t, uint64(2), cu.WriteSet.Groups["Application"].Version)
+	assert.Equal(t, "Writers", cu.WriteSet.Groups["Application"].ModPolicy)
+
+	cu, err = ComputeUpdate(config, []byte(`[{"op":"replace","path":"/channel_group/groups/Application/mod_policy","value":"Writers"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, "Writers", cu.WriteSet.Groups["Application"].ModPolicy)
+
+	// the config itself is left untouched
+	assert.Equal(t, "Admins", config.ChannelGroup.Groups["Application"].ModPolicy)
+
+	_, err = ComputeUpdate(config, []byte(`{}`))
+	assert.Error(t, err, "Expected an error without any change")
+	_, err = ComputeUpdate(config, []byte(`{"channel_group":{"bogus":1}}`))
+	assert.Error(t, err, "Expected an error with an invalid config")
+}
+
+func TestUnmarshalConfig(t *testing.T) {
+	config := &cb.Config{
+		Sequence:     3,
+		ChannelGroup: &cb.ConfigGroup{ModPolicy: "Admins"},
+	}
+
+	data, err := proto.Marshal(config)
+	assert.NoError(t, err)
+	decoded, err := UnmarshalConfig(data)
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(config, decoded))
+
+	var buffer bytes.Buffer
+	assert.NoError(t, protolator.DeepMarshalJSON(&buffer, config))
+	decoded, err = UnmarshalConfig(buffer.Bytes())
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(config, decoded))
+
+	_, err = UnmarshalConfig([]byte(`{"bogus":1}`))
+	assert.Error(t, err)
+	_, err = UnmarshalConfig([]byte{0xff})
+	assert.Error(t, err)
+}
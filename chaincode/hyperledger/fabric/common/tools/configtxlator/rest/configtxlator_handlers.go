@@ -17,14 +17,24 @@ limitations under the License.
 package rest
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 
+	"github.com/hyperledger/fabric/common/tools/configtxlator/channelops"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/envelope"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/patch"
 	"github.com/hyperledger/fabric/common/tools/configtxlator/sanitycheck"
 	"github.com/hyperledger/fabric/common/tools/configtxlator/update"
 	cb "github.com/hyperledger/fabric/protos/common"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -57,22 +67,19 @@ func fieldConfigProto(fieldName string, r *http.Request) (*cb.Config, error) {
 func ComputeUpdateFromConfigs(w http.ResponseWriter, r *http.Request) {
 	originalConfig, err := fieldConfigProto("original", r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error with field 'original': %s\n", err)
+		fieldError(w, "original", err)
 		return
 	}
 
 	updatedConfig, err := fieldConfigProto("updated", r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error with field 'updated': %s\n", err)
+		fieldError(w, "updated", err)
 		return
 	}
 
 	configUpdate, err := update.Compute(originalConfig, updatedConfig)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error computing update: %s\n", err)
+		operationError(w, "Error computing update: %s", err)
 		return
 	}
 
@@ -80,46 +87,389 @@ func ComputeUpdateFromConfigs(w http.ResponseWriter, r *http.Request) {
 
 	encoded, err := proto.Marshal(configUpdate)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error marshaling config update: %s\n", err)
+		internalError(w, "Error marshaling config update: %s", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(encoded)
+	writeResponse(w, "application/octet-stream", encoded)
+}
+
+// ComputeUpdateFromPatch computes the config update which applies the JSON
+// Patch or merge patch of the 'patch' field to the config of the 'original'
+// field, given either marshaled or in its protolator JSON form
+func ComputeUpdateFromPatch(w http.ResponseWriter, r *http.Request) {
+	originalBytes, err := fieldBytes("original", r)
+	if err != nil {
+		fieldError(w, "original", fmt.Errorf("error reading field bytes: %s", err))
+		return
+	}
+
+	originalConfig, err := patch.UnmarshalConfig(originalBytes)
+	if err != nil {
+		fieldError(w, "original", err)
+		return
+	}
+
+	patchBytes, err := fieldBytes("patch", r)
+	if err != nil {
+		fieldError(w, "patch", fmt.Errorf("error reading field bytes: %s", err))
+		return
+	}
+
+	configUpdate, err := patch.ComputeUpdate(originalConfig, patchBytes)
+	if err != nil {
+		operationError(w, "Error computing update: %s", err)
+		return
+	}
+
+	configUpdate.ChannelId = r.FormValue("channel")
+
+	encoded, err := proto.Marshal(configUpdate)
+	if err != nil {
+		internalError(w, "Error marshaling config update: %s", err)
+		return
+	}
+
+	writeResponse(w, "application/octet-stream", encoded)
+}
+
+// channelOperation computes the config update which edit makes to the
+// config of the config block of the 'block' field
+func channelOperation(w http.ResponseWriter, r *http.Request, edit func(conf *cb.Config) error) {
+	blockBytes, err := fieldBytes("block", r)
+	if err != nil {
+		fieldError(w, "block", fmt.Errorf("error reading field bytes: %s", err))
+		return
+	}
+
+	block := &cb.Block{}
+	err = proto.Unmarshal(blockBytes, block)
+	if err != nil {
+		fieldError(w, "block", fmt.Errorf("error unmarshaling field bytes: %s", err))
+		return
+	}
+
+	configUpdate, err := channelops.ComputeUpdate(block, edit)
+	if err != nil {
+		operationError(w, "Error computing update: %s", err)
+		return
+	}
+
+	encoded, err := proto.Marshal(configUpdate)
+	if err != nil {
+		internalError(w, "Error marshaling config update: %s", err)
+		return
+	}
+
+	writeResponse(w, "application/octet-stream", encoded)
+}
+
+// mspFolders are the folders of a verifying MSP which can be uploaded, each
+// as a field with one file per certificate
+var mspFolders = []string{"cacerts", "admincerts", "intermediatecerts", "tlscacerts", "tlsintermediatecerts", "crls"}
+
+// fieldsMSPConfig returns the config of the verifying MSP with the ID mspID
+// whose certificates are uploaded in the fields named after mspFolders of the
+// form parsed by the router. If the uploads are invalid, the field at fault is
+// returned with the error. An MSP which cannot be set up is blamed on its CA
+// certificates, against which the others are checked.
+func fieldsMSPConfig(r *http.Request, mspID string) (*mspprotos.MSPConfig, string, error) {
+	for _, folder := range []string{"cacerts", "admincerts"} {
+		if len(r.MultipartForm.File[folder]) == 0 {
+			return nil, folder, fmt.Errorf("missing")
+		}
+	}
+
+	mspDir, err := ioutil.TempDir("", "configtxlator")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(mspDir)
+
+	for _, folder := range mspFolders {
+		headers := r.MultipartForm.File[folder]
+		if len(headers) == 0 {
+			continue
+		}
+		err = os.Mkdir(filepath.Join(mspDir, folder), 0755)
+		if err != nil {
+			return nil, "", err
+		}
+		for i, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				return nil, folder, err
+			}
+			data, err := ioutil.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return nil, folder, err
+			}
+			if block, _ := pem.Decode(data); block == nil {
+				return nil, folder, fmt.Errorf("%s is not PEM encoded", header.Filename)
+			}
+			err = ioutil.WriteFile(filepath.Join(mspDir, folder, fmt.Sprintf("%d.pem", i)), data, 0644)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	mspConf, err := channelops.OrgMSPConfig(mspDir, mspID)
+	if err != nil {
+		return nil, "cacerts", err
+	}
+	return mspConf, "", nil
+}
+
+// AddOrg computes the config update which adds the org named after the
+// 'name' field, or the 'msp_id' field if it is empty, to the channel of the
+// config block of the 'block' field. The certificates of the verifying MSP
+// of the org are uploaded in the fields named after its folders, such as
+// 'cacerts' and 'admincerts'.
+func AddOrg(w http.ResponseWriter, r *http.Request) {
+	mspID := r.FormValue("msp_id")
+	if mspID == "" {
+		fieldError(w, "msp_id", fmt.Errorf("missing"))
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		name = mspID
+	}
+
+	mspConf, field, err := fieldsMSPConfig(r, mspID)
+	if err != nil {
+		if field == "" {
+			internalError(w, "Error reading MSP: %s", err)
+			return
+		}
+		fieldError(w, field, err)
+		return
+	}
+
+	channelOperation(w, r, func(conf *cb.Config) error {
+		return channelops.AddOrg(conf, name, mspConf)
+	})
+}
+
+// RemoveOrg computes the config update which removes the org named after the
+// 'name' field from the channel of the config block of the 'block' field
+func RemoveOrg(w http.ResponseWriter, r *http.Request) {
+	channelOperation(w, r, func(conf *cb.Config) error {
+		return channelops.RemoveOrg(conf, r.FormValue("name"))
+	})
+}
+
+// formUint32 returns the value of the field fieldName, 0 if it is empty
+func formUint32(r *http.Request, fieldName string) (uint32, error) {
+	value := r.FormValue(fieldName)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(n), nil
+}
+
+// SetBatchSize computes the config update which sets the limits of the
+// orderer batch size given in the 'max_message_count', 'absolute_max_bytes'
+// and 'preferred_max_bytes' fields in the channel of the config block of the
+// 'block' field
+func SetBatchSize(w http.ResponseWriter, r *http.Request) {
+	maxMessageCount, err := formUint32(r, "max_message_count")
+	if err != nil {
+		fieldError(w, "max_message_count", err)
+		return
+	}
+	absoluteMaxBytes, err := formUint32(r, "absolute_max_bytes")
+	if err != nil {
+		fieldError(w, "absolute_max_bytes", err)
+		return
+	}
+	preferredMaxBytes, err := formUint32(r, "preferred_max_bytes")
+	if err != nil {
+		fieldError(w, "preferred_max_bytes", err)
+		return
+	}
+
+	channelOperation(w, r, func(conf *cb.Config) error {
+		return channelops.SetBatchSize(conf, maxMessageCount, absoluteMaxBytes, preferredMaxBytes)
+	})
+}
+
+// SetBatchTimeout computes the config update which sets the orderer batch
+// timeout to the 'timeout' field in the channel of the config block of the
+// 'block' field
+func SetBatchTimeout(w http.ResponseWriter, r *http.Request) {
+	channelOperation(w, r, func(conf *cb.Config) error {
+		return channelops.SetBatchTimeout(conf, r.FormValue("timeout"))
+	})
+}
+
+// SetAnchorPeers computes the config update which sets the anchor peers of
+// the org named after the 'org' field to the host:port values of the
+// 'anchor_peer' fields in the channel of the config block of the 'block'
+// field
+func SetAnchorPeers(w http.ResponseWriter, r *http.Request) {
+	var anchorPeers []*pb.AnchorPeer
+	for _, address := range r.Form["anchor_peer"] {
+		anchorPeer, err := channelops.ParseAnchorPeer(address)
+		if err != nil {
+			fieldError(w, "anchor_peer", err)
+			return
+		}
+		anchorPeers = append(anchorPeers, anchorPeer)
+	}
+
+	channelOperation(w, r, func(conf *cb.Config) error {
+		return channelops.SetAnchorPeers(conf, r.FormValue("org"), anchorPeers)
+	})
+}
+
+// ApplyUpdate applies the config update of the 'update' field to the config
+// of the 'original' field and returns the resulting config
+func ApplyUpdate(w http.ResponseWriter, r *http.Request) {
+	originalConfig, err := fieldConfigProto("original", r)
+	if err != nil {
+		fieldError(w, "original", err)
+		return
+	}
+
+	updateBytes, err := fieldBytes("update", r)
+	if err != nil {
+		fieldError(w, "update", fmt.Errorf("error reading field bytes: %s", err))
+		return
+	}
+
+	configUpdate := &cb.ConfigUpdate{}
+	err = proto.Unmarshal(updateBytes, configUpdate)
+	if err != nil {
+		fieldError(w, "update", fmt.Errorf("error unmarshaling field bytes: %s", err))
+		return
+	}
+
+	config, err := update.Apply(originalConfig, configUpdate)
+	if err != nil {
+		operationError(w, "Error applying update: %s", err)
+		return
+	}
+
+	encoded, err := proto.Marshal(config)
+	if err != nil {
+		internalError(w, "Error marshaling config: %s", err)
+		return
+	}
+
+	writeResponse(w, "application/octet-stream", encoded)
+}
+
+// DiffConfigs lists the differences between the configs of the 'original'
+// and 'updated' fields, as JSON unless the 'format' field is 'text'. Unlike
+// the diff command, which is read by people, the default is JSON.
+func DiffConfigs(w http.ResponseWriter, r *http.Request) {
+	format := r.FormValue("format")
+	if format != "" && format != "json" && format != "text" {
+		fieldError(w, "format", fmt.Errorf("unknown format '%s', must be json or text", format))
+		return
+	}
+
+	originalConfig, err := fieldConfigProto("original", r)
+	if err != nil {
+		fieldError(w, "original", err)
+		return
+	}
+
+	updatedConfig, err := fieldConfigProto("updated", r)
+	if err != nil {
+		fieldError(w, "updated", err)
+		return
+	}
+
+	changes, err := update.Diff(originalConfig, updatedConfig)
+	if err != nil {
+		operationError(w, "Error comparing configs: %s", err)
+		return
+	}
+
+	if format == "text" {
+		buf := &bytes.Buffer{}
+		err = update.WriteText(buf, changes)
+		if err != nil {
+			internalError(w, "Error writing changes as text: %s", err)
+			return
+		}
+		writeResponse(w, "text/plain", buf.Bytes())
+		return
+	}
+
+	resBytes, err := json.Marshal(changes)
+	if err != nil {
+		internalError(w, "Error marshaling result to JSON: %s", err)
+		return
+	}
+
+	writeResponse(w, "application/json", resBytes)
+}
+
+// WrapUpdate wraps the marshaled config update of the 'update' field in an
+// unsigned CONFIG_UPDATE envelope for the channel of the 'channel' field, or
+// the channel of the update if that field is empty
+func WrapUpdate(w http.ResponseWriter, r *http.Request) {
+	updateBytes, err := fieldBytes("update", r)
+	if err != nil {
+		fieldError(w, "update", fmt.Errorf("error reading field bytes: %s", err))
+		return
+	}
+
+	configUpdate := &cb.ConfigUpdate{}
+	err = proto.Unmarshal(updateBytes, configUpdate)
+	if err != nil {
+		fieldError(w, "update", fmt.Errorf("error unmarshaling field bytes: %s", err))
+		return
+	}
+
+	env, err := envelope.Wrap(configUpdate, r.FormValue("channel"))
+	if err != nil {
+		operationError(w, "Error wrapping update: %s", err)
+		return
+	}
+
+	encoded, err := proto.Marshal(env)
+	if err != nil {
+		internalError(w, "Error marshaling envelope: %s", err)
+		return
+	}
+
+	writeResponse(w, "application/octet-stream", encoded)
 }
 
 func SanityCheckConfig(w http.ResponseWriter, r *http.Request) {
 	buf, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, err)
+		bodyError(w, err)
 		return
 	}
 
 	config := &cb.Config{}
 	err = proto.Unmarshal(buf, config)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "Error unmarshaling data to common.Config': %s\n", err)
+		bodyError(w, fmt.Errorf("error unmarshaling data to common.Config: %s", err))
 		return
 	}
 
-	fmt.Printf("Sanity checking %+v\n", config)
 	sanityCheckMessages, err := sanitycheck.Check(config)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error performing sanity check: %s\n", err)
+		internalError(w, "Error performing sanity check: %s", err)
 		return
 	}
 
 	resBytes, err := json.Marshal(sanityCheckMessages)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Error marshaling result to JSON: %s\n", err)
+		internalError(w, "Error marshaling result to JSON: %s", err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(resBytes)
+	writeResponse(w, "application/json", resBytes)
 }
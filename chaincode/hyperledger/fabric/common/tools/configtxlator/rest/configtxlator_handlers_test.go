@@ -19,15 +19,22 @@ package rest
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/pem"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/hyperledger/fabric/common/config"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/envelope"
 	"github.com/hyperledger/fabric/common/tools/configtxlator/sanitycheck"
+	"github.com/hyperledger/fabric/common/tools/configtxlator/update"
 	cb "github.com/hyperledger/fabric/protos/common"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	pb "github.com/hyperledger/fabric/protos/peer"
 	"github.com/hyperledger/fabric/protos/utils"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -160,6 +167,407 @@ func TestProtolatorCorruptProtos(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
 }
 
+func TestConfigtxlatorComputeUpdateFromPatch(t *testing.T) {
+	originalConfig := utils.MarshalOrPanic(&cb.Config{
+		ChannelGroup: &cb.ConfigGroup{
+			ModPolicy: "foo",
+		},
+	})
+
+	for _, patch := range []string{
+		`{"channel_group":{"mod_policy":"bar"}}`,
+		`[{"op":"replace","path":"/channel_group/mod_policy","value":"bar"}]`,
+	} {
+		buffer := &bytes.Buffer{}
+		mpw := multipart.NewWriter(buffer)
+
+		ffw, err := mpw.CreateFormFile("original", "foo")
+		assert.NoError(t, err)
+		_, err = bytes.NewReader(originalConfig).WriteTo(ffw)
+		assert.NoError(t, err)
+
+		ffw, err = mpw.CreateFormFile("patch", "bar")
+		assert.NoError(t, err)
+		_, err = bytes.NewReader([]byte(patch)).WriteTo(ffw)
+		assert.NoError(t, err)
+
+		err = mpw.WriteField("channel", "foochannel")
+		assert.NoError(t, err)
+
+		err = mpw.Close()
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/configtxlator/compute/update-from-patch", buffer)
+		assert.NoError(t, err)
+
+		req.Header.Set("Content-Type", mpw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		r := NewRouter()
+		r.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		configUpdate := &cb.ConfigUpdate{}
+		err = proto.Unmarshal(rec.Body.Bytes(), configUpdate)
+		assert.NoError(t, err)
+		assert.Equal(t, "foochannel", configUpdate.ChannelId)
+		assert.Equal(t, "bar", configUpdate.WriteSet.ModPolicy)
+	}
+}
+
+func TestConfigtxlatorComputeUpdateFromBadPatch(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	mpw := multipart.NewWriter(buffer)
+
+	ffw, err := mpw.CreateFormFile("original", "foo")
+	assert.NoError(t, err)
+	_, err = bytes.NewReader(utils.MarshalOrPanic(&cb.Config{ChannelGroup: &cb.ConfigGroup{}})).WriteTo(ffw)
+	assert.NoError(t, err)
+
+	ffw, err = mpw.CreateFormFile("patch", "bar")
+	assert.NoError(t, err)
+	_, err = bytes.NewReader([]byte(`[{"op":"remove","path":"/missing"}]`)).WriteTo(ffw)
+	assert.NoError(t, err)
+
+	err = mpw.Close()
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/configtxlator/compute/update-from-patch", buffer)
+	assert.NoError(t, err)
+
+	req.Header.Set("Content-Type", mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	r := NewRouter()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func applyUpdateRequest(t *testing.T, original *cb.Config, configUpdate *cb.ConfigUpdate) *httptest.ResponseRecorder {
+	buffer := &bytes.Buffer{}
+	mpw := multipart.NewWriter(buffer)
+
+	ffw, err := mpw.CreateFormFile("original", "foo")
+	assert.NoError(t, err)
+	_, err = bytes.NewReader(utils.MarshalOrPanic(original)).WriteTo(ffw)
+	assert.NoError(t, err)
+
+	ffw, err = mpw.CreateFormFile("update", "bar")
+	assert.NoError(t, err)
+	_, err = bytes.NewReader(utils.MarshalOrPanic(configUpdate)).WriteTo(ffw)
+	assert.NoError(t, err)
+
+	err = mpw.Close()
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/configtxlator/compute/apply-update", buffer)
+	assert.NoError(t, err)
+
+	req.Header.Set("Content-Type", mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	r := NewRouter()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestConfigtxlatorApplyUpdate(t *testing.T) {
+	original := &cb.Config{
+		ChannelGroup: &cb.ConfigGroup{
+			ModPolicy: "foo",
+		},
+	}
+	configUpdate := &cb.ConfigUpdate{
+		ReadSet: &cb.ConfigGroup{},
+		WriteSet: &cb.ConfigGroup{
+			Version:   1,
+			ModPolicy: "bar",
+		},
+	}
+
+	rec := applyUpdateRequest(t, original, configUpdate)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	config := &cb.Config{}
+	err := proto.Unmarshal(rec.Body.Bytes(), config)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", config.ChannelGroup.ModPolicy)
+	assert.Equal(t, uint64(1), config.ChannelGroup.Version)
+
+	// the update was already applied
+	rec = applyUpdateRequest(t, config, configUpdate)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func channelOperationRequest(t *testing.T, path string, block *cb.Block, fields map[string][]string) *httptest.ResponseRecorder {
+	buffer := &bytes.Buffer{}
+	mpw := multipart.NewWriter(buffer)
+
+	if block != nil {
+		ffw, err := mpw.CreateFormFile("block", "foo")
+		assert.NoError(t, err)
+		_, err = bytes.NewReader(utils.MarshalOrPanic(block)).WriteTo(ffw)
+		assert.NoError(t, err)
+	}
+
+	for name, values := range fields {
+		for _, value := range values {
+			err := mpw.WriteField(name, value)
+			assert.NoError(t, err)
+		}
+	}
+
+	err := mpw.Close()
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", path, buffer)
+	assert.NoError(t, err)
+
+	req.Header.Set("Content-Type", mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	r := NewRouter()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func channelOperationBlock(t *testing.T) *cb.Block {
+	orderer := cb.NewConfigGroup()
+	orderer.ModPolicy = "Admins"
+	orderer.Values[config.BatchTimeoutKey] = &cb.ConfigValue{
+		ModPolicy: "Admins",
+		Value:     utils.MarshalOrPanic(&ab.BatchTimeout{Timeout: "2s"}),
+	}
+	application := cb.NewConfigGroup()
+	application.ModPolicy = "Admins"
+	application.Groups["Org1MSP"] = &cb.ConfigGroup{ModPolicy: "Admins"}
+	channelGroup := cb.NewConfigGroup()
+	channelGroup.ModPolicy = "Admins"
+	channelGroup.Groups[config.OrdererGroupKey] = orderer
+	channelGroup.Groups[config.ApplicationGroupKey] = application
+
+	env, err := utils.CreateSignedEnvelope(cb.HeaderType_CONFIG, "foochannel", nil,
+		&cb.ConfigEnvelope{Config: &cb.Config{ChannelGroup: channelGroup}}, 0, 0)
+	assert.NoError(t, err)
+	block := cb.NewBlock(0, nil)
+	block.Data.Data = [][]byte{utils.MarshalOrPanic(env)}
+	return block
+}
+
+func TestConfigtxlatorChannelOperations(t *testing.T) {
+	rec := channelOperationRequest(t, "/configtxlator/channel/batch-timeout", channelOperationBlock(t),
+		map[string][]string{"timeout": {"1s"}})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	configUpdate := &cb.ConfigUpdate{}
+	err := proto.Unmarshal(rec.Body.Bytes(), configUpdate)
+	assert.NoError(t, err)
+	assert.Equal(t, "foochannel", configUpdate.ChannelId)
+	assert.Equal(t, uint64(1), configUpdate.WriteSet.Groups[config.OrdererGroupKey].Values[config.BatchTimeoutKey].Version)
+
+	rec = channelOperationRequest(t, "/configtxlator/channel/remove-org", channelOperationBlock(t),
+		map[string][]string{"name": {"Org1MSP"}})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	configUpdate = &cb.ConfigUpdate{}
+	err = proto.Unmarshal(rec.Body.Bytes(), configUpdate)
+	assert.NoError(t, err)
+	assert.Empty(t, configUpdate.WriteSet.Groups[config.ApplicationGroupKey].Groups)
+
+	rec = channelOperationRequest(t, "/configtxlator/channel/anchor-peers", channelOperationBlock(t),
+		map[string][]string{"org": {"Org1MSP"}, "anchor_peer": {"peer0.org1.example.com:7051", "peer1.org1.example.com:7051"}})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	configUpdate = &cb.ConfigUpdate{}
+	err = proto.Unmarshal(rec.Body.Bytes(), configUpdate)
+	assert.NoError(t, err)
+	anchorPeers := &pb.AnchorPeers{}
+	err = proto.Unmarshal(configUpdate.WriteSet.Groups[config.ApplicationGroupKey].Groups["Org1MSP"].Values[config.AnchorPeersKey].Value, anchorPeers)
+	assert.NoError(t, err)
+	assert.Len(t, anchorPeers.AnchorPeers, 2)
+}
+
+func TestConfigtxlatorChannelOperationsBadRequests(t *testing.T) {
+	for _, request := range []struct {
+		path   string
+		block  *cb.Block
+		fields map[string][]string
+		code   string
+		field  string
+	}{
+		{"/configtxlator/channel/batch-timeout", nil, map[string][]string{"timeout": {"1s"}}, CodeInvalidField, "block"},
+		{"/configtxlator/channel/batch-timeout", channelOperationBlock(t), map[string][]string{"timeout": {"soon"}}, CodeOperationFailed, ""},
+		{"/configtxlator/channel/batch-size", channelOperationBlock(t), map[string][]string{"max_message_count": {"20"}}, CodeOperationFailed, ""},
+		{"/configtxlator/channel/batch-size", channelOperationBlock(t), map[string][]string{"absolute_max_bytes": {"1MB"}}, CodeInvalidField, "absolute_max_bytes"},
+		{"/configtxlator/channel/remove-org", channelOperationBlock(t), map[string][]string{"name": {"Org2MSP"}}, CodeOperationFailed, ""},
+		{"/configtxlator/channel/anchor-peers", channelOperationBlock(t), map[string][]string{"org": {"Org1MSP"}, "anchor_peer": {"peer0"}}, CodeInvalidField, "anchor_peer"},
+		{"/configtxlator/channel/add-org", channelOperationBlock(t), nil, CodeInvalidField, "msp_id"},
+		{"/configtxlator/channel/add-org", channelOperationBlock(t), map[string][]string{"msp_id": {"Org2MSP"}}, CodeInvalidField, "cacerts"},
+	} {
+		rec := channelOperationRequest(t, request.path, request.block, request.fields)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, request.path)
+		e := responseError(t, rec)
+		assert.Equal(t, request.code, e.Code, "%s %v", request.path, request.fields)
+		assert.Equal(t, request.field, e.Field, "%s %v", request.path, request.fields)
+	}
+}
+
+func TestConfigtxlatorAddOrgBadMSP(t *testing.T) {
+	notACert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("Garbage")}))
+	for _, request := range []struct {
+		files   map[string]string
+		field   string
+		message string
+	}{
+		{map[string]string{"cacerts": "Garbage", "admincerts": notACert}, "cacerts", "cacerts.pem is not PEM encoded"},
+		{map[string]string{"cacerts": notACert, "admincerts": "Garbage"}, "admincerts", "admincerts.pem is not PEM encoded"},
+		{map[string]string{"cacerts": notACert}, "admincerts", "missing"},
+		{map[string]string{"cacerts": notACert, "admincerts": notACert}, "cacerts", "Org2MSP"},
+	} {
+		buffer := &bytes.Buffer{}
+		mpw := multipart.NewWriter(buffer)
+
+		ffw, err := mpw.CreateFormFile("block", "foo")
+		assert.NoError(t, err)
+		_, err = bytes.NewReader(utils.MarshalOrPanic(channelOperationBlock(t))).WriteTo(ffw)
+		assert.NoError(t, err)
+		assert.NoError(t, mpw.WriteField("msp_id", "Org2MSP"))
+		for name, data := range request.files {
+			ffw, err = mpw.CreateFormFile(name, name+".pem")
+			assert.NoError(t, err)
+			_, err = ffw.Write([]byte(data))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, mpw.Close())
+
+		req, err := http.NewRequest("POST", "/configtxlator/channel/add-org", buffer)
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", mpw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		NewRouter().ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		e := responseError(t, rec)
+		assert.Equal(t, CodeInvalidField, e.Code)
+		assert.Equal(t, request.field, e.Field)
+		assert.Contains(t, e.Message, request.message)
+	}
+}
+
+func TestConfigtxlatorDiff(t *testing.T) {
+	for _, format := range []string{"", "json", "text", "yaml"} {
+		buffer := &bytes.Buffer{}
+		mpw := multipart.NewWriter(buffer)
+
+		ffw, err := mpw.CreateFormFile("original", "foo")
+		assert.NoError(t, err)
+		_, err = bytes.NewReader(utils.MarshalOrPanic(&cb.Config{
+			ChannelGroup: &cb.ConfigGroup{
+				ModPolicy: "foo",
+			},
+		})).WriteTo(ffw)
+		assert.NoError(t, err)
+
+		ffw, err = mpw.CreateFormFile("updated", "bar")
+		assert.NoError(t, err)
+		_, err = bytes.NewReader(utils.MarshalOrPanic(&cb.Config{
+			ChannelGroup: &cb.ConfigGroup{
+				ModPolicy: "bar",
+			},
+		})).WriteTo(ffw)
+		assert.NoError(t, err)
+
+		if format != "" {
+			err = mpw.WriteField("format", format)
+			assert.NoError(t, err)
+		}
+
+		err = mpw.Close()
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/configtxlator/diff", buffer)
+		assert.NoError(t, err)
+
+		req.Header.Set("Content-Type", mpw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		r := NewRouter()
+		r.ServeHTTP(rec, req)
+
+		if format == "yaml" {
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			e := responseError(t, rec)
+			assert.Equal(t, CodeInvalidField, e.Code)
+			assert.Equal(t, "format", e.Field)
+			continue
+		}
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		if format != "text" {
+			// JSON is the default
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			changes := []*update.Change{}
+			err = json.Unmarshal(rec.Body.Bytes(), &changes)
+			assert.NoError(t, err)
+			if assert.Len(t, changes, 1) {
+				assert.Equal(t, "channel_group", changes[0].Path)
+			}
+		} else {
+			assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+			assert.Contains(t, rec.Body.String(), "~ channel_group (group, version 0 -> 1)")
+		}
+	}
+}
+
+func TestConfigtxlatorWrapUpdate(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	mpw := multipart.NewWriter(buffer)
+
+	ffw, err := mpw.CreateFormFile("update", "foo")
+	assert.NoError(t, err)
+	_, err = bytes.NewReader(utils.MarshalOrPanic(&cb.ConfigUpdate{})).WriteTo(ffw)
+	assert.NoError(t, err)
+
+	err = mpw.WriteField("channel", "foochannel")
+	assert.NoError(t, err)
+
+	err = mpw.Close()
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/configtxlator/envelope/wrap-update", buffer)
+	assert.NoError(t, err)
+
+	req.Header.Set("Content-Type", mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	r := NewRouter()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	env := &cb.Envelope{}
+	err = proto.Unmarshal(rec.Body.Bytes(), env)
+	assert.NoError(t, err)
+	_, chdr, err := envelope.Unwrap(env)
+	assert.NoError(t, err)
+	assert.Equal(t, "foochannel", chdr.ChannelId)
+}
+
+func TestConfigtxlatorWrapUpdateNoChannel(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	mpw := multipart.NewWriter(buffer)
+
+	ffw, err := mpw.CreateFormFile("update", "foo")
+	assert.NoError(t, err)
+	_, err = bytes.NewReader(utils.MarshalOrPanic(&cb.ConfigUpdate{})).WriteTo(ffw)
+	assert.NoError(t, err)
+
+	err = mpw.Close()
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/configtxlator/envelope/wrap-update", buffer)
+	assert.NoError(t, err)
+
+	req.Header.Set("Content-Type", mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	r := NewRouter()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
 func TestConfigtxlatorSanityCheckConfig(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/configtxlator/config/verify", bytes.NewReader(utils.MarshalOrPanic(&cb.Config{})))
 	rec := httptest.NewRecorder()
@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Codes of the errors returned by the REST API. Unlike the messages, which
+// are meant for people, codes do not change across releases.
+const (
+	// CodeNotFound is returned for requests to unknown paths
+	CodeNotFound = "NOT_FOUND"
+
+	// CodeUnknownMessageType is returned when the message type in the path
+	// is not a known proto message
+	CodeUnknownMessageType = "UNKNOWN_MESSAGE_TYPE"
+
+	// CodeUnsupportedMediaType is returned when a request does not have the
+	// content type the endpoint requires
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+
+	// CodeBodyTooLarge is returned when the request body exceeds the size
+	// limit of the server
+	CodeBodyTooLarge = "BODY_TOO_LARGE"
+
+	// CodeInvalidBody is returned when the request body cannot be read or
+	// decoded
+	CodeInvalidBody = "INVALID_BODY"
+
+	// CodeInvalidField is returned when a field of a form is missing or
+	// cannot be decoded, the field being named in the error
+	CodeInvalidField = "INVALID_FIELD"
+
+	// CodeOperationFailed is returned when the inputs are well formed but
+	// the operation cannot be performed on them, such as when an update does
+	// not apply to a config
+	CodeOperationFailed = "OPERATION_FAILED"
+
+	// CodeInternal is returned for failures of the server
+	CodeInternal = "INTERNAL_ERROR"
+)
+
+// Error is the JSON body of error responses
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Field is the form field the error is about, if any
+	Field string `json:"field,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, e *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// isBodyTooLarge reports whether err comes from reading past the limit of
+// the body set by limitBody
+func isBodyTooLarge(err error) bool {
+	return errors.As(err, new(*http.MaxBytesError))
+}
+
+// bodyError writes the error of reading or decoding the request body
+func bodyError(w http.ResponseWriter, err error) {
+	if isBodyTooLarge(err) {
+		writeError(w, http.StatusRequestEntityTooLarge, &Error{Code: CodeBodyTooLarge, Message: err.Error()})
+		return
+	}
+	writeError(w, http.StatusBadRequest, &Error{Code: CodeInvalidBody, Message: err.Error()})
+}
+
+// fieldError writes the error of reading or decoding the form field field
+func fieldError(w http.ResponseWriter, field string, err error) {
+	if isBodyTooLarge(err) {
+		writeError(w, http.StatusRequestEntityTooLarge, &Error{Code: CodeBodyTooLarge, Message: err.Error()})
+		return
+	}
+	writeError(w, http.StatusBadRequest, &Error{
+		Code:    CodeInvalidField,
+		Message: fmt.Sprintf("Error with field '%s': %s", field, err),
+		Field:   field,
+	})
+}
+
+// operationError writes the error of an operation on well formed inputs
+func operationError(w http.ResponseWriter, format string, args ...interface{}) {
+	writeError(w, http.StatusBadRequest, &Error{Code: CodeOperationFailed, Message: fmt.Sprintf(format, args...)})
+}
+
+func internalError(w http.ResponseWriter, format string, args ...interface{}) {
+	writeError(w, http.StatusInternalServerError, &Error{Code: CodeInternal, Message: fmt.Sprintf(format, args...)})
+}
+
+// writeResponse writes a successful response of type contentType
+func writeResponse(w http.ResponseWriter, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/mux"
+	"github.com/op/go-logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// accessLogger logs a line per request served by the router
+var accessLogger = logging.MustGetLogger("configtxlator/rest/access")
+
+// metrics are the Prometheus collectors of the requests served by a router
+type metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "configtxlator",
+			Name:      "requests_total",
+			Help:      "The number of requests served, by route, message type and status code.",
+		}, []string{"route", "msg_type", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "configtxlator",
+			Name:      "request_duration_seconds",
+			Help:      "The time taken to serve requests, by route and message type.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "msg_type"}),
+	}
+	m.registry.MustRegister(m.requests, m.duration)
+	m.registry.MustRegister(prometheus.NewGoCollector())
+	return m
+}
+
+// handler serves the collected metrics in the Prometheus text format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// statusRecorder records the status code and the size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(data)
+	s.size += n
+	return n, err
+}
+
+// msgTypeLabel returns the message type of the path of r, if any. Types
+// which are not registered are all labeled "unknown" so that callers cannot
+// grow the number of series.
+func msgTypeLabel(r *http.Request) string {
+	msgName, ok := mux.Vars(r)["msgName"]
+	if !ok {
+		return ""
+	}
+	if proto.MessageType(msgName) == nil {
+		return "unknown"
+	}
+	return msgName
+}
+
+// caller identifies the client of r by the common name of its certificate,
+// if it presented one
+func caller(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "-"
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+// instrument logs and measures the requests to the route whose path
+// template is route
+func (m *metrics) instrument(route string, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+		elapsed := time.Since(start)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		msgType := msgTypeLabel(r)
+		m.requests.WithLabelValues(route, msgType, strconv.Itoa(recorder.status)).Inc()
+		m.duration.WithLabelValues(route, msgType).Observe(elapsed.Seconds())
+
+		accessLogger.Infof("%s %s \"%s %s %s\" %d %d %s",
+			r.RemoteAddr, caller(r), r.Method, r.URL.RequestURI(), r.Proto, recorder.status, recorder.size, elapsed)
+	}
+}
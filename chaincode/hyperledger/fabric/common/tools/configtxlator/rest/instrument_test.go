@@ -0,0 +1,75 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/fabric/protos/utils"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	r := NewRouter()
+
+	url := fmt.Sprintf("/protolator/decode/%s", proto.MessageName(testProto))
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", url, bytes.NewReader(utils.MarshalOrPanic(testProto)))
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req, _ := http.NewRequest("POST", "/protolator/decode/NonExistantMsg", bytes.NewReader([]byte{}))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("GET", "/nope", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	metrics := rec.Body.String()
+	assert.Contains(t, metrics, `configtxlator_requests_total{code="200",msg_type="common.Block",route="/protolator/decode/{msgName}"} 2`)
+	assert.Contains(t, metrics, `configtxlator_requests_total{code="404",msg_type="unknown",route="/protolator/decode/{msgName}"} 1`)
+	assert.Contains(t, metrics, `configtxlator_requests_total{code="404",msg_type="",route="unmatched"} 1`)
+	assert.Contains(t, metrics, `configtxlator_request_duration_seconds_count{msg_type="common.Block",route="/protolator/decode/{msgName}"} 2`)
+
+	// routers do not share their metrics
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rec = httptest.NewRecorder()
+	NewRouter().ServeHTTP(rec, req)
+	assert.NotContains(t, rec.Body.String(), "common.Block")
+}
+
+func TestStatusRecorder(t *testing.T) {
+	rec := httptest.NewRecorder()
+	recorder := &statusRecorder{ResponseWriter: rec}
+	recorder.Write([]byte("foo"))
+	recorder.Write([]byte("bar"))
+	assert.Equal(t, http.StatusOK, recorder.status)
+	assert.Equal(t, 6, recorder.size)
+
+	recorder = &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	recorder.WriteHeader(http.StatusTeapot)
+	assert.Equal(t, http.StatusTeapot, recorder.status)
+	assert.Equal(t, 0, recorder.size)
+}
@@ -0,0 +1,458 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+)
+
+// OpenAPI serves the OpenAPI description of the REST API
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, "application/json", []byte(openAPIDocument))
+}
+
+// openAPIDocument is the OpenAPI 3.0 description of the routes of
+// NewRouter. Marshaled protos are sent and returned as
+// application/octet-stream.
+const openAPIDocument = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "configtxlator",
+    "description": "Translates Hyperledger Fabric protos to and from JSON, and computes and checks channel config updates.",
+    "version": "1.0"
+  },
+  "paths": {
+    "/protolator/encode/{msgName}": {
+      "post": {
+        "operationId": "encode",
+        "summary": "Encodes the JSON form of a proto message",
+        "parameters": [{"$ref": "#/components/parameters/msgName"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object"}}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/protolator/decode/{msgName}": {
+      "post": {
+        "operationId": "decode",
+        "summary": "Decodes a proto message to its JSON form",
+        "parameters": [{"$ref": "#/components/parameters/msgName"}],
+        "requestBody": {"$ref": "#/components/requestBodies/Proto"},
+        "responses": {
+          "200": {"$ref": "#/components/responses/JSON"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"}
+        }
+      }
+    },
+    "/protolator/inspect/{msgName}": {
+      "post": {
+        "operationId": "inspect",
+        "summary": "Decodes a common.Block or common.Envelope, including its transactions",
+        "parameters": [{"$ref": "#/components/parameters/msgName"}],
+        "requestBody": {"$ref": "#/components/requestBodies/Proto"},
+        "responses": {
+          "200": {"$ref": "#/components/responses/JSON"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"}
+        }
+      }
+    },
+    "/configtxlator/compute/update-from-configs": {
+      "post": {
+        "operationId": "computeUpdateFromConfigs",
+        "summary": "Computes the config update between two common.Config",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["original", "updated"],
+          "properties": {
+            "original": {"type": "string", "format": "binary"},
+            "updated": {"type": "string", "format": "binary"},
+            "channel": {"type": "string"}
+          }
+        }}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/compute/update-from-patch": {
+      "post": {
+        "operationId": "computeUpdateFromPatch",
+        "summary": "Computes the config update applying a JSON Patch or merge patch to a common.Config, marshaled or in its JSON form",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["original", "patch"],
+          "properties": {
+            "original": {"type": "string", "format": "binary"},
+            "patch": {"type": "string", "format": "binary"},
+            "channel": {"type": "string"}
+          }
+        }}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/compute/apply-update": {
+      "post": {
+        "operationId": "applyUpdate",
+        "summary": "Applies a common.ConfigUpdate to a common.Config",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["original", "update"],
+          "properties": {
+            "original": {"type": "string", "format": "binary"},
+            "update": {"type": "string", "format": "binary"}
+          }
+        }}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/channel/add-org": {
+      "post": {
+        "operationId": "addOrg",
+        "summary": "Computes the config update adding an org to the channel of a config block",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["block", "msp_id", "cacerts", "admincerts"],
+          "properties": {
+            "block": {"type": "string", "format": "binary"},
+            "msp_id": {"type": "string"},
+            "name": {"type": "string"},
+            "cacerts": {"type": "array", "items": {"type": "string", "format": "binary"}},
+            "admincerts": {"type": "array", "items": {"type": "string", "format": "binary"}},
+            "intermediatecerts": {"type": "array", "items": {"type": "string", "format": "binary"}},
+            "tlscacerts": {"type": "array", "items": {"type": "string", "format": "binary"}},
+            "tlsintermediatecerts": {"type": "array", "items": {"type": "string", "format": "binary"}},
+            "crls": {"type": "array", "items": {"type": "string", "format": "binary"}}
+          }
+        }}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/channel/remove-org": {
+      "post": {
+        "operationId": "removeOrg",
+        "summary": "Computes the config update removing an org from the channel of a config block",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["block", "name"],
+          "properties": {
+            "block": {"type": "string", "format": "binary"},
+            "name": {"type": "string"}
+          }
+        }}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/channel/batch-size": {
+      "post": {
+        "operationId": "setBatchSize",
+        "summary": "Computes the config update setting the orderer batch size of the channel of a config block, limits left empty being unchanged",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["block"],
+          "properties": {
+            "block": {"type": "string", "format": "binary"},
+            "max_message_count": {"type": "integer", "format": "int32", "minimum": 0},
+            "absolute_max_bytes": {"type": "integer", "format": "int32", "minimum": 0},
+            "preferred_max_bytes": {"type": "integer", "format": "int32", "minimum": 0}
+          }
+        }}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/channel/batch-timeout": {
+      "post": {
+        "operationId": "setBatchTimeout",
+        "summary": "Computes the config update setting the orderer batch timeout of the channel of a config block",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["block", "timeout"],
+          "properties": {
+            "block": {"type": "string", "format": "binary"},
+            "timeout": {"type": "string", "example": "2s"}
+          }
+        }}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/channel/anchor-peers": {
+      "post": {
+        "operationId": "setAnchorPeers",
+        "summary": "Computes the config update setting the anchor peers of an org in the channel of a config block",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["block", "org"],
+          "properties": {
+            "block": {"type": "string", "format": "binary"},
+            "org": {"type": "string"},
+            "anchor_peer": {"type": "array", "items": {"type": "string", "example": "peer0.org1.example.com:7051"}}
+          }
+        }}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/diff": {
+      "post": {
+        "operationId": "diffConfigs",
+        "summary": "Lists the differences between two common.Config",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["original", "updated"],
+          "properties": {
+            "original": {"type": "string", "format": "binary"},
+            "updated": {"type": "string", "format": "binary"},
+            "format": {
+              "type": "string",
+              "enum": ["json", "text"],
+              "default": "json",
+              "description": "The format of the changes. Unlike the diff command of the CLI, which defaults to text, the default is JSON."
+            }
+          }
+        }}}},
+        "responses": {
+          "200": {
+            "description": "The changes, sorted by path",
+            "content": {
+              "application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Change"}}},
+              "text/plain": {"schema": {"type": "string"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/envelope/wrap-update": {
+      "post": {
+        "operationId": "wrapUpdate",
+        "summary": "Wraps a common.ConfigUpdate in an unsigned CONFIG_UPDATE common.Envelope",
+        "requestBody": {"required": true, "content": {"multipart/form-data": {"schema": {
+          "type": "object",
+          "required": ["update"],
+          "properties": {
+            "update": {"type": "string", "format": "binary"},
+            "channel": {"type": "string"}
+          }
+        }}}},
+        "responses": {
+          "200": {"$ref": "#/components/responses/Proto"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "415": {"$ref": "#/components/responses/UnsupportedMediaType"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/configtxlator/config/verify": {
+      "post": {
+        "operationId": "sanityCheckConfig",
+        "summary": "Sanity checks a common.Config",
+        "requestBody": {"$ref": "#/components/requestBodies/Proto"},
+        "responses": {
+          "200": {
+            "description": "The findings of the check",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Messages"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/BodyTooLarge"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/healthz": {
+      "get": {
+        "operationId": "healthz",
+        "summary": "Reports that the server is up",
+        "responses": {"200": {"description": "The server is up", "content": {"application/json": {"schema": {
+          "type": "object",
+          "properties": {"status": {"type": "string", "enum": ["OK"]}}
+        }}}}}
+      }
+    },
+    "/metrics": {
+      "get": {
+        "operationId": "metrics",
+        "summary": "The Prometheus metrics of the requests served",
+        "responses": {"200": {"description": "The metrics in the Prometheus text format", "content": {"text/plain": {"schema": {"type": "string"}}}}}
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "operationId": "openAPI",
+        "summary": "This description of the API",
+        "responses": {"200": {"description": "The OpenAPI description", "content": {"application/json": {"schema": {"type": "object"}}}}}
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "msgName": {
+        "name": "msgName",
+        "in": "path",
+        "required": true,
+        "description": "The full name of the proto message, such as common.Block",
+        "schema": {"type": "string"}
+      }
+    },
+    "requestBodies": {
+      "Proto": {
+        "required": true,
+        "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}
+      }
+    },
+    "responses": {
+      "Proto": {
+        "description": "The marshaled proto message",
+        "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}
+      },
+      "JSON": {
+        "description": "The JSON form of the proto message",
+        "content": {"application/json": {"schema": {"type": "object"}}}
+      },
+      "BadRequest": {
+        "description": "The request or its fields are invalid, or the operation cannot be performed on them",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "NotFound": {
+        "description": "The message type is unknown",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "BodyTooLarge": {
+        "description": "The request body exceeds the limit of the server",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "UnsupportedMediaType": {
+        "description": "The request body is not a multipart form",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "InternalError": {
+        "description": "The server failed",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "required": ["code", "message"],
+        "properties": {
+          "code": {
+            "type": "string",
+            "enum": ["NOT_FOUND", "UNKNOWN_MESSAGE_TYPE", "UNSUPPORTED_MEDIA_TYPE", "BODY_TOO_LARGE", "INVALID_BODY", "INVALID_FIELD", "OPERATION_FAILED", "INTERNAL_ERROR"]
+          },
+          "message": {"type": "string"},
+          "field": {"type": "string", "description": "The form field the error is about"}
+        }
+      },
+      "Change": {
+        "type": "object",
+        "required": ["path", "element", "action"],
+        "properties": {
+          "path": {"type": "string"},
+          "element": {"type": "string", "enum": ["group", "value", "policy"]},
+          "action": {"type": "string", "enum": ["added", "removed", "changed"]},
+          "version": {"type": "integer", "format": "int64"},
+          "updated_version": {"type": "integer", "format": "int64"},
+          "original": {},
+          "updated": {},
+          "fields": {"type": "array", "items": {"$ref": "#/components/schemas/FieldChange"}}
+        }
+      },
+      "FieldChange": {
+        "type": "object",
+        "required": ["path"],
+        "properties": {
+          "path": {"type": "string"},
+          "original": {},
+          "updated": {}
+        }
+      },
+      "Messages": {
+        "type": "object",
+        "properties": {
+          "general_errors": {"type": "array", "items": {"type": "string"}, "nullable": true},
+          "element_warnings": {"type": "array", "items": {"$ref": "#/components/schemas/ElementMessage"}, "nullable": true},
+          "element_errors": {"type": "array", "items": {"$ref": "#/components/schemas/ElementMessage"}, "nullable": true}
+        }
+      },
+      "ElementMessage": {
+        "type": "object",
+        "required": ["path", "message"],
+        "properties": {
+          "path": {"type": "string"},
+          "message": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
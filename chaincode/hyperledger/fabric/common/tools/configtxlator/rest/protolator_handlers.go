@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"reflect"
 
+	"github.com/hyperledger/fabric/common/tools/configtxlator/inspect"
 	"github.com/hyperledger/fabric/common/tools/protolator"
 
 	// Import these to register the proto types
@@ -46,64 +47,89 @@ func getMsgType(r *http.Request) (proto.Message, error) {
 	return reflect.New(msgType.Elem()).Interface().(proto.Message), nil
 }
 
+// unknownMessageType writes the error of getMsgType
+func unknownMessageType(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusNotFound, &Error{Code: CodeUnknownMessageType, Message: err.Error()})
+}
+
 func Decode(w http.ResponseWriter, r *http.Request) {
 	msg, err := getMsgType(r)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintln(w, err)
+		unknownMessageType(w, err)
 		return
 	}
 
 	buf, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, err)
+		bodyError(w, err)
 		return
 	}
 
 	err = proto.Unmarshal(buf, msg)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, err)
+		bodyError(w, err)
 		return
 	}
 
 	var buffer bytes.Buffer
 	err = protolator.DeepMarshalJSON(&buffer, msg)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, err)
+		bodyError(w, err)
+		return
+	}
+
+	writeResponse(w, "application/json", buffer.Bytes())
+}
+
+// Inspect decodes a common.Block or common.Envelope like Decode, and also
+// the payloads which Decode cannot decode, such as endorser transactions
+func Inspect(w http.ResponseWriter, r *http.Request) {
+	msg, err := getMsgType(r)
+	if err != nil {
+		unknownMessageType(w, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+
+	err = proto.Unmarshal(buf, msg)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+
+	var buffer bytes.Buffer
+	err = inspect.Message(&buffer, msg)
+	if err != nil {
+		bodyError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	buffer.WriteTo(w)
+	writeResponse(w, "application/json", buffer.Bytes())
 }
 
 func Encode(w http.ResponseWriter, r *http.Request) {
 	msg, err := getMsgType(r)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintln(w, err)
+		unknownMessageType(w, err)
 		return
 	}
 
 	err = protolator.DeepUnmarshalJSON(r.Body, msg)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, err)
+		bodyError(w, err)
 		return
 	}
 
 	data, err := proto.Marshal(msg)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, err)
+		internalError(w, "%s", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(data)
+	writeResponse(w, "application/octet-stream", data)
 }
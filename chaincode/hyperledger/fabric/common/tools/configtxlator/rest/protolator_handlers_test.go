@@ -67,6 +67,36 @@ func TestProtolatorDecode(t *testing.T) {
 	assert.Equal(t, testOutput, compactJSON)
 }
 
+func TestProtolatorInspect(t *testing.T) {
+	data, err := proto.Marshal(testProto)
+	assert.NoError(t, err)
+
+	url := fmt.Sprintf("/protolator/inspect/%s", proto.MessageName(testProto))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(data))
+	rec := httptest.NewRecorder()
+	r := NewRouter()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Remove all the whitespace
+	compactJSON := strings.Replace(strings.Replace(strings.Replace(rec.Body.String(), "\n", "", -1), "\t", "", -1), " ", "", -1)
+
+	assert.Equal(t, `{"data":{"data":[{"signature":"YmFy"}]},"header":{"previous_hash":"Zm9v"},"metadata":{"metadata":[]}}`, compactJSON)
+}
+
+func TestProtolatorInspectUnsupportedProto(t *testing.T) {
+	url := fmt.Sprintf("/protolator/inspect/%s", proto.MessageName(&cb.Config{}))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(utils.MarshalOrPanic(&cb.Config{})))
+	rec := httptest.NewRecorder()
+	r := NewRouter()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
 func TestProtolatorEncode(t *testing.T) {
 
 	url := fmt.Sprintf("/protolator/encode/%s", proto.MessageName(testProto))
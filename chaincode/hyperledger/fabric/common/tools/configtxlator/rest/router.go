@@ -17,24 +17,110 @@ limitations under the License.
 package rest
 
 import (
+	"fmt"
+	"mime"
+	"net/http"
+
 	"github.com/gorilla/mux"
 )
 
+// DefaultMaxBodyBytes is the default limit of the size of request bodies
+const DefaultMaxBodyBytes = 32 << 20
+
+// limitBody rejects the requests whose body is larger than maxBodyBytes,
+// upfront if the request declares its length, when it is read otherwise
+func limitBody(maxBodyBytes int64, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBodyBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, &Error{
+				Code:    CodeBodyTooLarge,
+				Message: fmt.Sprintf("request body of %d bytes exceeds the limit of %d bytes", r.ContentLength, maxBodyBytes),
+			})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		handler(w, r)
+	}
+}
+
+// multipartForm rejects the requests which are not multipart forms, and
+// parses the form of the others so that the handler does not have to tell
+// malformed or oversized bodies from missing fields
+func multipartForm(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/form-data" {
+			writeError(w, http.StatusUnsupportedMediaType, &Error{
+				Code:    CodeUnsupportedMediaType,
+				Message: "request body must be of type multipart/form-data",
+			})
+			return
+		}
+		err = r.ParseMultipartForm(32 << 20)
+		if err != nil {
+			bodyError(w, err)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, &Error{
+		Code:    CodeNotFound,
+		Message: fmt.Sprintf("no endpoint for %s %s", r.Method, r.URL.Path),
+	})
+}
+
+// Healthz reports that the server is up. It has no dependencies to check.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, "application/json", []byte(`{"status":"OK"}`+"\n"))
+}
+
 func NewRouter() *mux.Router {
+	return NewRouterWithMaxBodyBytes(DefaultMaxBodyBytes)
+}
+
+// NewRouterWithMaxBodyBytes returns the router of the REST API, which
+// rejects the requests whose body is larger than maxBodyBytes. Requests are
+// logged, and measured by the Prometheus metrics served at /metrics.
+func NewRouterWithMaxBodyBytes(maxBodyBytes int64) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
-	router.
-		HandleFunc("/protolator/encode/{msgName}", Encode).
-		Methods("POST")
-
-	router.
-		HandleFunc("/protolator/decode/{msgName}", Decode).
-		Methods("POST")
-	router.
-		HandleFunc("/configtxlator/compute/update-from-configs", ComputeUpdateFromConfigs).
-		Methods("POST")
-	router.
-		HandleFunc("/configtxlator/config/verify", SanityCheckConfig).
-		Methods("POST")
+	metrics := newMetrics()
+	router.NotFoundHandler = metrics.instrument("unmatched", http.HandlerFunc(notFound))
+
+	handle := func(path, method string, handler http.Handler) {
+		router.
+			Handle(path, metrics.instrument(path, handler)).
+			Methods(method)
+	}
+	// raw takes the message as the request body, of any content type
+	raw := func(handler http.HandlerFunc) http.Handler {
+		return limitBody(maxBodyBytes, handler)
+	}
+	// form takes its inputs as the fields of a multipart form
+	form := func(handler http.HandlerFunc) http.Handler {
+		return limitBody(maxBodyBytes, multipartForm(handler))
+	}
+
+	handle("/openapi.json", "GET", http.HandlerFunc(OpenAPI))
+	handle("/healthz", "GET", http.HandlerFunc(Healthz))
+	handle("/metrics", "GET", metrics.handler())
+
+	handle("/protolator/encode/{msgName}", "POST", raw(Encode))
+	handle("/protolator/decode/{msgName}", "POST", raw(Decode))
+	handle("/protolator/inspect/{msgName}", "POST", raw(Inspect))
+	handle("/configtxlator/compute/update-from-configs", "POST", form(ComputeUpdateFromConfigs))
+	handle("/configtxlator/compute/update-from-patch", "POST", form(ComputeUpdateFromPatch))
+	handle("/configtxlator/compute/apply-update", "POST", form(ApplyUpdate))
+	handle("/configtxlator/channel/add-org", "POST", form(AddOrg))
+	handle("/configtxlator/channel/remove-org", "POST", form(RemoveOrg))
+	handle("/configtxlator/channel/batch-size", "POST", form(SetBatchSize))
+	handle("/configtxlator/channel/batch-timeout", "POST", form(SetBatchTimeout))
+	handle("/configtxlator/channel/anchor-peers", "POST", form(SetAnchorPeers))
+	handle("/configtxlator/diff", "POST", form(DiffConfigs))
+	handle("/configtxlator/envelope/wrap-update", "POST", form(WrapUpdate))
+	handle("/configtxlator/config/verify", "POST", raw(SanityCheckConfig))
 
 	return router
 }
@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cb "github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/utils"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func responseError(t *testing.T, rec *httptest.ResponseRecorder) *Error {
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	e := &Error{}
+	err := json.Unmarshal(rec.Body.Bytes(), e)
+	assert.NoError(t, err, rec.Body.String())
+	return e
+}
+
+func TestOpenAPI(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	r := NewRouter()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	document := struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}{}
+	err := json.Unmarshal(rec.Body.Bytes(), &document)
+	assert.NoError(t, err)
+	assert.Equal(t, "3.0.0", document.OpenAPI)
+
+	// every route is described, with its method
+	routes := 0
+	err = r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		routes++
+		assert.Contains(t, document.Paths, path)
+		for _, method := range []string{"get", "post"} {
+			req, _ := http.NewRequest(strings.ToUpper(method), strings.Replace(path, "{msgName}", "common.Block", 1), nil)
+			var match mux.RouteMatch
+			if route.Match(req, &match) {
+				assert.Contains(t, document.Paths[path], method, path)
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, document.Paths, routes)
+}
+
+func TestHealthz(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	rec := httptest.NewRecorder()
+	r := NewRouter()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"status":"OK"}`, rec.Body.String())
+}
+
+func TestRouterErrors(t *testing.T) {
+	r := NewRouter()
+
+	req, _ := http.NewRequest("POST", "/protolator/decode/NonExistantMsg", bytes.NewReader([]byte{}))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, CodeUnknownMessageType, responseError(t, rec).Code)
+
+	req, _ = http.NewRequest("POST", "/protolator/frobnicate", bytes.NewReader([]byte{}))
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, CodeNotFound, responseError(t, rec).Code)
+
+	req, _ = http.NewRequest("POST", "/configtxlator/config/verify", bytes.NewReader([]byte("Garbage")))
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, CodeInvalidBody, responseError(t, rec).Code)
+
+	rec = channelOperationRequest(t, "/configtxlator/channel/add-org", channelOperationBlock(t), nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	e := responseError(t, rec)
+	assert.Equal(t, CodeInvalidField, e.Code)
+	assert.Equal(t, "msp_id", e.Field)
+
+	rec = channelOperationRequest(t, "/configtxlator/channel/remove-org", channelOperationBlock(t), map[string][]string{"name": {"Org2MSP"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, CodeOperationFailed, responseError(t, rec).Code)
+}
+
+func TestRouterContentTypes(t *testing.T) {
+	r := NewRouter()
+
+	url := fmt.Sprintf("/protolator/decode/%s", proto.MessageName(testProto))
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(utils.MarshalOrPanic(testProto)))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	rec = channelOperationRequest(t, "/configtxlator/channel/batch-timeout", channelOperationBlock(t), map[string][]string{"timeout": {"1s"}})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+
+	// form endpoints only take multipart forms
+	req, _ = http.NewRequest("POST", "/configtxlator/channel/batch-timeout", bytes.NewReader(utils.MarshalOrPanic(channelOperationBlock(t))))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	assert.Equal(t, CodeUnsupportedMediaType, responseError(t, rec).Code)
+}
+
+func TestRouterMaxBodyBytes(t *testing.T) {
+	r := NewRouterWithMaxBodyBytes(16)
+	url := fmt.Sprintf("/protolator/decode/%s", proto.MessageName(testProto))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(utils.MarshalOrPanic(&cb.Block{})))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// the length is declared
+	req, _ = http.NewRequest("POST", url, bytes.NewReader(make([]byte, 17)))
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, CodeBodyTooLarge, responseError(t, rec).Code)
+
+	// the length is unknown until the body is read
+	req, _ = http.NewRequest("POST", url, io.MultiReader(bytes.NewReader(make([]byte, 17))))
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, CodeBodyTooLarge, responseError(t, rec).Code)
+
+	buffer := &bytes.Buffer{}
+	mpw := multipart.NewWriter(buffer)
+	err := mpw.WriteField("timeout", "1s")
+	assert.NoError(t, err)
+	err = mpw.Close()
+	assert.NoError(t, err)
+	req, _ = http.NewRequest("POST", "/configtxlator/channel/batch-timeout", io.MultiReader(buffer))
+	req.Header.Set("Content-Type", mpw.FormDataContentType())
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, CodeBodyTooLarge, responseError(t, rec).Code)
+}
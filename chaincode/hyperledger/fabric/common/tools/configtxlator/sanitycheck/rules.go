@@ -0,0 +1,447 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sanitycheck
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/common/config"
+	mspconfig "github.com/hyperledger/fabric/common/config/msp"
+	"github.com/hyperledger/fabric/msp"
+	cb "github.com/hyperledger/fabric/protos/common"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// DefaultExpiryWindow is how long before they expire the default rules
+// report certificates
+const DefaultExpiryWindow = 30 * 24 * time.Hour
+
+// walkGroups calls fn with each group of config and its path, in order
+func walkGroups(config *cb.Config, fn func(path string, group *cb.ConfigGroup)) {
+	if config.GetChannelGroup() == nil {
+		return
+	}
+	walkGroup("", config.ChannelGroup, fn)
+}
+
+func walkGroup(path string, group *cb.ConfigGroup, fn func(path string, group *cb.ConfigGroup)) {
+	fn(path, group)
+	for _, name := range sortedGroupNames(group) {
+		walkGroup(path+".groups."+name, group.Groups[name], fn)
+	}
+}
+
+func sortedGroupNames(group *cb.ConfigGroup) []string {
+	names := make([]string, 0, len(group.Groups))
+	for name := range group.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedPolicyNames(group *cb.ConfigGroup) []string {
+	names := make([]string, 0, len(group.Policies))
+	for name := range group.Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mspValue is an MSP value of a config holding a fabric MSP
+type mspValue struct {
+	path   string
+	fabric *mspprotos.FabricMSPConfig
+}
+
+// mspValues returns the fabric MSP values of config. Values which cannot be
+// unmarshaled are skipped, as loading the config reports them.
+func mspValues(config *cb.Config) []*mspValue {
+	var values []*mspValue
+	walkGroups(config, func(path string, group *cb.ConfigGroup) {
+		value, ok := group.Values[mspconfig.MSPKey]
+		if !ok {
+			return
+		}
+		mspConf := &mspprotos.MSPConfig{}
+		if proto.Unmarshal(value.Value, mspConf) != nil || mspConf.Type != int32(msp.FABRIC) {
+			return
+		}
+		fabricConf := &mspprotos.FabricMSPConfig{}
+		if proto.Unmarshal(mspConf.Config, fabricConf) != nil {
+			return
+		}
+		values = append(values, &mspValue{
+			path:   path + ".values." + mspconfig.MSPKey,
+			fabric: fabricConf,
+		})
+	})
+	return values
+}
+
+// parseCertificate parses a PEM encoded certificate
+func parseCertificate(pemBytes []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// PolicyPrincipals reports signature policies whose principals cannot be
+// unmarshaled as errors, and those referring to MSP IDs which are not defined
+// in the config as warnings
+type PolicyPrincipals struct{}
+
+func (r *PolicyPrincipals) Check(input *Input) []*Finding {
+	var findings []*Finding
+	walkGroups(input.Config, func(path string, group *cb.ConfigGroup) {
+		for _, policyName := range sortedPolicyNames(group) {
+			configPolicy := group.Policies[policyName]
+			report := func(severity Severity, message string) {
+				findings = append(findings, &Finding{
+					Severity: severity,
+					Path:     path + ".policies." + policyName,
+					Message:  message,
+				})
+			}
+
+			if configPolicy.Policy == nil {
+				report(Error, fmt.Sprintf("no policy value set for %s", policyName))
+				continue
+			}
+
+			if configPolicy.Policy.Type != int32(cb.Policy_SIGNATURE) {
+				continue
+			}
+			spe := &cb.SignaturePolicyEnvelope{}
+			err := proto.Unmarshal(configPolicy.Policy.Value, spe)
+			if err != nil {
+				report(Error, fmt.Sprintf("error unmarshaling policy value to SignaturePolicyEnvelope: %s", err))
+				continue
+			}
+
+			for i, identity := range spe.Identities {
+				var mspID string
+				switch identity.PrincipalClassification {
+				case mspprotos.MSPPrincipal_ROLE:
+					role := &mspprotos.MSPRole{}
+					err = proto.Unmarshal(identity.Principal, role)
+					if err != nil {
+						report(Error, fmt.Sprintf("value of identities array at index %d is of type ROLE, but could not be unmarshaled to msp.MSPRole: %s", i, err))
+						continue
+					}
+					mspID = role.MspIdentifier
+				case mspprotos.MSPPrincipal_ORGANIZATION_UNIT:
+					ou := &mspprotos.OrganizationUnit{}
+					err = proto.Unmarshal(identity.Principal, ou)
+					if err != nil {
+						report(Error, fmt.Sprintf("value of identities array at index %d is of type ORGANIZATION_UNIT, but could not be unmarshaled to msp.OrganizationUnit: %s", i, err))
+						continue
+					}
+					mspID = ou.MspIdentifier
+				default:
+					continue
+				}
+
+				_, ok := input.MSPIDs[mspID]
+				if !ok {
+					report(Warning, fmt.Sprintf("identity principal at index %d refers to MSP ID '%s', which is not an MSP in the network", i, mspID))
+				}
+			}
+		}
+	})
+	return findings
+}
+
+// CertificateExpiry reports the root, intermediate, TLS root and TLS
+// intermediate certificates of MSPs which cannot be parsed or have expired as
+// errors, and those which expire within Window or are not valid yet as
+// warnings
+type CertificateExpiry struct {
+	Window time.Duration
+}
+
+func (r *CertificateExpiry) Check(input *Input) []*Finding {
+	var findings []*Finding
+	for _, value := range mspValues(input.Config) {
+		for _, certs := range []struct {
+			kind string
+			pems [][]byte
+		}{
+			{"root", value.fabric.RootCerts},
+			{"intermediate", value.fabric.IntermediateCerts},
+			{"TLS root", value.fabric.TlsRootCerts},
+			{"TLS intermediate", value.fabric.TlsIntermediateCerts},
+		} {
+			for i, pemBytes := range certs.pems {
+				report := func(severity Severity, format string, args ...interface{}) {
+					findings = append(findings, &Finding{
+						Severity: severity,
+						Path:     value.path,
+						Message:  fmt.Sprintf("%s certificate at index %d ", certs.kind, i) + fmt.Sprintf(format, args...),
+					})
+				}
+
+				cert, err := parseCertificate(pemBytes)
+				switch {
+				case err != nil:
+					report(Error, "cannot be parsed: %s", err)
+				case input.Now.After(cert.NotAfter):
+					report(Error, "(%s) expired at %s", cert.Subject.CommonName, cert.NotAfter)
+				case input.Now.Add(r.Window).After(cert.NotAfter):
+					report(Warning, "(%s) expires at %s", cert.Subject.CommonName, cert.NotAfter)
+				case input.Now.Before(cert.NotBefore):
+					report(Warning, "(%s) is not valid before %s", cert.Subject.CommonName, cert.NotBefore)
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// AdminCertificates reports the admin certificates of MSPs which cannot be
+// parsed or are not issued by a root certificate of their MSP, directly or
+// through its intermediate certificates, as errors
+type AdminCertificates struct{}
+
+func (r *AdminCertificates) Check(input *Input) []*Finding {
+	var findings []*Finding
+	for _, value := range mspValues(input.Config) {
+		roots := x509.NewCertPool()
+		for _, pemBytes := range value.fabric.RootCerts {
+			// unparseable certificates are reported by CertificateExpiry
+			if cert, err := parseCertificate(pemBytes); err == nil {
+				roots.AddCert(cert)
+			}
+		}
+		intermediates := x509.NewCertPool()
+		for _, pemBytes := range value.fabric.IntermediateCerts {
+			if cert, err := parseCertificate(pemBytes); err == nil {
+				intermediates.AddCert(cert)
+			}
+		}
+
+		for i, pemBytes := range value.fabric.Admins {
+			cert, err := parseCertificate(pemBytes)
+			if err != nil {
+				findings = append(findings, &Finding{
+					Severity: Error,
+					Path:     value.path,
+					Message:  fmt.Sprintf("admin certificate at index %d cannot be parsed: %s", i, err),
+				})
+				continue
+			}
+
+			// the chain is verified when the certificate was issued, as
+			// expired certificates are reported by CertificateExpiry
+			_, err = cert.Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				CurrentTime:   cert.NotBefore,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			})
+			if err != nil {
+				findings = append(findings, &Finding{
+					Severity: Error,
+					Path:     value.path,
+					Message: fmt.Sprintf("admin certificate at index %d (%s) is not issued by a root certificate of MSP %s: %s",
+						i, cert.Subject.CommonName, value.fabric.Name, err),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// ImplicitMetaSubPolicies reports implicit meta policies as errors when none
+// of the sub-groups of their group define their sub-policy, which makes ANY
+// and MAJORITY policies unsatisfiable and ALL policies always satisfied.
+// Groups without sub-groups, such as the orderer group of a config without
+// orgs, are not reported.
+type ImplicitMetaSubPolicies struct{}
+
+func (r *ImplicitMetaSubPolicies) Check(input *Input) []*Finding {
+	var findings []*Finding
+	walkGroups(input.Config, func(path string, group *cb.ConfigGroup) {
+		for _, policyName := range sortedPolicyNames(group) {
+			configPolicy := group.Policies[policyName]
+			if configPolicy.Policy == nil || configPolicy.Policy.Type != int32(cb.Policy_IMPLICIT_META) {
+				continue
+			}
+			report := func(message string) {
+				findings = append(findings, &Finding{
+					Severity: Error,
+					Path:     path + ".policies." + policyName,
+					Message:  message,
+				})
+			}
+
+			imp := &cb.ImplicitMetaPolicy{}
+			err := proto.Unmarshal(configPolicy.Policy.Value, imp)
+			if err != nil {
+				report(fmt.Sprintf("error unmarshaling policy value to ImplicitMetaPolicy: %s", err))
+				continue
+			}
+			if len(group.Groups) == 0 {
+				continue
+			}
+
+			defined := false
+			for _, subGroup := range group.Groups {
+				if _, ok := subGroup.Policies[imp.SubPolicy]; ok {
+					defined = true
+					break
+				}
+			}
+			if !defined {
+				report(fmt.Sprintf("%s policy refers to sub-policy '%s', which is defined by none of the sub-groups %v",
+					imp.Rule, imp.SubPolicy, sortedGroupNames(group)))
+			}
+		}
+	})
+	return findings
+}
+
+// BatchSize reports orderer batch sizes which cannot be unmarshaled, have a
+// limit set to 0, or a preferred maximum exceeding their absolute maximum as
+// errors
+type BatchSize struct{}
+
+func (r *BatchSize) Check(input *Input) []*Finding {
+	orderer, ok := input.Config.GetChannelGroup().GetGroups()[config.OrdererGroupKey]
+	if !ok {
+		return nil
+	}
+	value, ok := orderer.Values[config.BatchSizeKey]
+	if !ok {
+		return nil
+	}
+
+	report := func(format string, args ...interface{}) []*Finding {
+		return []*Finding{{
+			Severity: Error,
+			Path:     ".groups." + config.OrdererGroupKey + ".values." + config.BatchSizeKey,
+			Message:  fmt.Sprintf(format, args...),
+		}}
+	}
+
+	batchSize := &ab.BatchSize{}
+	err := proto.Unmarshal(value.Value, batchSize)
+	switch {
+	case err != nil:
+		return report("error unmarshaling value to BatchSize: %s", err)
+	case batchSize.MaxMessageCount == 0:
+		return report("max message count is 0")
+	case batchSize.AbsoluteMaxBytes == 0:
+		return report("absolute max bytes is 0")
+	case batchSize.PreferredMaxBytes > batchSize.AbsoluteMaxBytes:
+		return report("preferred max bytes %d exceeds absolute max bytes %d", batchSize.PreferredMaxBytes, batchSize.AbsoluteMaxBytes)
+	}
+	return nil
+}
+
+// DuplicateMSPIDs reports MSPs defined with the same ID as an MSP defined
+// earlier in the config, but with a different config, as errors. The same
+// MSP may be defined in several groups, such as for an org which is a member
+// of several consortiums.
+type DuplicateMSPIDs struct{}
+
+func (r *DuplicateMSPIDs) Check(input *Input) []*Finding {
+	var findings []*Finding
+	defined := make(map[string]*mspValue)
+	for _, value := range mspValues(input.Config) {
+		first, ok := defined[value.fabric.Name]
+		if !ok {
+			defined[value.fabric.Name] = value
+			continue
+		}
+		if !proto.Equal(first.fabric, value.fabric) {
+			findings = append(findings, &Finding{
+				Severity: Error,
+				Path:     value.path,
+				Message:  fmt.Sprintf("MSP ID '%s' is already defined at %s with a different config", value.fabric.Name, first.path),
+			})
+		}
+	}
+	return findings
+}
+
+// AnchorPeerHosts reports anchor peers of application orgs which have no host
+// or an invalid port as errors, and those whose host cannot be resolved as
+// warnings, as the host may only be known in the network of the peers
+type AnchorPeerHosts struct {
+	// LookupHost resolves a host, net.LookupHost if nil
+	LookupHost func(host string) ([]string, error)
+}
+
+func (r *AnchorPeerHosts) Check(input *Input) []*Finding {
+	application, ok := input.Config.GetChannelGroup().GetGroups()[config.ApplicationGroupKey]
+	if !ok {
+		return nil
+	}
+	lookupHost := r.LookupHost
+	if lookupHost == nil {
+		lookupHost = net.LookupHost
+	}
+
+	var findings []*Finding
+	for _, orgName := range sortedGroupNames(application) {
+		value, ok := application.Groups[orgName].Values[config.AnchorPeersKey]
+		if !ok {
+			continue
+		}
+		report := func(severity Severity, message string) {
+			findings = append(findings, &Finding{
+				Severity: severity,
+				Path:     ".groups." + config.ApplicationGroupKey + ".groups." + orgName + ".values." + config.AnchorPeersKey,
+				Message:  message,
+			})
+		}
+
+		anchorPeers := &pb.AnchorPeers{}
+		err := proto.Unmarshal(value.Value, anchorPeers)
+		if err != nil {
+			report(Error, fmt.Sprintf("error unmarshaling value to AnchorPeers: %s", err))
+			continue
+		}
+
+		for i, anchorPeer := range anchorPeers.AnchorPeers {
+			switch {
+			case anchorPeer.Host == "":
+				report(Error, fmt.Sprintf("anchor peer at index %d has no host", i))
+			case anchorPeer.Port <= 0 || anchorPeer.Port > 65535:
+				report(Error, fmt.Sprintf("anchor peer at index %d (%s) has invalid port %d", i, anchorPeer.Host, anchorPeer.Port))
+			default:
+				if _, err := lookupHost(anchorPeer.Host); err != nil {
+					report(Warning, fmt.Sprintf("anchor peer at index %d host %s cannot be resolved: %s", i, anchorPeer.Host, err))
+				}
+			}
+		}
+	}
+	return findings
+}
@@ -0,0 +1,322 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sanitycheck
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/cauthdsl"
+	"github.com/hyperledger/fabric/common/config"
+	mspconfig "github.com/hyperledger/fabric/common/config/msp"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/ca"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
+	"github.com/hyperledger/fabric/msp"
+	cb "github.com/hyperledger/fabric/protos/common"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func certPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// newCA returns a CA of org1.example.com whose certificate is valid for
+// validity, written to a directory of dir
+func newCA(t *testing.T, dir, name string, validity time.Duration) *ca.CA {
+	signCA, err := ca.NewCA(filepath.Join(dir, name), "org1.example.com", name,
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{Validity: validity})
+	assert.NoError(t, err)
+	return signCA
+}
+
+// newAdminCert returns the certificate of an admin issued by signCA
+func newAdminCert(t *testing.T, dir string, signCA *ca.CA) *x509.Certificate {
+	keystore := filepath.Join(dir, "keystore")
+	priv, _, err := csp.GeneratePrivateKey(keystore)
+	assert.NoError(t, err)
+	pub, err := csp.GetECPublicKey(priv)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(dir, "admin"), 0755)
+	assert.NoError(t, err)
+	cert, err := signCA.SignCertificate(filepath.Join(dir, "admin"), "Admin@org1.example.com", nil, nil, pub,
+		x509.KeyUsageDigitalSignature, nil, ca.CertOptions{})
+	assert.NoError(t, err)
+	return cert
+}
+
+// mspGroup returns an org group holding the fabric MSP fabricConf
+func mspGroup(fabricConf *mspprotos.FabricMSPConfig) *cb.ConfigGroup {
+	group := cb.NewConfigGroup()
+	group.Values[mspconfig.MSPKey] = &cb.ConfigValue{
+		Value: utils.MarshalOrPanic(&mspprotos.MSPConfig{
+			Type:   int32(msp.FABRIC),
+			Config: utils.MarshalOrPanic(fabricConf),
+		}),
+	}
+	return group
+}
+
+// applicationConfig returns a config with an application group holding orgs
+func applicationConfig(orgs map[string]*cb.ConfigGroup) *cb.Config {
+	application := cb.NewConfigGroup()
+	application.Groups = orgs
+	channelGroup := cb.NewConfigGroup()
+	channelGroup.Groups[config.ApplicationGroupKey] = application
+	return &cb.Config{ChannelGroup: channelGroup}
+}
+
+func assertFindings(t *testing.T, expected []*Finding, actual []*Finding) {
+	if !assert.Len(t, actual, len(expected)) {
+		for _, finding := range actual {
+			t.Logf("found %s: %s", finding.Path, finding.Message)
+		}
+		return
+	}
+	for i := range expected {
+		assert.Equal(t, expected[i].Severity, actual[i].Severity, actual[i].Message)
+		assert.Equal(t, expected[i].Path, actual[i].Path, actual[i].Message)
+	}
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sanitycheck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	longCA := newCA(t, dir, "ca", 10*365*24*time.Hour)
+	shortCA := newCA(t, dir, "shortca", 10*24*time.Hour)
+	conf := applicationConfig(map[string]*cb.ConfigGroup{
+		"Org1MSP": mspGroup(&mspprotos.FabricMSPConfig{
+			Name:         "Org1MSP",
+			RootCerts:    [][]byte{certPEM(longCA.SignCert)},
+			TlsRootCerts: [][]byte{certPEM(shortCA.SignCert)},
+		}),
+		"Org2MSP": mspGroup(&mspprotos.FabricMSPConfig{
+			Name:              "Org2MSP",
+			RootCerts:         [][]byte{certPEM(longCA.SignCert)},
+			IntermediateCerts: [][]byte{[]byte("garbage")},
+		}),
+	})
+	org1Path := ".groups.Application.groups.Org1MSP.values.MSP"
+	org2Path := ".groups.Application.groups.Org2MSP.values.MSP"
+	rule := &CertificateExpiry{Window: DefaultExpiryWindow}
+
+	// the short lived TLS root expires within the window
+	input := NewInput(conf)
+	assertFindings(t, []*Finding{
+		{Severity: Warning, Path: org1Path},
+		{Severity: Error, Path: org2Path},
+	}, rule.Check(input))
+
+	input.Now = time.Now().Add(11 * 24 * time.Hour)
+	assertFindings(t, []*Finding{
+		{Severity: Error, Path: org1Path},
+		{Severity: Error, Path: org2Path},
+	}, rule.Check(input))
+
+	input.Now = time.Now().Add(-time.Hour)
+	findings := rule.Check(input)
+	assertFindings(t, []*Finding{
+		{Severity: Warning, Path: org1Path},
+		{Severity: Warning, Path: org1Path},
+		{Severity: Warning, Path: org2Path},
+		{Severity: Error, Path: org2Path},
+	}, findings)
+	assert.Contains(t, findings[0].Message, "root certificate at index 0 (ca) is not valid before")
+}
+
+func TestAdminCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sanitycheck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rootCA := newCA(t, dir, "ca", 0)
+	intermediateCA, err := rootCA.NewIntermediateCA(filepath.Join(dir, "ica"), "org1.example.com", "ica",
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err)
+	otherCA := newCA(t, dir, "otherca", 0)
+
+	conf := applicationConfig(map[string]*cb.ConfigGroup{
+		"Org1MSP": mspGroup(&mspprotos.FabricMSPConfig{
+			Name:              "Org1MSP",
+			RootCerts:         [][]byte{certPEM(rootCA.SignCert)},
+			IntermediateCerts: [][]byte{certPEM(intermediateCA.SignCert)},
+			Admins: [][]byte{
+				certPEM(newAdminCert(t, dir, rootCA)),
+				certPEM(newAdminCert(t, dir, intermediateCA)),
+				certPEM(newAdminCert(t, dir, otherCA)),
+				[]byte("garbage"),
+			},
+		}),
+	})
+
+	findings := (&AdminCertificates{}).Check(NewInput(conf))
+	path := ".groups.Application.groups.Org1MSP.values.MSP"
+	assertFindings(t, []*Finding{
+		{Severity: Error, Path: path},
+		{Severity: Error, Path: path},
+	}, findings)
+	assert.Contains(t, findings[0].Message, "admin certificate at index 2")
+	assert.Contains(t, findings[1].Message, "admin certificate at index 3")
+}
+
+func implicitMetaPolicy(subPolicy string) *cb.ConfigPolicy {
+	return &cb.ConfigPolicy{
+		Policy: &cb.Policy{
+			Type: int32(cb.Policy_IMPLICIT_META),
+			Value: utils.MarshalOrPanic(&cb.ImplicitMetaPolicy{
+				SubPolicy: subPolicy,
+				Rule:      cb.ImplicitMetaPolicy_MAJORITY,
+			}),
+		},
+	}
+}
+
+func TestImplicitMetaSubPolicies(t *testing.T) {
+	org1 := cb.NewConfigGroup()
+	org1.Policies[mspconfig.AdminsPolicyKey] = &cb.ConfigPolicy{
+		Policy: &cb.Policy{
+			Type:  int32(cb.Policy_SIGNATURE),
+			Value: utils.MarshalOrPanic(cauthdsl.SignedByMspAdmin("Org1MSP")),
+		},
+	}
+	// org groups have no sub-groups to refer to
+	org1.Policies["Readers"] = implicitMetaPolicy("Readers")
+	conf := applicationConfig(map[string]*cb.ConfigGroup{"Org1MSP": org1, "Org2MSP": cb.NewConfigGroup()})
+	application := conf.ChannelGroup.Groups[config.ApplicationGroupKey]
+	application.Policies["Admins"] = implicitMetaPolicy("Admins")
+	application.Policies["Writers"] = implicitMetaPolicy("Writers")
+	application.Policies["Corrupt"] = &cb.ConfigPolicy{
+		Policy: &cb.Policy{
+			Type:  int32(cb.Policy_IMPLICIT_META),
+			Value: []byte("garbage"),
+		},
+	}
+
+	findings := (&ImplicitMetaSubPolicies{}).Check(NewInput(conf))
+	assertFindings(t, []*Finding{
+		{Severity: Error, Path: ".groups.Application.policies.Corrupt"},
+		{Severity: Error, Path: ".groups.Application.policies.Writers"},
+	}, findings)
+	assert.Equal(t, "MAJORITY policy refers to sub-policy 'Writers', which is defined by none of the sub-groups [Org1MSP Org2MSP]", findings[1].Message)
+}
+
+func batchSizeConfig(batchSize *ab.BatchSize) *cb.Config {
+	orderer := cb.NewConfigGroup()
+	orderer.Values[config.BatchSizeKey] = &cb.ConfigValue{Value: utils.MarshalOrPanic(batchSize)}
+	channelGroup := cb.NewConfigGroup()
+	channelGroup.Groups[config.OrdererGroupKey] = orderer
+	return &cb.Config{ChannelGroup: channelGroup}
+}
+
+func TestBatchSize(t *testing.T) {
+	path := ".groups.Orderer.values.BatchSize"
+	for _, testCase := range []struct {
+		batchSize *ab.BatchSize
+		findings  []*Finding
+	}{
+		{&ab.BatchSize{MaxMessageCount: 10, AbsoluteMaxBytes: 1024, PreferredMaxBytes: 512}, nil},
+		{&ab.BatchSize{MaxMessageCount: 10, AbsoluteMaxBytes: 1024, PreferredMaxBytes: 1024}, nil},
+		{&ab.BatchSize{MaxMessageCount: 10, AbsoluteMaxBytes: 1024, PreferredMaxBytes: 2048}, []*Finding{{Severity: Error, Path: path}}},
+		{&ab.BatchSize{MaxMessageCount: 0, AbsoluteMaxBytes: 1024, PreferredMaxBytes: 512}, []*Finding{{Severity: Error, Path: path}}},
+		{&ab.BatchSize{MaxMessageCount: 10, AbsoluteMaxBytes: 0, PreferredMaxBytes: 0}, []*Finding{{Severity: Error, Path: path}}},
+	} {
+		assertFindings(t, testCase.findings, (&BatchSize{}).Check(NewInput(batchSizeConfig(testCase.batchSize))))
+	}
+
+	// configs without an orderer group are fine
+	assert.Empty(t, (&BatchSize{}).Check(NewInput(&cb.Config{})))
+}
+
+func TestDuplicateMSPIDs(t *testing.T) {
+	org1 := &mspprotos.FabricMSPConfig{Name: "Org1MSP", RootCerts: [][]byte{[]byte("root")}}
+	conf := applicationConfig(map[string]*cb.ConfigGroup{
+		"Org1":      mspGroup(org1),
+		"Org1Again": mspGroup(org1),
+		"Org1Other": mspGroup(&mspprotos.FabricMSPConfig{Name: "Org1MSP", RootCerts: [][]byte{[]byte("other")}}),
+		"Org2":      mspGroup(&mspprotos.FabricMSPConfig{Name: "Org2MSP"}),
+	})
+
+	findings := (&DuplicateMSPIDs{}).Check(NewInput(conf))
+	assertFindings(t, []*Finding{
+		{Severity: Error, Path: ".groups.Application.groups.Org1Other.values.MSP"},
+	}, findings)
+	assert.Equal(t, "MSP ID 'Org1MSP' is already defined at .groups.Application.groups.Org1.values.MSP with a different config", findings[0].Message)
+}
+
+func TestAnchorPeerHosts(t *testing.T) {
+	org1 := cb.NewConfigGroup()
+	org1.Values[config.AnchorPeersKey] = &cb.ConfigValue{
+		Value: utils.MarshalOrPanic(&pb.AnchorPeers{AnchorPeers: []*pb.AnchorPeer{
+			{Host: "peer0.org1.example.com", Port: 7051},
+			{Host: "peer1.org1.example.com", Port: 7051},
+			{Host: "peer2.org1.example.com", Port: 0},
+			{Port: 7051},
+		}}),
+	}
+	org2 := cb.NewConfigGroup()
+	org2.Values[config.AnchorPeersKey] = &cb.ConfigValue{Value: []byte("garbage")}
+	conf := applicationConfig(map[string]*cb.ConfigGroup{"Org1MSP": org1, "Org2MSP": org2})
+
+	rule := &AnchorPeerHosts{
+		LookupHost: func(host string) ([]string, error) {
+			if host == "peer0.org1.example.com" {
+				return []string{"10.0.0.1"}, nil
+			}
+			return nil, fmt.Errorf("no such host")
+		},
+	}
+	findings := rule.Check(NewInput(conf))
+	org1Path := ".groups.Application.groups.Org1MSP.values.AnchorPeers"
+	assertFindings(t, []*Finding{
+		{Severity: Warning, Path: org1Path},
+		{Severity: Error, Path: org1Path},
+		{Severity: Error, Path: org1Path},
+		{Severity: Error, Path: ".groups.Application.groups.Org2MSP.values.AnchorPeers"},
+	}, findings)
+	assert.Equal(t, "anchor peer at index 1 host peer1.org1.example.com cannot be resolved: no such host", findings[0].Message)
+}
+
+type stubRule []*Finding
+
+func (r stubRule) Check(input *Input) []*Finding {
+	return r
+}
+
+func TestCheckRules(t *testing.T) {
+	result, err := CheckRules(&cb.Config{}, stubRule{
+		{Severity: Warning, Path: ".b", Message: "warning"},
+		{Severity: Error, Path: ".c", Message: "error"},
+	}, stubRule{
+		{Severity: Error, Path: ".a", Message: "other error"},
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.GeneralErrors)
+	assert.Equal(t, []*ElementMessage{{Path: ".b", Message: "warning"}}, result.ElementWarnings)
+	assert.Equal(t, []*ElementMessage{{Path: ".a", Message: "other error"}, {Path: ".c", Message: "error"}}, result.ElementErrors)
+}
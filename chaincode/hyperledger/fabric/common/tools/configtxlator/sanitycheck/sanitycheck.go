@@ -17,19 +17,17 @@ limitations under the License.
 package sanitycheck
 
 import (
-	"fmt"
+	"sort"
+	"time"
 
 	"github.com/hyperledger/fabric/common/configtx"
 	cb "github.com/hyperledger/fabric/protos/common"
-	mspprotos "github.com/hyperledger/fabric/protos/msp"
 	"github.com/hyperledger/fabric/protos/utils"
-
-	"github.com/golang/protobuf/proto"
 )
 
 type Messages struct {
 	GeneralErrors   []string          `json:"general_errors"`
-	ElementWarnings []*ElementMessage `json:"element_errors"`
+	ElementWarnings []*ElementMessage `json:"element_warnings"`
 	ElementErrors   []*ElementMessage `json:"element_errors"`
 }
 
@@ -38,110 +36,111 @@ type ElementMessage struct {
 	Message string `json:"message"`
 }
 
-func Check(config *cb.Config) (*Messages, error) {
-	envConfig, err := utils.CreateSignedEnvelope(cb.HeaderType_CONFIG, "sanitycheck", nil, &cb.ConfigEnvelope{Config: config}, 0, 0)
-	if err != nil {
-		return nil, err
-	}
+// Severity is how serious a problem found by a Rule is
+type Severity int
 
-	result := &Messages{}
+const (
+	// Warning is a problem which may be intended, or which may only show
+	// outside of the config, such as an unresolvable host
+	Warning Severity = iota
 
-	cm, err := configtx.NewManagerImpl(envConfig, configtx.NewInitializer(), nil)
-	if err != nil {
-		result.GeneralErrors = []string{err.Error()}
-		return result, nil
-	}
+	// Error is a problem which prevents the config from working as intended
+	Error
+)
 
-	// This should come from the MSP manager, but, for some reason
-	// the MSP manager is not intialized if there are no orgs, so,
-	// we collect this manually.
-	mspMap := make(map[string]struct{})
+// Finding is a problem found by a Rule at the path of an element of a config
+type Finding struct {
+	Severity Severity
+	Path     string
+	Message  string
+}
 
-	if ac, ok := cm.ApplicationConfig(); ok {
-		for _, org := range ac.Organizations() {
-			mspMap[org.MSPID()] = struct{}{}
-		}
+// Input is what a Rule checks
+type Input struct {
+	Config *cb.Config
+
+	// MSPIDs are the IDs of the MSPs defined in the config
+	MSPIDs map[string]struct{}
+
+	// Now is the time at which the config is checked
+	Now time.Time
+}
+
+// NewInput returns the input of rules checking config now
+func NewInput(config *cb.Config) *Input {
+	mspIDs := make(map[string]struct{})
+	for _, value := range mspValues(config) {
+		mspIDs[value.fabric.Name] = struct{}{}
 	}
 
-	if oc, ok := cm.OrdererConfig(); ok {
-		for _, org := range oc.Organizations() {
-			mspMap[org.MSPID()] = struct{}{}
-		}
+	return &Input{
+		Config: config,
+		MSPIDs: mspIDs,
+		Now:    time.Now(),
 	}
+}
 
-	policyWarnings, policyErrors := checkPolicyPrincipals(config.ChannelGroup, "", mspMap)
+// Rule checks a config for one kind of problem
+type Rule interface {
+	Check(input *Input) []*Finding
+}
 
-	result.ElementWarnings = policyWarnings
-	result.ElementErrors = policyErrors
+// DefaultRules returns the rules run by Check
+func DefaultRules() []Rule {
+	return []Rule{
+		&PolicyPrincipals{},
+		&CertificateExpiry{Window: DefaultExpiryWindow},
+		&AdminCertificates{},
+		&ImplicitMetaSubPolicies{},
+		&BatchSize{},
+		&DuplicateMSPIDs{},
+		&AnchorPeerHosts{},
+	}
+}
 
-	return result, nil
+// Check checks config with the default rules
+func Check(config *cb.Config) (*Messages, error) {
+	return CheckRules(config, DefaultRules()...)
 }
 
-func checkPolicyPrincipals(group *cb.ConfigGroup, basePath string, mspMap map[string]struct{}) (warnings []*ElementMessage, errors []*ElementMessage) {
-	for policyName, configPolicy := range group.Policies {
-		appendError := func(err string) {
-			errors = append(errors, &ElementMessage{
-				Path:    basePath + ".policies." + policyName,
-				Message: err,
-			})
-		}
+// CheckRules checks that the config can be loaded like a channel config, then
+// checks it with rules, reporting the findings of each severity sorted by path
+func CheckRules(config *cb.Config, rules ...Rule) (*Messages, error) {
+	envConfig, err := utils.CreateSignedEnvelope(cb.HeaderType_CONFIG, "sanitycheck", nil, &cb.ConfigEnvelope{Config: config}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
 
-		appendWarning := func(err string) {
-			warnings = append(errors, &ElementMessage{
-				Path:    basePath + ".policies." + policyName,
-				Message: err,
-			})
-		}
+	result := &Messages{}
 
-		if configPolicy.Policy == nil {
-			appendError(fmt.Sprintf("no policy value set for %s", policyName))
-			continue
-		}
+	// Rules still run when the config cannot be loaded, as they tell more
+	// about some of the reasons, such as expired certificates
+	_, err = configtx.NewManagerImpl(envConfig, configtx.NewInitializer(), nil)
+	if err != nil {
+		result.GeneralErrors = []string{err.Error()}
+	}
 
-		if configPolicy.Policy.Type != int32(cb.Policy_SIGNATURE) {
-			continue
-		}
-		spe := &cb.SignaturePolicyEnvelope{}
-		err := proto.Unmarshal(configPolicy.Policy.Value, spe)
-		if err != nil {
-			appendError(fmt.Sprintf("error unmarshaling policy value to SignaturePolicyEnvelope: %s", err))
-			continue
+	input := NewInput(config)
+	var findings []*Finding
+	for _, rule := range rules {
+		findings = append(findings, rule.Check(input)...)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Path < findings[j].Path
+	})
+
+	for _, finding := range findings {
+		message := &ElementMessage{
+			Path:    finding.Path,
+			Message: finding.Message,
 		}
-
-		for i, identity := range spe.Identities {
-			var mspID string
-			switch identity.PrincipalClassification {
-			case mspprotos.MSPPrincipal_ROLE:
-				role := &mspprotos.MSPRole{}
-				err = proto.Unmarshal(identity.Principal, role)
-				if err != nil {
-					appendError(fmt.Sprintf("value of identities array at index %d is of type ROLE, but could not be unmarshaled to msp.MSPRole: %s", i, err))
-					continue
-				}
-				mspID = role.MspIdentifier
-			case mspprotos.MSPPrincipal_ORGANIZATION_UNIT:
-				ou := &mspprotos.OrganizationUnit{}
-				err = proto.Unmarshal(identity.Principal, ou)
-				if err != nil {
-					appendError(fmt.Sprintf("value of identities array at index %d is of type ORGANIZATION_UNIT, but could not be unmarshaled to msp.OrganizationUnit: %s", i, err))
-					continue
-				}
-				mspID = ou.MspIdentifier
-			default:
-				continue
-			}
-
-			_, ok := mspMap[mspID]
-			if !ok {
-				appendWarning(fmt.Sprintf("identity principal at index %d refers to MSP ID '%s', which is not an MSP in the network", i, mspID))
-			}
+		switch finding.Severity {
+		case Error:
+			result.ElementErrors = append(result.ElementErrors, message)
+		default:
+			result.ElementWarnings = append(result.ElementWarnings, message)
 		}
 	}
 
-	for subGroupName, subGroup := range group.Groups {
-		subWarnings, subErrors := checkPolicyPrincipals(subGroup, basePath+".groups."+subGroupName, mspMap)
-		warnings = append(warnings, subWarnings...)
-		errors = append(errors, subErrors...)
-	}
-	return
+	return result, nil
 }
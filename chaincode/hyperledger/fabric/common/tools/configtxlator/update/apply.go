@@ -0,0 +1,240 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package update
+
+import (
+	"fmt"
+
+	cb "github.com/hyperledger/fabric/protos/common"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Apply returns the config which results from applying configUpdate to
+// original, performing the version checks of the orderer: every element of
+// the read set must be at the same version in original, and every element of
+// the write set whose version differs from the read set must be at the next
+// version of the element in original, or at version 0 if it is new. Elements
+// are added or removed by modifying the group they belong to, whose members
+// are then exactly those of the write set. Whether the signatures of the
+// update satisfy the modification policies is not checked.
+func Apply(original *cb.Config, configUpdate *cb.ConfigUpdate) (*cb.Config, error) {
+	if original.ChannelGroup == nil {
+		return nil, fmt.Errorf("config has no channel group")
+	}
+	if configUpdate.WriteSet == nil {
+		return nil, fmt.Errorf("config update has no write set")
+	}
+
+	if configUpdate.ReadSet != nil {
+		err := verifyReadSetGroup("channel_group", configUpdate.ReadSet, original.ChannelGroup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a := &applier{}
+	channelGroup, err := a.group("channel_group", original.ChannelGroup, configUpdate.ReadSet, configUpdate.WriteSet)
+	if err != nil {
+		return nil, err
+	}
+	if !a.modified {
+		return nil, fmt.Errorf("config update modifies nothing")
+	}
+
+	return &cb.Config{
+		Sequence:     original.Sequence + 1,
+		ChannelGroup: channelGroup,
+	}, nil
+}
+
+// verifyReadSetGroup checks that the elements of the read set group read are
+// at the same version in the config group current found at path
+func verifyReadSetGroup(path string, read, current *cb.ConfigGroup) error {
+	if current == nil {
+		return fmt.Errorf("read set conflict at %s: group does not exist in the config", path)
+	}
+	if read.Version != current.Version {
+		return versionConflict(path, read.Version, current.Version)
+	}
+
+	for name, value := range read.Values {
+		currentValue, ok := current.Values[name]
+		if !ok {
+			return fmt.Errorf("read set conflict at %s.values.%s: value does not exist in the config", path, name)
+		}
+		if value.Version != currentValue.Version {
+			return versionConflict(path+".values."+name, value.Version, currentValue.Version)
+		}
+	}
+
+	for name, policy := range read.Policies {
+		currentPolicy, ok := current.Policies[name]
+		if !ok {
+			return fmt.Errorf("read set conflict at %s.policies.%s: policy does not exist in the config", path, name)
+		}
+		if policy.Version != currentPolicy.Version {
+			return versionConflict(path+".policies."+name, policy.Version, currentPolicy.Version)
+		}
+	}
+
+	for name, group := range read.Groups {
+		err := verifyReadSetGroup(path+".groups."+name, group, current.Groups[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func versionConflict(path string, readVersion, currentVersion uint64) error {
+	return fmt.Errorf("read set conflict at %s: read at version %d but the config is at version %d", path, readVersion, currentVersion)
+}
+
+// writeVersion checks that an element at path written at version written is
+// at the version following its current version, or at version 0 if it does
+// not exist yet, and that it has a mod_policy
+func writeVersion(path string, written uint64, current uint64, exists bool, modPolicy string) error {
+	if modPolicy == "" {
+		return fmt.Errorf("write set error at %s: mod_policy not set", path)
+	}
+	if !exists && written != 0 {
+		return fmt.Errorf("write set error at %s: element does not exist but is written at version %d instead of 0", path, written)
+	}
+	if exists && written != current+1 {
+		return fmt.Errorf("write set error at %s: element is at version %d but is written at version %d instead of %d", path, current, written, current+1)
+	}
+	return nil
+}
+
+type applier struct {
+	modified bool
+}
+
+// group returns the group at path once updated. current is the group in the
+// config, read and write the group in the read and write set, any of which
+// may be nil.
+func (a *applier) group(path string, current, read, write *cb.ConfigGroup) (*cb.ConfigGroup, error) {
+	written := write != nil && (read == nil || read.Version != write.Version)
+
+	var result *cb.ConfigGroup
+	var members *cb.ConfigGroup
+	switch {
+	case written:
+		err := writeVersion(path, write.Version, current.GetVersion(), current != nil, write.ModPolicy)
+		if err != nil {
+			return nil, err
+		}
+		a.modified = true
+		result = &cb.ConfigGroup{Version: write.Version, ModPolicy: write.ModPolicy}
+		members = write
+	case current != nil:
+		result = &cb.ConfigGroup{Version: current.Version, ModPolicy: current.ModPolicy}
+		members = current
+	default:
+		return nil, fmt.Errorf("write set error at %s: group is not in the config and its parent group is not modified", path)
+	}
+
+	if write != nil && !written {
+		err := checkMembership(path, write, current)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result.Values = make(map[string]*cb.ConfigValue)
+	for name := range members.Values {
+		value, err := a.value(path+".values."+name, current.GetValues()[name], read.GetValues()[name], write.GetValues()[name])
+		if err != nil {
+			return nil, err
+		}
+		result.Values[name] = value
+	}
+
+	result.Policies = make(map[string]*cb.ConfigPolicy)
+	for name := range members.Policies {
+		policy, err := a.policy(path+".policies."+name, current.GetPolicies()[name], read.GetPolicies()[name], write.GetPolicies()[name])
+		if err != nil {
+			return nil, err
+		}
+		result.Policies[name] = policy
+	}
+
+	result.Groups = make(map[string]*cb.ConfigGroup)
+	for name := range members.Groups {
+		group, err := a.group(path+".groups."+name, current.GetGroups()[name], read.GetGroups()[name], write.GetGroups()[name])
+		if err != nil {
+			return nil, err
+		}
+		result.Groups[name] = group
+	}
+
+	return result, nil
+}
+
+// checkMembership checks that the write set group write, which does not
+// modify the group current, only holds members of current, as members are
+// only added by modifying their group
+func checkMembership(path string, write, current *cb.ConfigGroup) error {
+	for name := range write.Values {
+		if _, ok := current.Values[name]; !ok {
+			return fmt.Errorf("write set error at %s.values.%s: value is added but the version of its group is not bumped", path, name)
+		}
+	}
+	for name := range write.Policies {
+		if _, ok := current.Policies[name]; !ok {
+			return fmt.Errorf("write set error at %s.policies.%s: policy is added but the version of its group is not bumped", path, name)
+		}
+	}
+	for name := range write.Groups {
+		if _, ok := current.Groups[name]; !ok {
+			return fmt.Errorf("write set error at %s.groups.%s: group is added but the version of its parent group is not bumped", path, name)
+		}
+	}
+	return nil
+}
+
+func (a *applier) value(path string, current, read, write *cb.ConfigValue) (*cb.ConfigValue, error) {
+	if write != nil && (read == nil || read.Version != write.Version) {
+		err := writeVersion(path, write.Version, current.GetVersion(), current != nil, write.ModPolicy)
+		if err != nil {
+			return nil, err
+		}
+		a.modified = true
+		return proto.Clone(write).(*cb.ConfigValue), nil
+	}
+	if current == nil {
+		return nil, fmt.Errorf("write set error at %s: value is not in the config and not written", path)
+	}
+	return proto.Clone(current).(*cb.ConfigValue), nil
+}
+
+func (a *applier) policy(path string, current, read, write *cb.ConfigPolicy) (*cb.ConfigPolicy, error) {
+	if write != nil && (read == nil || read.Version != write.Version) {
+		err := writeVersion(path, write.Version, current.GetVersion(), current != nil, write.ModPolicy)
+		if err != nil {
+			return nil, err
+		}
+		a.modified = true
+		return proto.Clone(write).(*cb.ConfigPolicy), nil
+	}
+	if current == nil {
+		return nil, fmt.Errorf("write set error at %s: policy is not in the config and not written", path)
+	}
+	return proto.Clone(current).(*cb.ConfigPolicy), nil
+}
@@ -0,0 +1,373 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package update
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/common/tools/protolator"
+	cb "github.com/hyperledger/fabric/protos/common"
+
+	// Import these to register the proto types
+	_ "github.com/hyperledger/fabric/protos/msp"
+	_ "github.com/hyperledger/fabric/protos/orderer"
+	_ "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Kinds of config elements
+const (
+	GroupElement  = "group"
+	ValueElement  = "value"
+	PolicyElement = "policy"
+)
+
+// Actions of a Change
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change is an element of a config which an update adds, removes or changes
+type Change struct {
+	// Path is the path of the element in the JSON form of the config, such
+	// as channel_group.groups.Application.values.ACLs
+	Path    string `json:"path"`
+	Element string `json:"element"`
+	Action  string `json:"action"`
+
+	// Version is the version of the element in the original config and
+	// UpdatedVersion its version once updated, each nil if the element
+	// does not exist in the corresponding config
+	Version        *uint64 `json:"version,omitempty"`
+	UpdatedVersion *uint64 `json:"updated_version,omitempty"`
+
+	// Original and Updated are the JSON forms of added and removed elements
+	Original interface{} `json:"original,omitempty"`
+	Updated  interface{} `json:"updated,omitempty"`
+
+	// Fields are the fields of a changed element which differ
+	Fields []*FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is a field of the JSON form of a changed element which
+// differs, nil meaning that the field is not set
+type FieldChange struct {
+	Path     string      `json:"path"`
+	Original interface{} `json:"original"`
+	Updated  interface{} `json:"updated"`
+}
+
+// Diff returns the changes which transition the original config to the
+// updated config, as computed by Compute, sorted by path. Groups whose
+// version is bumped are listed before their changed members.
+func Diff(original, updated *cb.Config) ([]*Change, error) {
+	if original.ChannelGroup == nil || updated.ChannelGroup == nil {
+		return nil, fmt.Errorf("both configs must have a channel group")
+	}
+
+	originalTree, err := jsonTree(original)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding original config: %s", err)
+	}
+	updatedTree, err := jsonTree(updated)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding updated config: %s", err)
+	}
+
+	d := &differ{}
+	_, writeSet, groupUpdated := computeGroupUpdate(original.ChannelGroup, updated.ChannelGroup)
+	if groupUpdated {
+		d.group("channel_group", original.ChannelGroup, writeSet,
+			member(originalTree, "channel_group"), member(updatedTree, "channel_group"))
+	}
+
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		return d.changes[i].Path < d.changes[j].Path
+	})
+	return d.changes, nil
+}
+
+type differ struct {
+	changes []*Change
+}
+
+// group records the changes of the group at path which the write set group
+// writeSet computed by computeGroupUpdate makes to the group original.
+// originalTree and updatedTree are the JSON forms of the group in the
+// original and updated config.
+func (d *differ) group(path string, original, writeSet *cb.ConfigGroup, originalTree, updatedTree interface{}) {
+	if writeSet.Version != original.Version {
+		d.changes = append(d.changes, &Change{
+			Path:           path,
+			Element:        GroupElement,
+			Action:         Changed,
+			Version:        version(original.Version),
+			UpdatedVersion: version(writeSet.Version),
+			Fields: diffTrees("mod_policy", member(originalTree, "mod_policy"),
+				member(updatedTree, "mod_policy")),
+		})
+	}
+
+	for name, policy := range writeSet.Policies {
+		originalPolicy, ok := original.Policies[name]
+		d.element(path+".policies."+name, PolicyElement, ok && originalPolicy.Version == policy.Version,
+			ok, policy.Version, member(originalTree, "policies", name), member(updatedTree, "policies", name))
+	}
+	for name, policy := range original.Policies {
+		if _, ok := member(updatedTree, "policies", name).(map[string]interface{}); !ok {
+			d.removed(path+".policies."+name, PolicyElement, policy.Version, member(originalTree, "policies", name))
+		}
+	}
+
+	for name, value := range writeSet.Values {
+		originalValue, ok := original.Values[name]
+		d.element(path+".values."+name, ValueElement, ok && originalValue.Version == value.Version,
+			ok, value.Version, member(originalTree, "values", name), member(updatedTree, "values", name))
+	}
+	for name, value := range original.Values {
+		if _, ok := member(updatedTree, "values", name).(map[string]interface{}); !ok {
+			d.removed(path+".values."+name, ValueElement, value.Version, member(originalTree, "values", name))
+		}
+	}
+
+	for name, group := range writeSet.Groups {
+		originalGroup, ok := original.Groups[name]
+		if !ok {
+			d.changes = append(d.changes, &Change{
+				Path:           path + ".groups." + name,
+				Element:        GroupElement,
+				Action:         Added,
+				UpdatedVersion: version(group.Version),
+				Updated:        member(updatedTree, "groups", name),
+			})
+			continue
+		}
+		d.group(path+".groups."+name, originalGroup, group,
+			member(originalTree, "groups", name), member(updatedTree, "groups", name))
+	}
+	for name, group := range original.Groups {
+		if _, ok := member(updatedTree, "groups", name).(map[string]interface{}); !ok {
+			d.removed(path+".groups."+name, GroupElement, group.Version, member(originalTree, "groups", name))
+		}
+	}
+}
+
+// element records the change of the value or policy at path, whose version in
+// the write set is writeVersion, unless it is unchanged
+func (d *differ) element(path, element string, unchanged, exists bool, writeVersion uint64, originalTree, updatedTree interface{}) {
+	switch {
+	case unchanged:
+	case !exists:
+		d.changes = append(d.changes, &Change{
+			Path:           path,
+			Element:        element,
+			Action:         Added,
+			UpdatedVersion: version(writeVersion),
+			Updated:        updatedTree,
+		})
+	default:
+		d.changes = append(d.changes, &Change{
+			Path:           path,
+			Element:        element,
+			Action:         Changed,
+			Version:        version(writeVersion - 1),
+			UpdatedVersion: version(writeVersion),
+			Fields:         diffTrees("", withoutVersion(originalTree), withoutVersion(updatedTree)),
+		})
+	}
+}
+
+func (d *differ) removed(path, element string, originalVersion uint64, originalTree interface{}) {
+	d.changes = append(d.changes, &Change{
+		Path:     path,
+		Element:  element,
+		Action:   Removed,
+		Version:  version(originalVersion),
+		Original: originalTree,
+	})
+}
+
+func version(v uint64) *uint64 {
+	return &v
+}
+
+// jsonTree returns the protolator JSON form of config decoded into maps,
+// slices and json.Numbers
+func jsonTree(config *cb.Config) (interface{}, error) {
+	var buffer bytes.Buffer
+	err := protolator.DeepMarshalJSON(&buffer, config)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(&buffer)
+	decoder.UseNumber()
+	var tree interface{}
+	err = decoder.Decode(&tree)
+	return tree, err
+}
+
+// member returns the member of tree at the path of keys, or nil
+func member(tree interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		object, ok := tree.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		tree = object[key]
+	}
+	return tree
+}
+
+// withoutVersion returns the JSON form of an element without its version,
+// which is reported separately
+func withoutVersion(tree interface{}) interface{} {
+	object, ok := tree.(map[string]interface{})
+	if !ok {
+		return tree
+	}
+	result := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		if key != "version" {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// diffTrees returns the leaves of the JSON trees original and updated which
+// differ. Arrays of different lengths are reported as a whole.
+func diffTrees(path string, original, updated interface{}) []*FieldChange {
+	if reflect.DeepEqual(original, updated) {
+		return nil
+	}
+
+	originalObject, ok1 := original.(map[string]interface{})
+	updatedObject, ok2 := updated.(map[string]interface{})
+	if ok1 && ok2 {
+		keys := []string{}
+		for key := range originalObject {
+			keys = append(keys, key)
+		}
+		for key := range updatedObject {
+			if _, ok := originalObject[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		var fields []*FieldChange
+		for _, key := range keys {
+			fields = append(fields, diffTrees(joinPath(path, key), originalObject[key], updatedObject[key])...)
+		}
+		return fields
+	}
+
+	originalArray, ok1 := original.([]interface{})
+	updatedArray, ok2 := updated.([]interface{})
+	if ok1 && ok2 && len(originalArray) == len(updatedArray) {
+		var fields []*FieldChange
+		for i := range originalArray {
+			fields = append(fields, diffTrees(fmt.Sprintf("%s[%d]", path, i), originalArray[i], updatedArray[i])...)
+		}
+		return fields
+	}
+
+	return []*FieldChange{{
+		Path:     path,
+		Original: original,
+		Updated:  updated,
+	}}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// WriteText writes changes to w as text, one line per change followed by one
+// indented line per changed field
+func WriteText(w io.Writer, changes []*Change) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(w, "No differences")
+		return err
+	}
+
+	for _, change := range changes {
+		var err error
+		switch change.Action {
+		case Added:
+			_, err = fmt.Fprintf(w, "+ %s (%s, version %d)\n", change.Path, change.Element, *change.UpdatedVersion)
+			if err == nil {
+				err = writeTextTree(w, "+", change.Updated)
+			}
+		case Removed:
+			_, err = fmt.Fprintf(w, "- %s (%s, version %d)\n", change.Path, change.Element, *change.Version)
+			if err == nil {
+				err = writeTextTree(w, "-", change.Original)
+			}
+		default:
+			_, err = fmt.Fprintf(w, "~ %s (%s, version %d -> %d)\n", change.Path, change.Element, *change.Version, *change.UpdatedVersion)
+			for _, field := range change.Fields {
+				if err != nil {
+					break
+				}
+				_, err = fmt.Fprintf(w, "    %s: %s -> %s\n", field.Path, textValue(field.Original), textValue(field.Updated))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTextTree writes the JSON form of an added or removed element, indented
+// and prefixed with sign
+func writeTextTree(w io.Writer, sign string, tree interface{}) error {
+	out, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		_, err = fmt.Fprintf(w, "    %s %s\n", sign, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func textValue(value interface{}) string {
+	if value == nil {
+		return "(unset)"
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
+}
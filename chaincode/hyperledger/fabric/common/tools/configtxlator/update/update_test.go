@@ -17,9 +17,12 @@ limitations under the License.
 package update
 
 import (
+	"bytes"
 	"testing"
 
 	cb "github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -583,3 +586,179 @@ func TestTwiceNestedModification(t *testing.T) {
 
 	assert.Equal(t, expectedWriteSet, cu.WriteSet, "Mismatched write set")
 }
+
+func diffTestConfig(anchorPort int32, orgs ...string) *cb.Config {
+	application := &cb.ConfigGroup{
+		Version:   1,
+		ModPolicy: "Admins",
+		Groups:    map[string]*cb.ConfigGroup{},
+	}
+	for _, org := range orgs {
+		application.Groups[org] = &cb.ConfigGroup{
+			ModPolicy: "Admins",
+			Values: map[string]*cb.ConfigValue{
+				"AnchorPeers": {
+					Version:   2,
+					ModPolicy: "Admins",
+					Value: utils.MarshalOrPanic(&pb.AnchorPeers{
+						AnchorPeers: []*pb.AnchorPeer{{Host: "peer0", Port: anchorPort}},
+					}),
+				},
+			},
+		}
+	}
+
+	return &cb.Config{
+		ChannelGroup: &cb.ConfigGroup{
+			Version:   3,
+			ModPolicy: "Admins",
+			Values: map[string]*cb.ConfigValue{
+				"HashingAlgorithm": {
+					ModPolicy: "Admins",
+					Value:     utils.MarshalOrPanic(&cb.HashingAlgorithm{Name: "SHA256"}),
+				},
+			},
+			Groups: map[string]*cb.ConfigGroup{
+				"Application": application,
+			},
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	original := diffTestConfig(7051, "Org1MSP", "Org2MSP")
+	updated := diffTestConfig(7052, "Org1MSP", "Org3MSP")
+	updated.ChannelGroup.ModPolicy = "Writers"
+	delete(updated.ChannelGroup.Values, "HashingAlgorithm")
+
+	changes, err := Diff(original, updated)
+	assert.NoError(t, err)
+
+	var summary [][]string
+	for _, change := range changes {
+		summary = append(summary, []string{change.Path, change.Element, change.Action})
+	}
+	assert.Equal(t, [][]string{
+		{"channel_group", GroupElement, Changed},
+		{"channel_group.groups.Application", GroupElement, Changed},
+		{"channel_group.groups.Application.groups.Org1MSP.values.AnchorPeers", ValueElement, Changed},
+		{"channel_group.groups.Application.groups.Org2MSP", GroupElement, Removed},
+		{"channel_group.groups.Application.groups.Org3MSP", GroupElement, Added},
+		{"channel_group.values.HashingAlgorithm", ValueElement, Removed},
+	}, summary)
+
+	// version bumps
+	assert.Equal(t, uint64(3), *changes[0].Version)
+	assert.Equal(t, uint64(4), *changes[0].UpdatedVersion)
+	assert.Equal(t, uint64(2), *changes[2].Version)
+	assert.Equal(t, uint64(3), *changes[2].UpdatedVersion)
+	assert.Nil(t, changes[3].UpdatedVersion)
+	assert.Nil(t, changes[4].Version)
+	assert.Equal(t, uint64(0), *changes[4].UpdatedVersion)
+
+	// changed fields
+	assert.Equal(t, []*FieldChange{{Path: "mod_policy", Original: "Admins", Updated: "Writers"}}, changes[0].Fields)
+	assert.Empty(t, changes[1].Fields)
+	if assert.Len(t, changes[2].Fields, 1) {
+		assert.Equal(t, "value.anchor_peers[0].port", changes[2].Fields[0].Path)
+		assert.EqualValues(t, "7051", changes[2].Fields[0].Original)
+		assert.EqualValues(t, "7052", changes[2].Fields[0].Updated)
+	}
+	assert.NotNil(t, changes[3].Original)
+	assert.NotNil(t, changes[4].Updated)
+
+	buffer := &bytes.Buffer{}
+	err = WriteText(buffer, changes)
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), "~ channel_group (group, version 3 -> 4)\n    mod_policy: \"Admins\" -> \"Writers\"\n")
+	assert.Contains(t, buffer.String(), "    value.anchor_peers[0].port: 7051 -> 7052\n")
+	assert.Contains(t, buffer.String(), "+ channel_group.groups.Application.groups.Org3MSP (group, version 0)\n")
+	assert.Contains(t, buffer.String(), "- channel_group.values.HashingAlgorithm (value, version 0)\n")
+}
+
+func TestDiffNoChanges(t *testing.T) {
+	changes, err := Diff(diffTestConfig(7051, "Org1MSP"), diffTestConfig(7051, "Org1MSP"))
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	buffer := &bytes.Buffer{}
+	err = WriteText(buffer, changes)
+	assert.NoError(t, err)
+	assert.Equal(t, "No differences\n", buffer.String())
+
+	_, err = Diff(&cb.Config{}, diffTestConfig(7051))
+	assert.Error(t, err)
+}
+
+func TestApply(t *testing.T) {
+	original := diffTestConfig(7051, "Org1MSP", "Org2MSP")
+	original.Sequence = 5
+	updated := diffTestConfig(7052, "Org1MSP", "Org3MSP")
+	updated.ChannelGroup.ModPolicy = "Writers"
+	delete(updated.ChannelGroup.Values, "HashingAlgorithm")
+
+	cu, err := Compute(original, updated)
+	assert.NoError(t, err)
+
+	applied, err := Apply(original, cu)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(6), applied.Sequence)
+
+	// the result holds the updated config with bumped versions
+	_, err = Compute(applied, updated)
+	assert.Error(t, err, "applied and updated configs differ")
+	assert.Equal(t, uint64(4), applied.ChannelGroup.Version)
+	assert.Equal(t, uint64(2), applied.ChannelGroup.Groups["Application"].Version)
+	assert.Equal(t, uint64(3), applied.ChannelGroup.Groups["Application"].Groups["Org1MSP"].Values["AnchorPeers"].Version)
+	assert.Equal(t, uint64(0), applied.ChannelGroup.Groups["Application"].Groups["Org3MSP"].Version)
+	assert.NotContains(t, applied.ChannelGroup.Groups["Application"].Groups, "Org2MSP")
+	assert.NotContains(t, applied.ChannelGroup.Values, "HashingAlgorithm")
+
+	// the original config is untouched
+	assert.Equal(t, uint64(3), original.ChannelGroup.Version)
+	assert.Contains(t, original.ChannelGroup.Groups["Application"].Groups, "Org2MSP")
+}
+
+func TestApplyConflicts(t *testing.T) {
+	original := diffTestConfig(7051, "Org1MSP")
+	updated := diffTestConfig(7052, "Org1MSP")
+
+	cu, err := Compute(original, updated)
+	assert.NoError(t, err)
+
+	// the group was modified since the update was computed
+	original.ChannelGroup.Groups["Application"].Groups["Org1MSP"].Version = 1
+	_, err = Apply(original, cu)
+	assert.EqualError(t, err, "read set conflict at channel_group.groups.Application.groups.Org1MSP: read at version 0 but the config is at version 1")
+	original.ChannelGroup.Groups["Application"].Groups["Org1MSP"].Version = 0
+
+	// the value was modified since the update was computed
+	original.ChannelGroup.Groups["Application"].Groups["Org1MSP"].Values["AnchorPeers"].Version = 3
+	_, err = Apply(original, cu)
+	assert.EqualError(t, err, "write set error at channel_group.groups.Application.groups.Org1MSP.values.AnchorPeers: element is at version 3 but is written at version 3 instead of 4")
+	original.ChannelGroup.Groups["Application"].Groups["Org1MSP"].Values["AnchorPeers"].Version = 2
+
+	// a value is added without bumping the version of its group
+	cu.WriteSet.Groups["Application"].Groups["Org1MSP"].Values["AnchorPeers"].Version = 3
+	cu.WriteSet.Groups["Application"].Groups["Org1MSP"].Values["Foo"] = &cb.ConfigValue{ModPolicy: "Admins"}
+	_, err = Apply(original, cu)
+	assert.EqualError(t, err, "write set error at channel_group.groups.Application.groups.Org1MSP.values.Foo: value is added but the version of its group is not bumped")
+
+	// a value is written without a mod_policy
+	delete(cu.WriteSet.Groups["Application"].Groups["Org1MSP"].Values, "Foo")
+	cu.WriteSet.Groups["Application"].Groups["Org1MSP"].Values["AnchorPeers"].ModPolicy = ""
+	_, err = Apply(original, cu)
+	assert.EqualError(t, err, "write set error at channel_group.groups.Application.groups.Org1MSP.values.AnchorPeers: mod_policy not set")
+
+	// a group of the read set is missing
+	cu.ReadSet.Groups["Foo"] = &cb.ConfigGroup{}
+	_, err = Apply(original, cu)
+	assert.EqualError(t, err, "read set conflict at channel_group.groups.Foo: group does not exist in the config")
+
+	// nothing is modified
+	_, err = Apply(original, &cb.ConfigUpdate{
+		ReadSet:  &cb.ConfigGroup{Version: 3},
+		WriteSet: &cb.ConfigGroup{Version: 3},
+	})
+	assert.EqualError(t, err, "config update modifies nothing")
+}
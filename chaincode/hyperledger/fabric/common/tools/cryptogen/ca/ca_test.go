@@ -16,11 +16,17 @@ limitations under the License.
 package ca_test
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/hyperledger/fabric/common/tools/cryptogen/ca"
 	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
@@ -38,7 +44,7 @@ var testDir = filepath.Join(os.TempDir(), "ca-test")
 func TestNewCA(t *testing.T) {
 
 	caDir := filepath.Join(testDir, "ca")
-	rootCA, err := ca.NewCA(caDir, testCAName, testCAName)
+	rootCA, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
 	assert.NoError(t, err, "Error generating CA")
 	assert.NotNil(t, rootCA, "Failed to return CA")
 	assert.NotNil(t, rootCA.Signer,
@@ -68,20 +74,20 @@ func TestGenerateSignCertificate(t *testing.T) {
 	assert.NotNil(t, ecPubKey, "Failed to generate signed certificate")
 
 	// create our CA
-	rootCA, err := ca.NewCA(caDir, testCA2Name, testCA2Name)
+	rootCA, err := ca.NewCA(caDir, testCA2Name, testCA2Name, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
 	assert.NoError(t, err, "Error generating CA")
 
-	cert, err := rootCA.SignCertificate(certDir, testName, nil, ecPubKey,
+	cert, err := rootCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
 		x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment,
-		[]x509.ExtKeyUsage{x509.ExtKeyUsageAny})
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageAny}, ca.CertOptions{})
 	assert.NoError(t, err, "Failed to generate signed certificate")
 	// KeyUsage should be x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
 	assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment,
 		cert.KeyUsage)
 	assert.Contains(t, cert.ExtKeyUsage, x509.ExtKeyUsageAny)
 
-	cert, err = rootCA.SignCertificate(certDir, testName, nil, ecPubKey,
-		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{})
+	cert, err = rootCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
 	assert.NoError(t, err, "Failed to generate signed certificate")
 	assert.Equal(t, 0, len(cert.ExtKeyUsage))
 
@@ -90,8 +96,8 @@ func TestGenerateSignCertificate(t *testing.T) {
 	assert.Equal(t, true, checkForFile(pemFile),
 		"Expected to find file "+pemFile)
 
-	_, err = rootCA.SignCertificate(certDir, "empty/CA", nil, ecPubKey,
-		x509.KeyUsageKeyEncipherment, []x509.ExtKeyUsage{x509.ExtKeyUsageAny})
+	_, err = rootCA.SignCertificate(certDir, "empty/CA", nil, nil, ecPubKey,
+		x509.KeyUsageKeyEncipherment, []x509.ExtKeyUsage{x509.ExtKeyUsageAny}, ca.CertOptions{})
 	assert.Error(t, err, "Bad name should fail")
 
 	// use an empty CA to test error path
@@ -99,13 +105,486 @@ func TestGenerateSignCertificate(t *testing.T) {
 		Name:     "badCA",
 		SignCert: &x509.Certificate{},
 	}
-	_, err = badCA.SignCertificate(certDir, testName, nil, &ecdsa.PublicKey{},
-		x509.KeyUsageKeyEncipherment, []x509.ExtKeyUsage{x509.ExtKeyUsageAny})
+	_, err = badCA.SignCertificate(certDir, testName, nil, nil, &ecdsa.PublicKey{},
+		x509.KeyUsageKeyEncipherment, []x509.ExtKeyUsage{x509.ExtKeyUsageAny}, ca.CertOptions{})
 	assert.Error(t, err, "Empty CA should not be able to sign")
 	cleanup(testDir)
 
 }
 
+func TestKeyAlgorithms(t *testing.T) {
+
+	certDir := filepath.Join(testDir, "certs")
+
+	// generate one leaf key per algorithm
+	pubKeys := map[string]crypto.PublicKey{}
+	for _, algorithm := range csp.KeyAlgorithms {
+		priv, _, err := csp.GenerateKey(certDir, algorithm)
+		assert.NoError(t, err, "Failed to generate %s private key", algorithm)
+		pubKeys[algorithm], err = csp.GetPublicKey(priv)
+		assert.NoError(t, err, "Failed to get public key")
+	}
+
+	// every CA algorithm must be able to sign every leaf algorithm
+	for _, caAlgorithm := range csp.KeyAlgorithms {
+		caDir := filepath.Join(testDir, "ca-"+caAlgorithm)
+		rootCA, err := ca.NewCA(caDir, testCAName, testCAName, caAlgorithm, ca.RandomSerials, ca.CertOptions{})
+		assert.NoError(t, err, "Error generating %s CA", caAlgorithm)
+		assert.NoError(t, rootCA.SignCert.CheckSignatureFrom(rootCA.SignCert))
+
+		// the CA can be loaded again, e.g. by cryptogen extend
+		loadedCA, err := ca.LoadCA(caDir, testCAName)
+		assert.NoError(t, err, "Error loading %s CA", caAlgorithm)
+		assert.Equal(t, rootCA.Signer.Public(), loadedCA.Signer.Public())
+
+		for _, algorithm := range csp.KeyAlgorithms {
+			cert, err := loadedCA.SignCertificate(certDir, testName, nil, nil, pubKeys[algorithm],
+				x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
+			assert.NoError(t, err, "Failed to sign %s key with %s CA", algorithm, caAlgorithm)
+			assert.Equal(t, pubKeys[algorithm], cert.PublicKey)
+			assert.NoError(t, cert.CheckSignatureFrom(rootCA.SignCert),
+				"Failed to verify %s certificate issued by %s CA", algorithm, caAlgorithm)
+		}
+	}
+
+	_, err := ca.NewCA(filepath.Join(testDir, "ca"), testCAName, testCAName, "DSA", ca.RandomSerials, ca.CertOptions{})
+	assert.Error(t, err, "Unsupported key algorithm should fail")
+	cleanup(testDir)
+
+}
+
+func TestLoadCA(t *testing.T) {
+
+	caDir := filepath.Join(testDir, "ca")
+	certDir := filepath.Join(testDir, "certs")
+
+	_, err := ca.LoadCA(caDir, testCAName)
+	assert.Error(t, err, "Loading a missing CA should fail")
+
+	rootCA, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+
+	loadedCA, err := ca.LoadCA(caDir, testCAName)
+	assert.NoError(t, err, "Error loading CA")
+	assert.Equal(t, testCAName, loadedCA.Name)
+	assert.Equal(t, rootCA.SignCert.Raw, loadedCA.SignCert.Raw,
+		"Loaded CA should have the same certificate")
+	assert.Equal(t, rootCA.Signer.Public(), loadedCA.Signer.Public(),
+		"Loaded CA should have the same public key")
+
+	// the loaded CA must be able to issue certificates chaining to the original
+	priv, _, err := csp.GeneratePrivateKey(certDir)
+	assert.NoError(t, err, "Failed to generate private key")
+	ecPubKey, err := csp.GetECPublicKey(priv)
+	assert.NoError(t, err, "Failed to get public key")
+	cert, err := loadedCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate signed certificate")
+	assert.NoError(t, cert.CheckSignatureFrom(rootCA.SignCert))
+
+	// remove the private key
+	files, err := filepath.Glob(filepath.Join(caDir, "*_sk"))
+	assert.NoError(t, err)
+	for _, file := range files {
+		os.Remove(file)
+	}
+	_, err = ca.LoadCA(caDir, testCAName)
+	assert.Error(t, err, "Loading a CA without private key should fail")
+
+	_, err = ca.LoadCertificate(filepath.Join(caDir, "missing-cert.pem"))
+	assert.Error(t, err, "Loading a missing certificate should fail")
+	cleanup(testDir)
+
+}
+
+// failingSigner is the signer of a CA whose key is unavailable
+type failingSigner struct {
+	crypto.Signer
+}
+
+func (failingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("key unavailable")
+}
+
+func TestRevokeCertificate(t *testing.T) {
+
+	caDir := filepath.Join(testDir, "ca")
+	certDir := filepath.Join(testDir, "certs")
+
+	rootCA, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+
+	// an empty index yields an empty CRL
+	revocations, err := ca.LoadRevocations(caDir)
+	assert.NoError(t, err, "Error loading revocation index")
+	assert.Equal(t, 0, len(revocations))
+	crlBytes, err := rootCA.GenerateCRL(caDir, time.Hour)
+	assert.NoError(t, err, "Failed to generate CRL")
+	crl, err := x509.ParseCRL(crlBytes)
+	assert.NoError(t, err, "Failed to parse CRL")
+	assert.Equal(t, 0, len(crl.TBSCertList.RevokedCertificates))
+
+	priv, _, err := csp.GeneratePrivateKey(certDir)
+	assert.NoError(t, err, "Failed to generate private key")
+	ecPubKey, err := csp.GetECPublicKey(priv)
+	assert.NoError(t, err, "Failed to get public key")
+	cert0, err := rootCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate signed certificate")
+	cert1, err := rootCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate signed certificate")
+
+	err = rootCA.RevokeCertificate(caDir, testName, cert0)
+	assert.NoError(t, err, "Failed to revoke certificate")
+	// revoking twice must not duplicate the entry
+	err = rootCA.RevokeCertificate(caDir, testName, cert0)
+	assert.NoError(t, err, "Failed to revoke certificate")
+	err = rootCA.RevokeCertificate(caDir, testName, cert1)
+	assert.NoError(t, err, "Failed to revoke certificate")
+
+	revocations, err = ca.LoadRevocations(caDir)
+	assert.NoError(t, err, "Error loading revocation index")
+	assert.Equal(t, 2, len(revocations))
+
+	crlBytes, err = rootCA.GenerateCRL(caDir, time.Hour)
+	assert.NoError(t, err, "Failed to generate CRL")
+	crl, err = x509.ParseCRL(crlBytes)
+	assert.NoError(t, err, "Failed to parse CRL")
+	assert.NoError(t, rootCA.SignCert.CheckCRLSignature(crl))
+	assert.Equal(t, 2, len(crl.TBSCertList.RevokedCertificates))
+	assert.Equal(t, 0, cert0.SerialNumber.Cmp(crl.TBSCertList.RevokedCertificates[0].SerialNumber))
+	assert.Equal(t, 0, cert1.SerialNumber.Cmp(crl.TBSCertList.RevokedCertificates[1].SerialNumber))
+
+	// the CRL is a v2 CRL numbered after the previous one
+	revocationList, err := x509.ParseRevocationList(crlBytes)
+	assert.NoError(t, err, "Failed to parse CRL")
+	assert.NoError(t, revocationList.CheckSignatureFrom(rootCA.SignCert))
+	assert.Equal(t, int64(2), revocationList.Number.Int64())
+	assert.Equal(t, rootCA.SignCert.SubjectKeyId, revocationList.AuthorityKeyId)
+
+	// a CRL which cannot be signed does not use up its number
+	failingCA := *rootCA
+	failingCA.Signer = failingSigner{rootCA.Signer}
+	_, err = failingCA.GenerateCRL(caDir, time.Hour)
+	assert.Error(t, err, "Generating a CRL with a failing signer should fail")
+	crlBytes, err = rootCA.GenerateCRL(caDir, time.Hour)
+	assert.NoError(t, err, "Failed to generate CRL")
+	revocationList, err = x509.ParseRevocationList(crlBytes)
+	assert.NoError(t, err, "Failed to parse CRL")
+	assert.Equal(t, int64(3), revocationList.Number.Int64())
+
+	// certificates of another CA cannot be revoked
+	otherCA, err := ca.NewCA(filepath.Join(testDir, "other"), testCA2Name, testCA2Name,
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	err = otherCA.RevokeCertificate(caDir, testName, cert0)
+	assert.Error(t, err, "Revoking a foreign certificate should fail")
+
+	// corrupt the CRL number
+	err = ioutil.WriteFile(filepath.Join(caDir, "crlnumber"), []byte("garbage"), 0644)
+	assert.NoError(t, err)
+	_, err = rootCA.GenerateCRL(caDir, time.Hour)
+	assert.Error(t, err, "Corrupt CRL number should fail")
+
+	// corrupt the index
+	err = ioutil.WriteFile(filepath.Join(caDir, "revocations.json"), []byte("garbage"), 0644)
+	assert.NoError(t, err)
+	_, err = rootCA.GenerateCRL(caDir, time.Hour)
+	assert.Error(t, err, "Corrupt revocation index should fail")
+	cleanup(testDir)
+
+}
+
+func TestCertOptions(t *testing.T) {
+
+	caDir := filepath.Join(testDir, "ca")
+	certDir := filepath.Join(testDir, "certs")
+
+	// the defaults of the built-in templates
+	rootCA, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	assert.Equal(t, []string{"US"}, rootCA.SignCert.Subject.Country)
+	assert.Equal(t, []string{"California"}, rootCA.SignCert.Subject.Province)
+	assert.Equal(t, []string{"San Francisco"}, rootCA.SignCert.Subject.Locality)
+	assert.Equal(t, 0, len(rootCA.SignCert.Subject.OrganizationalUnit))
+	assert.Equal(t, ca.DefaultValidity,
+		rootCA.SignCert.NotAfter.Sub(rootCA.SignCert.NotBefore))
+	cleanup(testDir)
+
+	caOpts := ca.CertOptions{
+		Country:  "GB",
+		Locality: "London",
+		Validity: 20 * 365 * 24 * time.Hour,
+	}
+	rootCA, err = ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, caOpts)
+	assert.NoError(t, err, "Error generating CA")
+	assert.Equal(t, []string{"GB"}, rootCA.SignCert.Subject.Country)
+	assert.Equal(t, []string{"California"}, rootCA.SignCert.Subject.Province)
+	assert.Equal(t, []string{"London"}, rootCA.SignCert.Subject.Locality)
+	assert.Equal(t, []string{testCAName}, rootCA.SignCert.Subject.Organization)
+	assert.Equal(t, caOpts.Validity, rootCA.SignCert.NotAfter.Sub(rootCA.SignCert.NotBefore))
+
+	priv, _, err := csp.GeneratePrivateKey(certDir)
+	assert.NoError(t, err, "Failed to generate private key")
+	ecPubKey, err := csp.GetECPublicKey(priv)
+	assert.NoError(t, err, "Failed to get public key")
+	opts := ca.CertOptions{
+		Province:           "Bavaria",
+		OrganizationalUnit: "peer",
+		StreetAddress:      "1 Main Street",
+		Validity:           24 * time.Hour,
+	}
+	cert, err := rootCA.SignCertificate(certDir, testName, []string{"client"}, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, opts)
+	assert.NoError(t, err, "Failed to generate signed certificate")
+	assert.Equal(t, []string{"US"}, cert.Subject.Country)
+	assert.Equal(t, []string{"Bavaria"}, cert.Subject.Province)
+	assert.Equal(t, []string{"San Francisco"}, cert.Subject.Locality)
+	assert.Equal(t, []string{"peer", "client"}, cert.Subject.OrganizationalUnit)
+	assert.Equal(t, []string{"1 Main Street"}, cert.Subject.StreetAddress)
+	assert.Equal(t, opts.Validity, cert.NotAfter.Sub(cert.NotBefore))
+	assert.True(t, cert.NotBefore.Before(time.Now()), "Certificate should be backdated")
+
+	_, err = rootCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{Validity: -time.Hour})
+	assert.Error(t, err, "Negative validity should fail")
+	cleanup(testDir)
+
+}
+
+func TestSequentialSerials(t *testing.T) {
+
+	caDir := filepath.Join(testDir, "ca")
+	certDir := filepath.Join(testDir, "certs")
+
+	_, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm,
+		"incremental", ca.CertOptions{})
+	assert.Error(t, err, "Unknown serial policy should fail")
+
+	rootCA, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm,
+		ca.SequentialSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	assert.Equal(t, int64(1), rootCA.SignCert.SerialNumber.Int64())
+
+	priv, _, err := csp.GeneratePrivateKey(certDir)
+	assert.NoError(t, err, "Failed to generate private key")
+	ecPubKey, err := csp.GetECPublicKey(priv)
+	assert.NoError(t, err, "Failed to get public key")
+	cert, err := rootCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate signed certificate")
+	assert.Equal(t, int64(2), cert.SerialNumber.Int64())
+
+	// a loaded CA carries on where the previous one stopped
+	loadedCA, err := ca.LoadCA(caDir, testCAName)
+	assert.NoError(t, err, "Error loading CA")
+	assert.Equal(t, ca.SequentialSerials, loadedCA.SerialPolicy)
+	cert, err = loadedCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate signed certificate")
+	assert.Equal(t, int64(3), cert.SerialNumber.Int64())
+
+	// corrupt the serial file
+	err = ioutil.WriteFile(filepath.Join(caDir, "serial"), []byte("garbage"), 0644)
+	assert.NoError(t, err)
+	_, err = loadedCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
+	assert.Error(t, err, "Corrupt serial file should fail")
+	cleanup(testDir)
+
+	// CAs numbering certificates randomly keep no state
+	rootCA, err = ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	assert.Equal(t, false, checkForFile(filepath.Join(caDir, "serial")))
+	loadedCA, err = ca.LoadCA(caDir, testCAName)
+	assert.NoError(t, err, "Error loading CA")
+	assert.Equal(t, ca.RandomSerials, loadedCA.SerialPolicy)
+	cleanup(testDir)
+
+}
+
+func TestIntermediateCA(t *testing.T) {
+
+	caDir := filepath.Join(testDir, "ca")
+	intermediateDir := filepath.Join(testDir, "intermediate")
+	certDir := filepath.Join(testDir, "certs")
+
+	rootCA, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	assert.Nil(t, rootCA.Parent, "A root CA should have no parent")
+	assert.Equal(t, rootCA, rootCA.Root())
+
+	intermediateCA, err := rootCA.NewIntermediateCA(intermediateDir, testCAName, testCA2Name,
+		csp.ECDSAP384, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating intermediate CA")
+	assert.Equal(t, rootCA, intermediateCA.Parent)
+	assert.Equal(t, rootCA, intermediateCA.Root())
+	assert.True(t, intermediateCA.SignCert.IsCA, "Intermediate CA certificate should be a CA")
+	assert.Equal(t, rootCA.SignCert.Subject.String(), intermediateCA.SignCert.Issuer.String())
+	assert.NoError(t, intermediateCA.SignCert.CheckSignatureFrom(rootCA.SignCert))
+
+	// leaf certificates chain to the root through the intermediate CA
+	priv, _, err := csp.GeneratePrivateKey(certDir)
+	assert.NoError(t, err, "Failed to generate private key")
+	ecPubKey, err := csp.GetECPublicKey(priv)
+	assert.NoError(t, err, "Failed to get public key")
+	cert, err := intermediateCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{x509.ExtKeyUsageAny}, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate signed certificate")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCA.SignCert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediateCA.SignCert)
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.NoError(t, err, "Failed to verify certificate chain")
+	assert.Equal(t, 1, len(chains))
+	assert.Equal(t, 3, len(chains[0]))
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.Error(t, err, "Verification without the intermediate CA should fail")
+
+	loadedCA, err := rootCA.LoadIntermediateCA(intermediateDir, testCA2Name)
+	assert.NoError(t, err, "Error loading intermediate CA")
+	assert.Equal(t, rootCA, loadedCA.Parent)
+	assert.Equal(t, intermediateCA.SignCert.Raw, loadedCA.SignCert.Raw)
+
+	// an intermediate CA is only loaded along with the CA which issued it
+	_, err = loadedCA.LoadIntermediateCA(intermediateDir, testCA2Name)
+	assert.Error(t, err, "Loading an intermediate CA of the wrong parent should fail")
+	cleanup(testDir)
+
+}
+
+func TestSetClock(t *testing.T) {
+
+	clock := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ca.SetClock(clock)
+	defer ca.SetClock(time.Time{})
+
+	caDir := filepath.Join(testDir, "ca")
+	rootCA, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	assert.Equal(t, clock.Add(-5*time.Minute), rootCA.SignCert.NotBefore)
+	assert.Equal(t, clock.Add(-5*time.Minute+ca.DefaultValidity), rootCA.SignCert.NotAfter)
+
+	// the actual clock is restored by the zero time
+	ca.SetClock(time.Time{})
+	cert, err := rootCA.SignCertificate(caDir, testName, nil, nil,
+		rootCA.SignCert.PublicKey, x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating signed certificate")
+	assert.WithinDuration(t, time.Now().Add(-5*time.Minute), cert.NotBefore, time.Minute)
+
+	cleanup(testDir)
+}
+
+func TestRenewCertificate(t *testing.T) {
+
+	caDir := filepath.Join(testDir, "ca")
+	certDir := filepath.Join(testDir, "certs")
+
+	rootCA, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+
+	priv, _, err := csp.GeneratePrivateKey(certDir)
+	assert.NoError(t, err, "Failed to generate private key")
+	ecPubKey, err := csp.GetECPublicKey(priv)
+	assert.NoError(t, err, "Failed to get public key")
+	cert, err := rootCA.SignCertificate(certDir, testName, []string{"peer"}, []string{"peer0", "peer0.example.com"},
+		ecPubKey, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, ca.CertOptions{Country: "DE", Validity: time.Hour})
+	assert.NoError(t, err, "Failed to generate signed certificate")
+
+	// by default the certificate is valid as long as before
+	renewed, err := rootCA.RenewCertificate(certDir, testName, cert, 0)
+	assert.NoError(t, err, "Failed to renew certificate")
+	saved, err := ca.LoadCertificate(filepath.Join(certDir, testName+"-cert.pem"))
+	assert.NoError(t, err, "Failed to load renewed certificate")
+	assert.Equal(t, renewed.Raw, saved.Raw, "Renewed certificate should replace the previous one")
+	assert.Equal(t, cert.RawSubject, renewed.RawSubject)
+	assert.Equal(t, cert.DNSNames, renewed.DNSNames)
+	assert.Equal(t, cert.KeyUsage, renewed.KeyUsage)
+	assert.Equal(t, cert.ExtKeyUsage, renewed.ExtKeyUsage)
+	assert.Equal(t, cert.RawSubjectPublicKeyInfo, renewed.RawSubjectPublicKeyInfo)
+	assert.Equal(t, time.Hour, renewed.NotAfter.Sub(renewed.NotBefore))
+	assert.NotEqual(t, 0, cert.SerialNumber.Cmp(renewed.SerialNumber), "Serial number should change")
+	assert.NoError(t, renewed.CheckSignatureFrom(rootCA.SignCert))
+
+	renewed, err = rootCA.RenewCertificate(certDir, testName, cert, 48*time.Hour)
+	assert.NoError(t, err, "Failed to renew certificate")
+	assert.Equal(t, 48*time.Hour, renewed.NotAfter.Sub(renewed.NotBefore))
+	assert.True(t, renewed.NotAfter.After(cert.NotAfter), "Renewed certificate should expire later")
+
+	// only the CA which issued the certificate can renew it
+	otherCA, err := ca.NewCA(filepath.Join(testDir, "other"), testCA2Name, testCA2Name,
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	_, err = otherCA.RenewCertificate(certDir, testName, cert, 0)
+	assert.Error(t, err, "Renewing a foreign certificate should fail")
+	cleanup(testDir)
+
+}
+
+func TestAttributes(t *testing.T) {
+
+	caDir := filepath.Join(testDir, "ca")
+	certDir := filepath.Join(testDir, "certs")
+
+	rootCA, err := ca.NewCA(caDir, testCAName, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+
+	priv, _, err := csp.GeneratePrivateKey(certDir)
+	assert.NoError(t, err, "Failed to generate private key")
+	ecPubKey, err := csp.GetECPublicKey(priv)
+	assert.NoError(t, err, "Failed to get public key")
+
+	attrs := map[string]string{"abac.init": "true", "department": "sales"}
+	cert, err := rootCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{Attributes: attrs})
+	assert.NoError(t, err, "Failed to generate signed certificate")
+
+	// the attributes are embedded as read by the cid library
+	var value []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(ca.AttributesOID) {
+			value = ext.Value
+		}
+	}
+	assert.NotNil(t, value, "Expected the attributes extension")
+	embedded := struct {
+		Attrs map[string]string `json:"attrs"`
+	}{}
+	assert.NoError(t, json.Unmarshal(value, &embedded))
+	assert.Equal(t, attrs, embedded.Attrs)
+
+	// they survive renewal
+	renewed, err := rootCA.RenewCertificate(certDir, testName, cert, 0)
+	assert.NoError(t, err, "Failed to renew certificate")
+	assert.Contains(t, renewed.Extensions, cert.Extensions[len(cert.Extensions)-1])
+
+	// no extension without attributes
+	cert, err = rootCA.SignCertificate(certDir, testName, nil, nil, ecPubKey,
+		x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate signed certificate")
+	for _, ext := range cert.Extensions {
+		assert.False(t, ext.Id.Equal(ca.AttributesOID), "Unexpected attributes extension")
+	}
+	cleanup(testDir)
+}
+
 func cleanup(dir string) {
 	os.RemoveAll(dir)
 }
@@ -0,0 +1,162 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// name of the file in which a CA keeps track of the certificates it revoked
+const revocationIndexFile = "revocations.json"
+
+// name of the file in which a CA keeps track of the number of its next CRL
+const crlNumberFile = "crlnumber"
+
+// Revocation is an entry of the revocation index of a CA
+type Revocation struct {
+	Name           string    `json:"name"`
+	SerialNumber   *big.Int  `json:"serial_number"`
+	RevocationTime time.Time `json:"revocation_time"`
+}
+
+// RevokeCertificate adds cert to the revocation index saved in baseDir.
+// Revoking a certificate which is already in the index has no effect.
+func (ca *CA) RevokeCertificate(baseDir, name string, cert *x509.Certificate) error {
+
+	err := cert.CheckSignatureFrom(ca.SignCert)
+	if err != nil {
+		return fmt.Errorf("certificate %s was not issued by CA %s: %s", name, ca.Name, err)
+	}
+
+	revocations, err := LoadRevocations(baseDir)
+	if err != nil {
+		return err
+	}
+
+	for _, revocation := range revocations {
+		if revocation.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return nil
+		}
+	}
+
+	revocations = append(revocations, &Revocation{
+		Name:           name,
+		SerialNumber:   cert.SerialNumber,
+		RevocationTime: now().UTC(),
+	})
+
+	data, err := json.MarshalIndent(revocations, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(baseDir, revocationIndexFile), data, 0644)
+}
+
+// LoadRevocations returns the entries of the revocation index saved in
+// baseDir. If there is no index yet, no entries are returned.
+func LoadRevocations(baseDir string) ([]*Revocation, error) {
+
+	revocations := []*Revocation{}
+
+	data, err := ioutil.ReadFile(filepath.Join(baseDir, revocationIndexFile))
+	if os.IsNotExist(err) {
+		return revocations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &revocations)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing revocation index in %s: %s", baseDir, err)
+	}
+
+	return revocations, nil
+}
+
+// GenerateCRL creates a DER encoded v2 CRL signed by the CA which lists every
+// certificate of the revocation index saved in baseDir. The CRLs of a CA are
+// numbered 1, 2, 3, ... and the number of the next one is kept in baseDir.
+func (ca *CA) GenerateCRL(baseDir string, expiry time.Duration) ([]byte, error) {
+
+	revocations, err := LoadRevocations(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := []x509.RevocationListEntry{}
+	for _, revocation := range revocations {
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   revocation.SerialNumber,
+			RevocationTime: revocation.RevocationTime,
+		})
+	}
+
+	number, err := loadCRLNumber(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	thisUpdate := now().UTC()
+	template := &x509.RevocationList{
+		Number:                    number,
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                thisUpdate.Add(expiry),
+		RevokedCertificateEntries: revoked,
+	}
+	// the authority key identifier is taken from the certificate of the CA
+	crl, err := x509.CreateRevocationList(rand.Reader, template, ca.SignCert, ca.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	// the number is only used up by a CRL which was created
+	next := new(big.Int).Add(number, big.NewInt(1))
+	err = ioutil.WriteFile(filepath.Join(baseDir, crlNumberFile), []byte(fmt.Sprintf("%X\n", next)), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+// loadCRLNumber returns the number of the next CRL of the CA whose state is
+// saved in baseDir
+func loadCRLNumber(baseDir string) (*big.Int, error) {
+
+	path := filepath.Join(baseDir, crlNumberFile)
+	number := big.NewInt(1)
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		_, ok := number.SetString(strings.TrimSpace(string(data)), 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid CRL number in %s", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return number, nil
+}
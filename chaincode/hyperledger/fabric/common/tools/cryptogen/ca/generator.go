@@ -17,13 +17,17 @@ package ca
 
 import (
 	"crypto"
-	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
 	"encoding/pem"
+	"fmt"
+	"io/ioutil"
 	"math/big"
 	"os"
+	"strings"
 	"time"
 
 	"path/filepath"
@@ -31,30 +35,120 @@ import (
 	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
 )
 
+// Serial number policies of a CA
+const (
+	// RandomSerials numbers certificates with random 128 bit integers
+	RandomSerials = "random"
+	// SequentialSerials numbers certificates 1, 2, 3, ... and keeps track of
+	// the next serial number in the directory of the CA
+	SequentialSerials = "sequential"
+)
+
+// name of the file in which a CA using SequentialSerials keeps track of the
+// next serial number
+const serialFile = "serial"
+
+// DefaultValidity is how long certificates are valid unless specified
+// otherwise
+const DefaultValidity = 3650 * 24 * time.Hour
+
+// AttributesOID is the OID of the extension in which the attributes of an
+// identity are embedded, as read by the cid library of the chaincode shim
+var AttributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// now returns the current time of the CAs
+var now = time.Now
+
+// SetClock makes the CAs behave as if the current time was always t, so that
+// the validity periods of the certificates they issue do not depend on when
+// they are created. The zero time restores the actual clock.
+func SetClock(t time.Time) {
+	if t.IsZero() {
+		now = time.Now
+		return
+	}
+	now = func() time.Time { return t }
+}
+
+// CertOptions holds the settings of a certificate created by a CA. Empty
+// fields take their default values.
+type CertOptions struct {
+	Country            string
+	Province           string
+	Locality           string
+	OrganizationalUnit string
+	StreetAddress      string
+	Validity           time.Duration
+	// Attributes are embedded in the AttributesOID extension unless empty
+	Attributes map[string]string
+}
+
 type CA struct {
 	Name string
 	//SignKey  *ecdsa.PrivateKey
 	Signer   crypto.Signer
 	SignCert *x509.Certificate
+	// SerialPolicy is either RandomSerials (the default) or SequentialSerials
+	SerialPolicy string
+	// Parent is the CA which issued the certificate of an intermediate CA,
+	// nil for a root CA
+	Parent *CA
+	// directory in which the state of sequential serial numbers is saved
+	baseDir string
 }
 
 // NewCA creates an instance of CA and saves the signing key pair in
-// baseDir/name
-func NewCA(baseDir, org, name string) (*CA, error) {
+// baseDir/name. The key pair is generated using keyAlgorithm, and the
+// self-signed certificate is created according to opts.
+func NewCA(baseDir, org, name, keyAlgorithm, serialPolicy string, opts CertOptions) (*CA, error) {
+	return newCA(baseDir, org, name, keyAlgorithm, serialPolicy, opts, nil)
+}
+
+// NewIntermediateCA creates an instance of CA whose certificate is issued by
+// ca, and saves its signing key pair in baseDir/name like NewCA does
+func (ca *CA) NewIntermediateCA(baseDir, org, name, keyAlgorithm, serialPolicy string,
+	opts CertOptions) (*CA, error) {
+	return newCA(baseDir, org, name, keyAlgorithm, serialPolicy, opts, ca)
+}
+
+// newCA creates a root CA if parent is nil, an intermediate CA of parent
+// otherwise
+func newCA(baseDir, org, name, keyAlgorithm, serialPolicy string, opts CertOptions,
+	parent *CA) (*CA, error) {
 
 	var response error
 	var ca *CA
 
-	err := os.MkdirAll(baseDir, 0755)
+	err := CheckSerialPolicy(serialPolicy)
+	if err != nil {
+		return nil, err
+	}
+	ca = &CA{
+		Name:         name,
+		SerialPolicy: serialPolicy,
+		Parent:       parent,
+		baseDir:      baseDir,
+	}
+	// a root CA issues its own certificate
+	issuer := parent
+	if issuer == nil {
+		issuer = ca
+	}
+
+	err = os.MkdirAll(baseDir, 0755)
+	response = err
 	if err == nil {
-		priv, signer, err := csp.GeneratePrivateKey(baseDir)
+		priv, signer, err := csp.GenerateKey(baseDir, keyAlgorithm)
 		response = err
 		if err == nil {
 			// get public signing certificate
-			ecPubKey, err := csp.GetECPublicKey(priv)
+			pubKey, err := csp.GetPublicKey(priv)
 			response = err
 			if err == nil {
-				template := x509Template()
+				template, err := issuer.x509Template(opts)
+				if err != nil {
+					return nil, err
+				}
 				//this is a CA
 				template.IsCA = true
 				template.KeyUsage |= x509.KeyUsageDigitalSignature |
@@ -63,46 +157,147 @@ func NewCA(baseDir, org, name string) (*CA, error) {
 				template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
 
 				//set the organization for the subject
-				subject := subjectTemplate()
+				subject := subjectTemplate(opts)
 				subject.Organization = []string{org}
 				subject.CommonName = name
 
 				template.Subject = subject
 				template.SubjectKeyId = priv.SKI()
 
-				x509Cert, err := genCertificateECDSA(baseDir, name, &template, &template,
-					ecPubKey, signer)
+				parentCert, parentSigner := &template, signer
+				if parent != nil {
+					parentCert, parentSigner = parent.SignCert, parent.Signer
+				}
+				x509Cert, err := genCertificate(baseDir, name, &template, parentCert,
+					pubKey, parentSigner)
 				response = err
 				if err == nil {
-					ca = &CA{
-						Name:     name,
-						Signer:   signer,
-						SignCert: x509Cert,
-					}
+					ca.Signer = signer
+					ca.SignCert = x509Cert
 				}
 			}
 		}
 	}
-	return ca, response
+	if response != nil {
+		return nil, response
+	}
+	return ca, nil
+}
+
+// CheckSerialPolicy returns an error if policy is not a known serial number
+// policy. The empty string stands for RandomSerials.
+func CheckSerialPolicy(policy string) error {
+	switch policy {
+	case "", RandomSerials, SequentialSerials:
+		return nil
+	default:
+		return fmt.Errorf("unknown serial number policy '%s', must be '%s' or '%s'",
+			policy, RandomSerials, SequentialSerials)
+	}
+}
+
+// LoadCA loads an existing CA whose signing key pair was previously saved
+// by NewCA in baseDir/name
+func LoadCA(baseDir, name string) (*CA, error) {
+
+	cert, err := LoadCertificate(filepath.Join(baseDir, name+"-cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	_, signer, err := csp.LoadPrivateKey(baseDir, cert.SubjectKeyId)
+	if err != nil {
+		return nil, fmt.Errorf("error loading private key for CA %s: %s", name, err)
+	}
+
+	// the serial file only exists for CAs numbering certificates sequentially
+	serialPolicy := RandomSerials
+	if _, err := os.Stat(filepath.Join(baseDir, serialFile)); err == nil {
+		serialPolicy = SequentialSerials
+	}
+
+	return &CA{
+		Name:         name,
+		Signer:       signer,
+		SignCert:     cert,
+		SerialPolicy: serialPolicy,
+		baseDir:      baseDir,
+	}, nil
+}
+
+// LoadIntermediateCA loads an existing intermediate CA of ca previously saved
+// by NewIntermediateCA in baseDir/name
+func (ca *CA) LoadIntermediateCA(baseDir, name string) (*CA, error) {
+
+	intermediateCA, err := LoadCA(baseDir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = intermediateCA.SignCert.CheckSignatureFrom(ca.SignCert)
+	if err != nil {
+		return nil, fmt.Errorf("CA %s was not issued by CA %s: %s", name, ca.Name, err)
+	}
+	intermediateCA.Parent = ca
+
+	return intermediateCA, nil
+}
+
+// Root returns the self-signed CA at the top of the hierarchy of ca
+func (ca *CA) Root() *CA {
+	root := ca
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	return root
+}
+
+// LoadCertificate reads a PEM encoded X509 certificate from path
+func LoadCertificate(path string) (*x509.Certificate, error) {
+
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM encoded certificate found in %s", path)
+	}
+
+	return x509.ParseCertificate(block.Bytes)
 }
 
 // SignCertificate creates a signed certificate based on a built-in template
-// and saves it in baseDir/name
-func (ca *CA) SignCertificate(baseDir, name string, sans []string, pub *ecdsa.PublicKey,
-	ku x509.KeyUsage, eku []x509.ExtKeyUsage) (*x509.Certificate, error) {
+// customized by opts and saves it in baseDir/name. The organizational units in
+// ous are added to the one of opts, if any.
+func (ca *CA) SignCertificate(baseDir, name string, ous, sans []string, pub crypto.PublicKey,
+	ku x509.KeyUsage, eku []x509.ExtKeyUsage, opts CertOptions) (*x509.Certificate, error) {
 
-	template := x509Template()
+	template, err := ca.x509Template(opts)
+	if err != nil {
+		return nil, err
+	}
 	template.KeyUsage = ku
 	template.ExtKeyUsage = eku
 
 	//set the organization for the subject
-	subject := subjectTemplate()
+	subject := subjectTemplate(opts)
+	subject.OrganizationalUnit = append(subject.OrganizationalUnit, ous...)
 	subject.CommonName = name
 
 	template.Subject = subject
 	template.DNSNames = sans
 
-	cert, err := genCertificateECDSA(baseDir, name, &template, ca.SignCert,
+	if len(opts.Attributes) > 0 {
+		ext, err := attributesExtension(opts.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	}
+
+	cert, err := genCertificate(baseDir, name, &template, ca.SignCert,
 		pub, ca.Signer)
 
 	if err != nil {
@@ -112,26 +307,116 @@ func (ca *CA) SignCertificate(baseDir, name string, sans []string, pub *ecdsa.Pu
 	return cert, nil
 }
 
-// default template for X509 subject
-func subjectTemplate() pkix.Name {
-	return pkix.Name{
+// RenewCertificate reissues cert, which was issued by ca, with the same
+// subject, SANs, key usages, custom extensions and public key, and saves it in
+// baseDir/name. The new certificate is valid for validity, or for as long as
+// cert was if validity is zero.
+func (ca *CA) RenewCertificate(baseDir, name string, cert *x509.Certificate,
+	validity time.Duration) (*x509.Certificate, error) {
+
+	err := cert.CheckSignatureFrom(ca.SignCert)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s was not issued by CA %s: %s", name, ca.Name, err)
+	}
+
+	if validity == 0 {
+		validity = cert.NotAfter.Sub(cert.NotBefore)
+	}
+	template, err := ca.x509Template(CertOptions{Validity: validity})
+	if err != nil {
+		return nil, err
+	}
+
+	template.RawSubject = cert.RawSubject
+	template.Subject = cert.Subject
+	template.KeyUsage = cert.KeyUsage
+	template.ExtKeyUsage = cert.ExtKeyUsage
+	template.UnknownExtKeyUsage = cert.UnknownExtKeyUsage
+	template.DNSNames = cert.DNSNames
+	template.EmailAddresses = cert.EmailAddresses
+	template.IPAddresses = cert.IPAddresses
+	template.IsCA = cert.IsCA
+	// extensions derived from the fields above are created anew
+	for _, ext := range cert.Extensions {
+		if !standardExtensions[ext.Id.String()] {
+			template.ExtraExtensions = append(template.ExtraExtensions, ext)
+		}
+	}
+
+	return genCertificate(baseDir, name, &template, ca.SignCert, cert.PublicKey, ca.Signer)
+}
+
+// attributesExtension returns the extension embedding attrs in the JSON
+// format of the attribute manager of Fabric CA
+func attributesExtension(attrs map[string]string) (pkix.Extension, error) {
+
+	value, err := json.Marshal(struct {
+		Attrs map[string]string `json:"attrs"`
+	}{attrs})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: AttributesOID, Value: value}, nil
+}
+
+// extensions which x509.CreateCertificate derives from the fields of the
+// template
+var standardExtensions = map[string]bool{
+	"2.5.29.14": true, // subject key identifier
+	"2.5.29.15": true, // key usage
+	"2.5.29.17": true, // subject alternative name
+	"2.5.29.19": true, // basic constraints
+	"2.5.29.30": true, // name constraints
+	"2.5.29.31": true, // CRL distribution points
+	"2.5.29.32": true, // certificate policies
+	"2.5.29.35": true, // authority key identifier
+	"2.5.29.37": true, // extended key usage
+}
+
+// template for X509 subject, defaulting to US / California / San Francisco
+func subjectTemplate(opts CertOptions) pkix.Name {
+	subject := pkix.Name{
 		Country:  []string{"US"},
 		Locality: []string{"San Francisco"},
 		Province: []string{"California"},
 	}
+	if len(opts.Country) > 0 {
+		subject.Country = []string{opts.Country}
+	}
+	if len(opts.Locality) > 0 {
+		subject.Locality = []string{opts.Locality}
+	}
+	if len(opts.Province) > 0 {
+		subject.Province = []string{opts.Province}
+	}
+	if len(opts.OrganizationalUnit) > 0 {
+		subject.OrganizationalUnit = []string{opts.OrganizationalUnit}
+	}
+	if len(opts.StreetAddress) > 0 {
+		subject.StreetAddress = []string{opts.StreetAddress}
+	}
+	return subject
 }
 
-// default template for X509 certificates
-func x509Template() x509.Certificate {
+// template for X509 certificates issued by the CA
+func (ca *CA) x509Template(opts CertOptions) (x509.Certificate, error) {
 
 	// generate a serial number
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, _ := rand.Int(rand.Reader, serialNumberLimit)
+	serialNumber, err := ca.nextSerialNumber()
+	if err != nil {
+		return x509.Certificate{}, err
+	}
 
-	// set expiry to around 10 years
-	expiry := 3650 * 24 * time.Hour
+	// set expiry to around 10 years unless specified otherwise
+	expiry := opts.Validity
+	if expiry == 0 {
+		expiry = DefaultValidity
+	}
+	if expiry < 0 {
+		return x509.Certificate{}, fmt.Errorf("invalid validity period %s", expiry)
+	}
 	// backdate 5 min
-	notBefore := time.Now().Add(-5 * time.Minute).UTC()
+	notBefore := now().Add(-5 * time.Minute).UTC()
 
 	//basic template to use
 	x509 := x509.Certificate{
@@ -140,12 +425,48 @@ func x509Template() x509.Certificate {
 		NotAfter:              notBefore.Add(expiry).UTC(),
 		BasicConstraintsValid: true,
 	}
-	return x509
+	return x509, nil
 
 }
 
-// generate a signed X509 certficate using ECDSA
-func genCertificateECDSA(baseDir, name string, template, parent *x509.Certificate, pub *ecdsa.PublicKey,
+// nextSerialNumber returns the serial number of the next certificate issued
+// by the CA according to its serial policy
+func (ca *CA) nextSerialNumber() (*big.Int, error) {
+
+	switch ca.SerialPolicy {
+	case "", RandomSerials:
+		serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+		return rand.Int(csp.Reader(), serialNumberLimit)
+	case SequentialSerials:
+		if len(ca.baseDir) == 0 {
+			return nil, fmt.Errorf("CA %s has no directory to keep track of serial numbers", ca.Name)
+		}
+	default:
+		return nil, CheckSerialPolicy(ca.SerialPolicy)
+	}
+
+	path := filepath.Join(ca.baseDir, serialFile)
+	serialNumber := big.NewInt(1)
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		_, ok := serialNumber.SetString(strings.TrimSpace(string(data)), 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number in %s", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	next := new(big.Int).Add(serialNumber, big.NewInt(1))
+	err = ioutil.WriteFile(path, []byte(fmt.Sprintf("%X\n", next)), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return serialNumber, nil
+}
+
+// generate a signed X509 certficate for an ECDSA, RSA or Ed25519 public key
+func genCertificate(baseDir, name string, template, parent *x509.Certificate, pub crypto.PublicKey,
 	priv interface{}) (*x509.Certificate, error) {
 
 	//create the x509 public cert
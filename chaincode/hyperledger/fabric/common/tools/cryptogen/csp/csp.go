@@ -18,21 +18,222 @@ package csp
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
 
 	"github.com/hyperledger/fabric/bccsp"
 	"github.com/hyperledger/fabric/bccsp/factory"
 	"github.com/hyperledger/fabric/bccsp/signer"
 )
 
-// GeneratePrivateKey creates a private key and stores it in keystorePath
+// Key algorithms supported by GenerateKey
+const (
+	ECDSAP256 = "ECDSA-P256"
+	ECDSAP384 = "ECDSA-P384"
+	RSA2048   = "RSA-2048"
+	RSA3072   = "RSA-3072"
+	RSA4096   = "RSA-4096"
+	Ed25519   = "Ed25519"
+)
+
+// DefaultKeyAlgorithm is the key algorithm used when none is specified
+const DefaultKeyAlgorithm = ECDSAP256
+
+// KeyAlgorithms lists all the supported key algorithms
+var KeyAlgorithms = []string{ECDSAP256, ECDSAP384, RSA2048, RSA3072, RSA4096, Ed25519}
+
+// CheckKeyAlgorithm returns an error if algorithm is not supported. The
+// empty string stands for DefaultKeyAlgorithm.
+func CheckKeyAlgorithm(algorithm string) error {
+	if algorithm == Ed25519 {
+		return nil
+	}
+	_, err := keyGenOpts(algorithm)
+	return err
+}
+
+// GeneratePrivateKey creates a private key using DefaultKeyAlgorithm and
+// stores it in keystorePath
 func GeneratePrivateKey(keystorePath string) (bccsp.Key,
 	crypto.Signer, error) {
 
+	return GenerateKey(keystorePath, DefaultKeyAlgorithm)
+}
+
+// GenerateKey creates a private key using algorithm and stores it in
+// keystorePath, or in the PKCS#11 token set up by InitPKCS11 in which case
+// keystorePath only holds a reference to the key
+func GenerateKey(keystorePath, algorithm string) (bccsp.Key,
+	crypto.Signer, error) {
+
+	if hsm != nil {
+		return generateHSMKey(keystorePath, algorithm)
+	}
+	return GenerateSoftwareKey(keystorePath, algorithm)
+}
+
+// GenerateSoftwareKey creates a private key using algorithm and stores it in
+// keystorePath, even if InitPKCS11 was called
+func GenerateSoftwareKey(keystorePath, algorithm string) (bccsp.Key,
+	crypto.Signer, error) {
+
+	var err error
+	var priv bccsp.Key
+	var s crypto.Signer
+
+	// the BCCSP does not support Ed25519
+	if algorithm == Ed25519 {
+		return generateEd25519Key(keystorePath)
+	}
+
+	// the BCCSP cannot generate keys from a given source of randomness
+	if drbg != nil {
+		return generateDeterministicKey(keystorePath, algorithm)
+	}
+
+	opts, err := keyGenOpts(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csp, err := getBCCSP(keystorePath)
+	if err == nil {
+		// generate a key
+		priv, err = csp.KeyGen(opts)
+		if err == nil {
+			// create a crypto.Signer
+			s, err = signer.New(csp, priv)
+		}
+	}
+	return priv, s, err
+}
+
+// LoadPrivateKey loads the private key identified by ski from keystorePath,
+// or from the PKCS#11 token set up by InitPKCS11
+func LoadPrivateKey(keystorePath string, ski []byte) (bccsp.Key,
+	crypto.Signer, error) {
+
 	var err error
 	var priv bccsp.Key
 	var s crypto.Signer
 
+	if hsm != nil {
+		return loadHSMKey(ski)
+	}
+
+	csp, err := getBCCSP(keystorePath)
+	if err == nil {
+		// load the key
+		priv, err = csp.GetKey(ski)
+		if err != nil {
+			// the BCCSP does not support Ed25519
+			if edPriv, edSigner, edErr := loadEd25519Key(keystorePath, ski); edErr == nil {
+				return edPriv, edSigner, nil
+			}
+		}
+		if err == nil {
+			if !priv.Private() {
+				return nil, nil, fmt.Errorf("no private key found for SKI [%x] in %s",
+					ski, keystorePath)
+			}
+			// create a crypto.Signer
+			if drbg != nil {
+				s, err = newDeterministicSigner(keystorePath, ski)
+			} else {
+				s, err = signer.New(csp, priv)
+			}
+		}
+	}
+	return priv, s, err
+}
+
+// ReadPrivateKey reads the PEM encoded private key saved in file, as written
+// to a keystore or a TLS folder by GenerateKey, without going through a BCCSP
+func ReadPrivateKey(file string) (crypto.Signer, error) {
+
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key found in %s", file)
+	}
+
+	var key interface{}
+	key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	}
+	if err != nil {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key in %s", file)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key in %s is of unsupported type %T", file, key)
+	}
+	return signer, nil
+}
+
+// PrivateKeyFile returns the path to the file in which the private key
+// identified by ski is saved in keystorePath. Keys kept in a PKCS#11 token
+// have no such file.
+func PrivateKeyFile(keystorePath string, ski []byte) (string, error) {
+
+	file := softwareKeyFile(keystorePath, ski)
+	if _, err := os.Stat(file); err != nil {
+		return "", fmt.Errorf("no private key file found for SKI [%x] in %s", ski, keystorePath)
+	}
+	return file, nil
+}
+
+// SKI returns the subject key identifier of pub, under which the private key
+// matching pub is saved in a keystore
+func SKI(pub crypto.PublicKey) ([]byte, error) {
+
+	var importOpts bccsp.KeyImportOpts
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return ed25519SKI(pub), nil
+	case *ecdsa.PublicKey:
+		importOpts = &bccsp.ECDSAGoPublicKeyImportOpts{Temporary: true}
+	case *rsa.PublicKey:
+		importOpts = &bccsp.RSAGoPublicKeyImportOpts{Temporary: true}
+	default:
+		return nil, fmt.Errorf("public key is of unsupported type %T", pub)
+	}
+
+	// let the BCCSP identify the key, without any keystore
+	csp, err := factory.GetBCCSPFromOpts(&factory.FactoryOpts{
+		ProviderName: "SW",
+		SwOpts: &factory.SwOpts{
+			HashFamily:    "SHA2",
+			SecLevel:      256,
+			Ephemeral:     true,
+			DummyKeystore: &factory.DummyKeystoreOpts{},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	key, err := csp.KeyImport(pub, importOpts)
+	if err != nil {
+		return nil, err
+	}
+	return key.SKI(), nil
+}
+
+// getBCCSP returns a software BCCSP backed by a file keystore in keystorePath
+func getBCCSP(keystorePath string) (bccsp.BCCSP, error) {
 	opts := &factory.FactoryOpts{
 		ProviderName: "SW",
 		SwOpts: &factory.SwOpts{
@@ -44,19 +245,31 @@ func GeneratePrivateKey(keystorePath string) (bccsp.Key,
 			},
 		},
 	}
-	csp, err := factory.GetBCCSPFromOpts(opts)
-	if err == nil {
-		// generate a key
-		priv, err = csp.KeyGen(&bccsp.ECDSAP256KeyGenOpts{Temporary: false})
-		if err == nil {
-			// create a crypto.Signer
-			s, err = signer.New(csp, priv)
-		}
+	return factory.GetBCCSPFromOpts(opts)
+}
+
+// keyGenOpts returns the BCCSP options to generate a key using algorithm
+func keyGenOpts(algorithm string) (bccsp.KeyGenOpts, error) {
+	switch algorithm {
+	case "", ECDSAP256:
+		return &bccsp.ECDSAP256KeyGenOpts{Temporary: false}, nil
+	case ECDSAP384:
+		return &bccsp.ECDSAP384KeyGenOpts{Temporary: false}, nil
+	case RSA2048:
+		return &bccsp.RSA2048KeyGenOpts{Temporary: false}, nil
+	case RSA3072:
+		return &bccsp.RSA3072KeyGenOpts{Temporary: false}, nil
+	case RSA4096:
+		return &bccsp.RSA4096KeyGenOpts{Temporary: false}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm '%s', must be one of %v",
+			algorithm, KeyAlgorithms)
 	}
-	return priv, s, err
 }
 
-func GetECPublicKey(priv bccsp.Key) (*ecdsa.PublicKey, error) {
+// GetPublicKey returns the public key of priv as a *ecdsa.PublicKey,
+// *rsa.PublicKey or ed25519.PublicKey
+func GetPublicKey(priv bccsp.Key) (crypto.PublicKey, error) {
 
 	// get the public key
 	pubKey, err := priv.PublicKey()
@@ -69,9 +282,18 @@ func GetECPublicKey(priv bccsp.Key) (*ecdsa.PublicKey, error) {
 		return nil, err
 	}
 	// unmarshal using pkix
-	ecPubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	return x509.ParsePKIXPublicKey(pubKeyBytes)
+}
+
+func GetECPublicKey(priv bccsp.Key) (*ecdsa.PublicKey, error) {
+
+	pubKey, err := GetPublicKey(priv)
 	if err != nil {
 		return nil, err
 	}
-	return ecPubKey.(*ecdsa.PublicKey), nil
+	ecPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is of type %T, not ECDSA", pubKey)
+	}
+	return ecPubKey, nil
 }
@@ -16,9 +16,17 @@ limitations under the License.
 package csp_test
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -72,6 +80,186 @@ func TestGeneratePrivateKey(t *testing.T) {
 
 }
 
+func TestLoadPrivateKey(t *testing.T) {
+
+	priv, _, err := csp.GeneratePrivateKey(testDir)
+	assert.NoError(t, err, "Failed to generate private key")
+
+	loaded, signer, err := csp.LoadPrivateKey(testDir, priv.SKI())
+	assert.NoError(t, err, "Failed to load private key")
+	assert.Equal(t, true, loaded.Private(), "Failed to return private key")
+	assert.Equal(t, priv.SKI(), loaded.SKI())
+	assert.NotNil(t, signer, "Should have returned a crypto.Signer")
+
+	_, _, err = csp.LoadPrivateKey(testDir, []byte{1, 2, 3, 4})
+	assert.Error(t, err, "Expected an error with an unknown SKI")
+
+	cleanup(testDir)
+}
+
+func TestGenerateKey(t *testing.T) {
+
+	for _, algorithm := range csp.KeyAlgorithms {
+		assert.NoError(t, csp.CheckKeyAlgorithm(algorithm))
+
+		priv, signer, err := csp.GenerateKey(testDir, algorithm)
+		assert.NoError(t, err, "Failed to generate %s private key", algorithm)
+		assert.Equal(t, true, priv.Private(), "Failed to return private key")
+		assert.NotNil(t, signer, "Should have returned a crypto.Signer")
+		pkFile := filepath.Join(testDir, hex.EncodeToString(priv.SKI())+"_sk")
+		assert.Equal(t, true, checkForFile(pkFile),
+			"Expected to find private key file")
+
+		pubKey, err := csp.GetPublicKey(priv)
+		assert.NoError(t, err, "Failed to get public key from private key")
+		assert.Equal(t, signer.Public(), pubKey)
+		switch algorithm {
+		case csp.ECDSAP256:
+			assert.Equal(t, elliptic.P256(), pubKey.(*ecdsa.PublicKey).Curve)
+		case csp.ECDSAP384:
+			assert.Equal(t, elliptic.P384(), pubKey.(*ecdsa.PublicKey).Curve)
+		case csp.RSA2048:
+			assert.Equal(t, 2048, pubKey.(*rsa.PublicKey).N.BitLen())
+		case csp.RSA3072:
+			assert.Equal(t, 3072, pubKey.(*rsa.PublicKey).N.BitLen())
+		case csp.RSA4096:
+			assert.Equal(t, 4096, pubKey.(*rsa.PublicKey).N.BitLen())
+		case csp.Ed25519:
+			assert.IsType(t, ed25519.PublicKey{}, pubKey)
+		}
+
+		// the key can be loaded back from the keystore
+		loaded, loadedSigner, err := csp.LoadPrivateKey(testDir, priv.SKI())
+		assert.NoError(t, err, "Failed to load %s private key", algorithm)
+		assert.Equal(t, priv.SKI(), loaded.SKI())
+		assert.Equal(t, pubKey, loadedSigner.Public())
+
+		cleanup(testDir)
+	}
+
+	err := csp.CheckKeyAlgorithm("DSA")
+	assert.Error(t, err, "Expected an error with an unsupported algorithm")
+	_, _, err = csp.GenerateKey(testDir, "DSA")
+	assert.Error(t, err, "Expected an error with an unsupported algorithm")
+
+	// only ECDSA keys have an EC public key
+	priv, _, err := csp.GenerateKey(testDir, csp.Ed25519)
+	assert.NoError(t, err, "Failed to generate private key")
+	_, err = csp.GetECPublicKey(priv)
+	assert.Error(t, err, "Expected an error with an Ed25519 key")
+
+	cleanup(testDir)
+}
+
+func TestReadPrivateKey(t *testing.T) {
+
+	for _, algorithm := range csp.KeyAlgorithms {
+		priv, signer, err := csp.GenerateKey(testDir, algorithm)
+		assert.NoError(t, err, "Failed to generate %s private key", algorithm)
+
+		read, err := csp.ReadPrivateKey(filepath.Join(testDir, hex.EncodeToString(priv.SKI())+"_sk"))
+		assert.NoError(t, err, "Failed to read %s private key", algorithm)
+		assert.Equal(t, signer.Public(), read.Public())
+
+		cleanup(testDir)
+	}
+
+	_, err := csp.ReadPrivateKey(filepath.Join(testDir, "missing_sk"))
+	assert.Error(t, err, "Expected an error with a missing file")
+}
+
+func TestSKI(t *testing.T) {
+
+	for _, algorithm := range []string{csp.ECDSAP256, csp.RSA2048, csp.Ed25519} {
+		priv, signer, err := csp.GenerateKey(testDir, algorithm)
+		assert.NoError(t, err, "Failed to generate %s private key", algorithm)
+
+		ski, err := csp.SKI(signer.Public())
+		assert.NoError(t, err, "Failed to compute SKI of %s key", algorithm)
+		assert.Equal(t, priv.SKI(), ski)
+
+		cleanup(testDir)
+	}
+
+	_, err := csp.SKI("not a key")
+	assert.Error(t, err, "Expected an error with an unsupported key")
+}
+
+func TestPrivateKeyFile(t *testing.T) {
+
+	priv, _, err := csp.GeneratePrivateKey(testDir)
+	assert.NoError(t, err, "Failed to generate private key")
+
+	file, err := csp.PrivateKeyFile(testDir, priv.SKI())
+	assert.NoError(t, err, "Failed to find private key file")
+	assert.Equal(t, filepath.Join(testDir, hex.EncodeToString(priv.SKI())+"_sk"), file)
+
+	_, err = csp.PrivateKeyFile(testDir, []byte{1, 2, 3, 4})
+	assert.Error(t, err, "Expected an error with an unknown SKI")
+
+	cleanup(testDir)
+}
+
+func TestSetSeed(t *testing.T) {
+
+	defer csp.SetSeed("")
+	digest := sha256.Sum256([]byte("digest"))
+
+	// larger RSA keys take long to derive and are derived the same way
+	for _, algorithm := range []string{csp.ECDSAP256, csp.ECDSAP384, csp.RSA2048, csp.Ed25519} {
+		var opts crypto.SignerOpts = crypto.SHA256
+		if algorithm == csp.Ed25519 {
+			opts = crypto.Hash(0)
+		}
+
+		// the same seed gives the same keys and signatures
+		keys := [][]byte{}
+		signatures := [][]byte{}
+		for i := 0; i < 2; i++ {
+			csp.SetSeed("test")
+			keystore := filepath.Join(testDir, fmt.Sprint(i))
+			priv, signer, err := csp.GenerateKey(keystore, algorithm)
+			assert.NoError(t, err, "Failed to generate %s private key", algorithm)
+
+			key, err := ioutil.ReadFile(filepath.Join(keystore, hex.EncodeToString(priv.SKI())+"_sk"))
+			assert.NoError(t, err, "Expected to find private key file")
+			keys = append(keys, key)
+
+			signature, err := signer.Sign(rand.Reader, digest[:], opts)
+			assert.NoError(t, err, "Failed to sign with %s private key", algorithm)
+			signatures = append(signatures, signature)
+
+			// the key can be loaded back from the keystore
+			_, loadedSigner, err := csp.LoadPrivateKey(keystore, priv.SKI())
+			assert.NoError(t, err, "Failed to load %s private key", algorithm)
+			signature, err = loadedSigner.Sign(rand.Reader, digest[:], opts)
+			assert.NoError(t, err, "Failed to sign with %s private key", algorithm)
+			assert.Equal(t, signatures[0], signature)
+
+			switch pubKey := signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				assert.True(t, ecdsa.VerifyASN1(pubKey, digest[:], signature))
+			case *rsa.PublicKey:
+				assert.NoError(t, rsa.VerifyPKCS1v15(pubKey, crypto.SHA256, digest[:], signature))
+			case ed25519.PublicKey:
+				assert.True(t, ed25519.Verify(pubKey, digest[:], signature))
+			}
+		}
+		assert.Equal(t, keys[0], keys[1], "Expected the same %s keys", algorithm)
+		assert.Equal(t, signatures[0], signatures[1], "Expected the same %s signatures", algorithm)
+
+		// another seed gives other keys
+		csp.SetSeed("other")
+		priv, _, err := csp.GenerateKey(testDir, algorithm)
+		assert.NoError(t, err, "Failed to generate %s private key", algorithm)
+		key, err := ioutil.ReadFile(filepath.Join(testDir, hex.EncodeToString(priv.SKI())+"_sk"))
+		assert.NoError(t, err, "Expected to find private key file")
+		assert.NotEqual(t, keys[0], key)
+
+		cleanup(testDir)
+	}
+}
+
 func TestGetECPublicKey(t *testing.T) {
 
 	priv, _, err := csp.GeneratePrivateKey(testDir)
@@ -0,0 +1,255 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package csp
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/sw"
+	"github.com/hyperledger/fabric/bccsp/utils"
+)
+
+// drbg is the DRBG seeded by SetSeed, nil unless keys are generated
+// deterministically
+var drbg io.Reader
+
+// SetSeed makes GenerateKey derive the keys from a DRBG seeded with seed, and
+// the signers returned by GenerateKey and LoadPrivateKey sign
+// deterministically, so that the same sequence of calls always gives the same
+// keys and signatures. Anyone knowing the seed can recreate the keys, so this
+// is only meant for test fixtures. An empty seed restores crypto/rand.
+func SetSeed(seed string) {
+
+	if len(seed) == 0 {
+		drbg = nil
+		return
+	}
+
+	// AES-256 in counter mode keyed with the hash of the seed
+	key := sha256.Sum256([]byte(seed))
+	block, _ := aes.NewCipher(key[:])
+	stream := cipher.NewCTR(block, make([]byte, aes.BlockSize))
+	drbg = &cipher.StreamReader{S: stream, R: zeroReader{}}
+}
+
+// Reader returns the source of randomness of the keys, which is
+// crypto/rand.Reader unless SetSeed was called
+func Reader() io.Reader {
+	if drbg != nil {
+		return drbg
+	}
+	return rand.Reader
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// generateDeterministicKey derives a private key using algorithm from the
+// DRBG and stores it in keystorePath like the BCCSP file keystore does
+func generateDeterministicKey(keystorePath, algorithm string) (bccsp.Key, crypto.Signer, error) {
+
+	var key crypto.Signer
+	var importOpts bccsp.KeyImportOpts
+	var err error
+
+	switch algorithm {
+	case "", ECDSAP256:
+		key, err = deterministicECDSAKey(elliptic.P256())
+		importOpts = &bccsp.ECDSAGoPublicKeyImportOpts{Temporary: true}
+	case ECDSAP384:
+		key, err = deterministicECDSAKey(elliptic.P384())
+		importOpts = &bccsp.ECDSAGoPublicKeyImportOpts{Temporary: true}
+	case RSA2048:
+		key, err = deterministicRSAKey(2048)
+		importOpts = &bccsp.RSAGoPublicKeyImportOpts{Temporary: true}
+	case RSA3072:
+		key, err = deterministicRSAKey(3072)
+		importOpts = &bccsp.RSAGoPublicKeyImportOpts{Temporary: true}
+	case RSA4096:
+		key, err = deterministicRSAKey(4096)
+		importOpts = &bccsp.RSAGoPublicKeyImportOpts{Temporary: true}
+	default:
+		_, err = keyGenOpts(algorithm)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// let the BCCSP identify the key, so that it finds it in the keystore
+	csp, err := getBCCSP(keystorePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, err := csp.KeyImport(key.Public(), importOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, err := utils.PrivateKeyToPEM(key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = os.MkdirAll(keystorePath, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = ioutil.WriteFile(softwareKeyFile(keystorePath, pub.SKI()), raw, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return LoadPrivateKey(keystorePath, pub.SKI())
+}
+
+// deterministicECDSAKey derives an ECDSA key on curve from the DRBG, as
+// described in FIPS 186-4 B.4.1
+func deterministicECDSAKey(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+
+	params := curve.Params()
+	b := make([]byte, params.BitSize/8+8)
+	_, err := io.ReadFull(drbg, b)
+	if err != nil {
+		return nil, err
+	}
+
+	one := big.NewInt(1)
+	d := new(big.Int).SetBytes(b)
+	d.Mod(d, new(big.Int).Sub(params.N, one))
+	d.Add(d, one)
+
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	return priv, nil
+}
+
+// deterministicRSAKey derives a two primes RSA key of bits bits from the DRBG
+func deterministicRSAKey(bits int) (*rsa.PrivateKey, error) {
+
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+	for {
+		p, err := deterministicPrime(bits / 2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := deterministicPrime(bits - bits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+
+		n := new(big.Int).Mul(p, q)
+		if n.BitLen() != bits {
+			continue
+		}
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d := new(big.Int).ModInverse(e, phi)
+		if d == nil {
+			continue
+		}
+
+		priv := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		priv.Precompute()
+		return priv, nil
+	}
+}
+
+// deterministicPrime draws odd numbers of bits bits, with their two top bits
+// set, from the DRBG until one of them is prime
+func deterministicPrime(bits int) (*big.Int, error) {
+
+	b := make([]byte, (bits+7)/8)
+	for {
+		_, err := io.ReadFull(drbg, b)
+		if err != nil {
+			return nil, err
+		}
+		// clear the bits above bits, then set the two top ones
+		b[0] &= byte(0xFF >> uint(len(b)*8-bits))
+		p := new(big.Int).SetBytes(b)
+		p.SetBit(p, bits-1, 1)
+		p.SetBit(p, bits-2, 1)
+		p.SetBit(p, 0, 1)
+		if p.ProbablyPrime(20) {
+			return p, nil
+		}
+	}
+}
+
+// deterministicSigner signs with the key it wraps without using any source
+// of randomness
+type deterministicSigner struct {
+	crypto.Signer
+}
+
+// newDeterministicSigner returns a deterministicSigner for the key saved in
+// keystorePath under ski
+func newDeterministicSigner(keystorePath string, ski []byte) (crypto.Signer, error) {
+
+	key, err := ReadPrivateKey(softwareKeyFile(keystorePath, ski))
+	if err != nil {
+		return nil, err
+	}
+	return &deterministicSigner{key}, nil
+}
+
+// Sign signs digest as described in RFC 6979 for ECDSA keys, with the low-S
+// signatures the BCCSP creates. RSA PKCS#1 v1.5 and Ed25519 signatures are
+// deterministic already.
+func (s *deterministicSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+
+	ecKey, ok := s.Signer.(*ecdsa.PrivateKey)
+	if !ok {
+		return s.Signer.Sign(Reader(), digest, opts)
+	}
+
+	signature, err := ecKey.Sign(nil, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	return sw.SignatureToLowS(&ecKey.PublicKey, signature)
+}
+
+func softwareKeyFile(keystorePath string, ski []byte) string {
+	return filepath.Join(keystorePath, hex.EncodeToString(ski)+"_sk")
+}
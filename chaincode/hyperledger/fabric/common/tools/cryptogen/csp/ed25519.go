@@ -0,0 +1,139 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package csp
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric/bccsp"
+)
+
+// ed25519PrivateKey implements bccsp.Key for Ed25519 private keys, which
+// the BCCSP cannot generate itself
+type ed25519PrivateKey struct {
+	privKey ed25519.PrivateKey
+}
+
+// Bytes is not supported for private keys
+func (k *ed25519PrivateKey) Bytes() ([]byte, error) {
+	return nil, errors.New("Not supported.")
+}
+
+// SKI returns the hash of the public key
+func (k *ed25519PrivateKey) SKI() []byte {
+	return ed25519SKI(k.privKey.Public().(ed25519.PublicKey))
+}
+
+func (k *ed25519PrivateKey) Symmetric() bool { return false }
+
+func (k *ed25519PrivateKey) Private() bool { return true }
+
+func (k *ed25519PrivateKey) PublicKey() (bccsp.Key, error) {
+	return &ed25519PublicKey{k.privKey.Public().(ed25519.PublicKey)}, nil
+}
+
+// ed25519PublicKey implements bccsp.Key for Ed25519 public keys
+type ed25519PublicKey struct {
+	pubKey ed25519.PublicKey
+}
+
+// Bytes returns the PKIX encoding of the public key
+func (k *ed25519PublicKey) Bytes() ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(k.pubKey)
+}
+
+func (k *ed25519PublicKey) SKI() []byte { return ed25519SKI(k.pubKey) }
+
+func (k *ed25519PublicKey) Symmetric() bool { return false }
+
+func (k *ed25519PublicKey) Private() bool { return false }
+
+func (k *ed25519PublicKey) PublicKey() (bccsp.Key, error) { return k, nil }
+
+func ed25519SKI(pubKey ed25519.PublicKey) []byte {
+	hash := sha256.Sum256(pubKey)
+	return hash[:]
+}
+
+// generateEd25519Key creates an Ed25519 private key and stores it in
+// keystorePath using the same naming as the BCCSP file keystore
+func generateEd25519Key(keystorePath string) (bccsp.Key, crypto.Signer, error) {
+
+	seed := make([]byte, ed25519.SeedSize)
+	_, err := io.ReadFull(Reader(), seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	privKey := ed25519.NewKeyFromSeed(seed)
+	priv := &ed25519PrivateKey{privKey}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = os.MkdirAll(keystorePath, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = ioutil.WriteFile(ed25519KeyFile(keystorePath, priv.SKI()),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, privKey, nil
+}
+
+// loadEd25519Key loads the Ed25519 private key identified by ski from
+// keystorePath
+func loadEd25519Key(keystorePath string, ski []byte) (bccsp.Key, crypto.Signer, error) {
+
+	raw, err := ioutil.ReadFile(ed25519KeyFile(keystorePath, ski))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no PEM encoded key found for SKI [%x]", ski)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	privKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("key for SKI [%x] is not an Ed25519 key", ski)
+	}
+
+	return &ed25519PrivateKey{privKey}, privKey, nil
+}
+
+func ed25519KeyFile(keystorePath string, ski []byte) string {
+	return filepath.Join(keystorePath, hex.EncodeToString(ski)+"_sk")
+}
@@ -0,0 +1,136 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package csp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/signer"
+)
+
+// PKCS11Opts describes the PKCS#11 token in which keys are kept
+type PKCS11Opts struct {
+	Library string
+	Label   string
+	Pin     string
+	// Hash is the hash family of the token, SHA2 if empty
+	Hash string
+	// Security is the security level of the token, 256 if zero
+	Security int
+}
+
+// hsm is the PKCS#11 BCCSP in which GenerateKey creates keys, once
+// InitPKCS11 has been called
+var hsm bccsp.BCCSP
+
+// hsmLabel is the label of the token of hsm
+var hsmLabel string
+
+// generateHSMKey creates a private key using algorithm in the PKCS#11 token
+// and stores a reference to it in keystorePath
+func generateHSMKey(keystorePath, algorithm string) (bccsp.Key, crypto.Signer, error) {
+
+	// the PKCS#11 BCCSP only keeps ECDSA keys in the token
+	if algorithm != "" && algorithm != ECDSAP256 && algorithm != ECDSAP384 {
+		return nil, nil, fmt.Errorf("key algorithm '%s' is not supported with PKCS#11, must be %s or %s",
+			algorithm, ECDSAP256, ECDSAP384)
+	}
+	opts, err := keyGenOpts(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, err := hsm.KeyGen(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := signer.New(hsm, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = os.MkdirAll(keystorePath, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = ioutil.WriteFile(keyReferenceFile(keystorePath, priv.SKI()),
+		[]byte(keyReference(priv.SKI())+"\n"), 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, s, nil
+}
+
+// loadHSMKey loads the private key identified by ski from the PKCS#11 token
+func loadHSMKey(ski []byte) (bccsp.Key, crypto.Signer, error) {
+
+	priv, err := hsm.GetKey(ski)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !priv.Private() {
+		return nil, nil, fmt.Errorf("no private key found for SKI [%x] in token %s", ski, hsmLabel)
+	}
+	s, err := signer.New(hsm, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, s, nil
+}
+
+// HasKeyReference returns true if keystorePath holds a reference to the
+// private key of pub kept in a PKCS#11 token
+func HasKeyReference(keystorePath string, pub crypto.PublicKey) bool {
+
+	// only ECDSA keys are kept in tokens, identified like the BCCSP does
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+	hash := sha256.Sum256(elliptic.Marshal(ecPub.Curve, ecPub.X, ecPub.Y))
+
+	_, err := os.Stat(keyReferenceFile(keystorePath, hash[:]))
+	return err == nil
+}
+
+// keyReference returns the PKCS#11 URI of the private key identified by ski
+func keyReference(ski []byte) string {
+
+	id := strings.ToUpper(hex.EncodeToString(ski))
+	escapedID := ""
+	for i := 0; i < len(id); i += 2 {
+		escapedID += "%" + id[i:i+2]
+	}
+
+	return fmt.Sprintf("pkcs11:token=%s;id=%s;type=private", url.PathEscape(hsmLabel), escapedID)
+}
+
+func keyReferenceFile(keystorePath string, ski []byte) string {
+	return filepath.Join(keystorePath, hex.EncodeToString(ski)+"_ref")
+}
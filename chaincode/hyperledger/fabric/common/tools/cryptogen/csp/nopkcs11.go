@@ -0,0 +1,25 @@
+// +build nopkcs11
+
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package csp
+
+import "errors"
+
+// InitPKCS11 is not supported when built without PKCS#11
+func InitPKCS11(opts PKCS11Opts) error {
+	return errors.New("cryptogen was built without PKCS#11 support")
+}
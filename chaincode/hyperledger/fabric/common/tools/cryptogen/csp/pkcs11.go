@@ -0,0 +1,55 @@
+// +build !nopkcs11
+
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package csp
+
+import (
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/hyperledger/fabric/bccsp/pkcs11"
+)
+
+// InitPKCS11 makes GenerateKey create the keys in the PKCS#11 token described
+// by opts, leaving only references to them in the keystores, and
+// LoadPrivateKey find them there. Keys generated by GenerateSoftwareKey are
+// not affected.
+func InitPKCS11(opts PKCS11Opts) error {
+
+	if opts.Hash == "" {
+		opts.Hash = "SHA2"
+	}
+	if opts.Security == 0 {
+		opts.Security = 256
+	}
+	csp, err := factory.GetBCCSPFromOpts(&factory.FactoryOpts{
+		ProviderName: "PKCS11",
+		Pkcs11Opts: &pkcs11.PKCS11Opts{
+			SecLevel:   opts.Security,
+			HashFamily: opts.Hash,
+			Library:    opts.Library,
+			Label:      opts.Label,
+			Pin:        opts.Pin,
+			Sensitive:  true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	hsm = csp
+	hsmLabel = opts.Label
+	return nil
+}
@@ -0,0 +1,87 @@
+// +build !nopkcs11
+
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package csp
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPKCS11 runs against the token described by PKCS11_LIB, PKCS11_LABEL and
+// PKCS11_PIN, e.g. a SoftHSM token initialized with
+//   softhsm2-util --init-token --slot 0 --label ForFabric --so-pin 1234 --pin 98765432
+func TestPKCS11(t *testing.T) {
+
+	lib := os.Getenv("PKCS11_LIB")
+	if lib == "" {
+		t.Skip("PKCS11_LIB is not set")
+	}
+
+	err := InitPKCS11(PKCS11Opts{
+		Library: lib,
+		Label:   os.Getenv("PKCS11_LABEL"),
+		Pin:     os.Getenv("PKCS11_PIN"),
+	})
+	assert.NoError(t, err, "Failed to initialize PKCS#11 token")
+	defer func() { hsm = nil }()
+
+	keystore := filepath.Join(os.TempDir(), "csp-pkcs11-test")
+	defer os.RemoveAll(keystore)
+
+	priv, signer, err := GenerateKey(keystore, ECDSAP384)
+	assert.NoError(t, err, "Failed to generate private key")
+	assert.Equal(t, true, priv.Private(), "Failed to return private key")
+
+	// the keystore only holds a reference to the key
+	files, err := ioutil.ReadDir(keystore)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, hex.EncodeToString(priv.SKI())+"_ref", files[0].Name())
+	ref, err := ioutil.ReadFile(filepath.Join(keystore, files[0].Name()))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(ref), "pkcs11:token="))
+	assert.True(t, HasKeyReference(keystore, signer.Public()))
+
+	loaded, loadedSigner, err := LoadPrivateKey(keystore, priv.SKI())
+	assert.NoError(t, err, "Failed to load private key")
+	assert.Equal(t, priv.SKI(), loaded.SKI())
+	assert.Equal(t, signer.Public(), loadedSigner.Public())
+
+	// software keys are still available for TLS
+	swPriv, _, err := GenerateSoftwareKey(keystore, RSA2048)
+	assert.NoError(t, err, "Failed to generate software key")
+	_, err = os.Stat(filepath.Join(keystore, hex.EncodeToString(swPriv.SKI())+"_sk"))
+	assert.NoError(t, err, "Expected to find private key file")
+
+	_, _, err = GenerateKey(keystore, RSA2048)
+	assert.Error(t, err, "Expected an error with an RSA key in the token")
+}
+
+func TestKeyReference(t *testing.T) {
+
+	hsmLabel = "For Fabric"
+	defer func() { hsmLabel = "" }()
+
+	assert.Equal(t, "pkcs11:token=For%20Fabric;id=%01%AB;type=private", keyReference([]byte{0x01, 0xab}))
+}
@@ -16,11 +16,18 @@ limitations under the License.
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -30,6 +37,7 @@ import (
 	"io/ioutil"
 
 	"github.com/hyperledger/fabric/common/tools/cryptogen/ca"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
 	"github.com/hyperledger/fabric/common/tools/cryptogen/metadata"
 	"github.com/hyperledger/fabric/common/tools/cryptogen/msp"
 )
@@ -41,6 +49,9 @@ const (
 	defaultCNTemplate       = "{{.Hostname}}.{{.Domain}}"
 )
 
+// seededClock is the current time of the CAs when generating from a seed
+var seededClock = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 type HostnameData struct {
 	Prefix string
 	Index  int
@@ -60,31 +71,95 @@ type NodeTemplate struct {
 	SANS     []string `yaml:"SANS"`
 }
 
+type CertSpec struct {
+	Validity           time.Duration `yaml:"Validity"`
+	Country            string        `yaml:"Country"`
+	Province           string        `yaml:"Province"`
+	Locality           string        `yaml:"Locality"`
+	OrganizationalUnit string        `yaml:"OrganizationalUnit"`
+	StreetAddress      string        `yaml:"StreetAddress"`
+}
+
 type NodeSpec struct {
-	Hostname   string   `yaml:"Hostname"`
-	CommonName string   `yaml:"CommonName"`
-	SANS       []string `yaml:"SANS"`
+	Hostname       string   `yaml:"Hostname"`
+	CommonName     string   `yaml:"CommonName"`
+	SANS           []string `yaml:"SANS"`
+	KeyAlgorithm   string   `yaml:"KeyAlgorithm"`
+	IntermediateCA string   `yaml:"IntermediateCA"`
+	CertSpec       `yaml:",inline"`
+	// Attrs are embedded in the signing certificate, only set for users
+	Attrs map[string]string `yaml:"-"`
+}
+
+type UserSpec struct {
+	Name     string            `yaml:"Name"`
+	Admin    bool              `yaml:"Admin"`
+	Attrs    map[string]string `yaml:"Attrs"`
+	CertSpec `yaml:",inline"`
 }
 
 type UsersSpec struct {
-	Count int `yaml:"Count"`
+	Count int        `yaml:"Count"`
+	Specs []UserSpec `yaml:"Specs"`
 }
 
 type OrgSpec struct {
-	Name     string       `yaml:"Name"`
-	Domain   string       `yaml:"Domain"`
-	CA       NodeSpec     `yaml:"CA"`
-	Template NodeTemplate `yaml:"Template"`
-	Specs    []NodeSpec   `yaml:"Specs"`
-	Users    UsersSpec    `yaml:"Users"`
+	Name            string       `yaml:"Name"`
+	Domain          string       `yaml:"Domain"`
+	KeyAlgorithm    string       `yaml:"KeyAlgorithm"`
+	SerialPolicy    string       `yaml:"SerialPolicy"`
+	CA              NodeSpec     `yaml:"CA"`
+	IntermediateCAs []NodeSpec   `yaml:"IntermediateCAs"`
+	EnableNodeOUs   bool         `yaml:"EnableNodeOUs"`
+	Template        NodeTemplate `yaml:"Template"`
+	Specs           []NodeSpec   `yaml:"Specs"`
+	Users           UsersSpec    `yaml:"Users"`
+	CertSpec        `yaml:",inline"`
+}
+
+// PKCS11Spec describes a PKCS#11 token like the BCCSP.PKCS11 section of the
+// core.yaml of a peer
+type PKCS11Spec struct {
+	Library  string `yaml:"Library"`
+	Label    string `yaml:"Label"`
+	Pin      string `yaml:"Pin"`
+	Hash     string `yaml:"Hash"`
+	Security int    `yaml:"Security"`
+}
+
+// BCCSPSpec chooses where keys are kept like the BCCSP section of the
+// core.yaml of a peer
+type BCCSPSpec struct {
+	Default string     `yaml:"Default"`
+	PKCS11  PKCS11Spec `yaml:"PKCS11"`
 }
 
 type Config struct {
+	BCCSP       BCCSPSpec `yaml:"BCCSP"`
 	OrdererOrgs []OrgSpec `yaml:"OrdererOrgs"`
 	PeerOrgs    []OrgSpec `yaml:"PeerOrgs"`
 }
 
 var defaultConfig = `
+# ---------------------------------------------------------------------------
+# "BCCSP" - Where the keys of the CAs and of the signing identities are kept
+# ---------------------------------------------------------------------------
+# Uncomment this section to keep them in a PKCS#11 token rather than in the
+# keystores, which then only hold references to them.  The layout is that of
+# the BCCSP section of the core.yaml of a peer.  The --bccsp and --pkcs11-*
+# flags override these settings, and are the only way to set them for the
+# commands which do not read a configuration, such as renew and revoke.  TLS
+# keys are always kept in the tls folders, from which the nodes read them.
+# ---------------------------------------------------------------------------
+# BCCSP:
+#   Default: PKCS11
+#   PKCS11:
+#     Library: /usr/lib/softhsm/libsofthsm2.so
+#     Label: ForFabric
+#     Pin: 98765432
+#     Hash: SHA2
+#     Security: 256
+
 # ---------------------------------------------------------------------------
 # "OrdererOrgs" - Definition of organizations managing orderer nodes
 # ---------------------------------------------------------------------------
@@ -111,6 +186,40 @@ PeerOrgs:
   - Name: Org1
     Domain: org1.example.com
 
+    # ---------------------------------------------------------------------------
+    # "KeyAlgorithm"
+    # ---------------------------------------------------------------------------
+    # Uncomment this line to choose the algorithm of the keys generated for this
+    # organization.  One of ECDSA-P256 (default), ECDSA-P384, RSA-2048, RSA-3072,
+    # RSA-4096 or Ed25519.  The CA and each Spec may override it.
+    # ---------------------------------------------------------------------------
+    # KeyAlgorithm: ECDSA-P384
+
+    # ---------------------------------------------------------------------------
+    # "SerialPolicy"
+    # ---------------------------------------------------------------------------
+    # Uncomment this line to choose how the CAs of this organization number the
+    # certificates they issue: "random" (default) 128 bit serial numbers, or
+    # "sequential" serial numbers starting at 1.
+    # ---------------------------------------------------------------------------
+    # SerialPolicy: sequential
+
+    # ---------------------------------------------------------------------------
+    # Certificate settings
+    # ---------------------------------------------------------------------------
+    # Uncomment these lines to customize the certificates issued to the nodes
+    # and users of this organization.  Validity defaults to 87600h (10 years)
+    # and the subject defaults to C=US, ST=California, L=San Francisco.  Each
+    # Spec may override them.  They do not apply to the CA certificates, which
+    # are customized by the same fields in the CA section.
+    # ---------------------------------------------------------------------------
+    # Validity: 8760h
+    # Country: GB
+    # Province: England
+    # Locality: London
+    # OrganizationalUnit: Blockchain
+    # StreetAddress: 1 Main Street
+
     # ---------------------------------------------------------------------------
     # "CA"
     # ---------------------------------------------------------------------------
@@ -119,6 +228,33 @@ PeerOrgs:
     # ---------------------------------------------------------------------------
     # CA:
     #    Hostname: ca # implicitly ca.org1.example.com
+    #    KeyAlgorithm: ECDSA-P384
+    #    Validity: 175200h
+    #    Country: GB
+
+    # ---------------------------------------------------------------------------
+    # "IntermediateCAs"
+    # ---------------------------------------------------------------------------
+    # Uncomment this section to create intermediate CAs signed by the CA above.
+    # Each entry is a Spec, with a required Hostname, and takes the certificate
+    # settings of the CA unless it overrides them.  The signing certificates of
+    # the nodes and users are then issued by the first intermediate CA, unless a
+    # Spec chooses another one by its Hostname with its IntermediateCA field.
+    # TLS certificates are still issued by the TLS CA.
+    # ---------------------------------------------------------------------------
+    # IntermediateCAs:
+    #   - Hostname: ica1 # implicitly ica1.org1.example.com
+    #   - Hostname: ica2
+
+    # ---------------------------------------------------------------------------
+    # "EnableNodeOUs"
+    # ---------------------------------------------------------------------------
+    # Uncomment this line to add the organizational unit of their role ("peer",
+    # "orderer", "client" or "admin") to the signing certificates of the nodes
+    # and users of this organization, and to enable NodeOUs in the config.yaml
+    # of each of its MSPs accordingly.
+    # ---------------------------------------------------------------------------
+    # EnableNodeOUs: true
 
     # ---------------------------------------------------------------------------
     # "Specs"
@@ -126,7 +262,7 @@ PeerOrgs:
     # Uncomment this section to enable the explicit definition of hosts in your
     # configuration.  Most users will want to use Template, below
     #
-    # Specs is an array of Spec entries.  Each Spec entry consists of two fields:
+    # Specs is an array of Spec entries.  Each Spec entry consists of these fields:
     #   - Hostname:   (Required) The desired hostname, sans the domain.
     #   - CommonName: (Optional) Specifies the template or explicit override for
     #                 the CN.  By default, this is the template:
@@ -141,6 +277,13 @@ PeerOrgs:
     #                 NOTE: Two implicit entries are created for you:
     #                     - {{ .CommonName }}
     #                     - {{ .Hostname }}
+    #   - KeyAlgorithm: (Optional) Overrides the KeyAlgorithm of the org for the
+    #                 keys of this node.
+    #   - Validity, Country, Province, Locality, OrganizationalUnit and
+    #     StreetAddress: (Optional) Override the certificate settings of the org
+    #                 for the certificates of this node.
+    #   - IntermediateCA: (Optional) The Hostname of the intermediate CA issuing
+    #                 the signing certificate of this node.
     # ---------------------------------------------------------------------------
     # Specs:
     #   - Hostname: foo # implicitly "foo.org1.example.com"
@@ -149,6 +292,9 @@ PeerOrgs:
     #       - "bar.{{.Domain}}"
     #       - "altfoo.{{.Domain}}"
     #       - "{{.Hostname}}.org6.net"
+    #     KeyAlgorithm: RSA-2048
+    #     Validity: 720h
+    #     # IntermediateCA: ica2
     #   - Hostname: bar
     #   - Hostname: baz
 
@@ -175,9 +321,22 @@ PeerOrgs:
     # "Users"
     # ---------------------------------------------------------------------------
     # Count: The number of user accounts _in addition_ to Admin
+    # Specs: Named user accounts, implicitly "<Name>@<Domain>". Admin users
+    #        are admins of the org like Admin. Attrs are embedded in the
+    #        certificate as read by the cid library of the chaincode shim, e.g.
+    #        for attribute based access control. Validity, Country, Province,
+    #        Locality, OrganizationalUnit and StreetAddress override those of
+    #        the org.
     # ---------------------------------------------------------------------------
     Users:
       Count: 1
+      # Specs:
+      #   - Name: alice
+      #     OrganizationalUnit: sales
+      #     Attrs:
+      #       abac.init: "true"
+      #   - Name: bob
+      #     Admin: true
 
   # ---------------------------------------------------------------------------
   # Org2: See "Org1" for full specification
@@ -194,23 +353,88 @@ PeerOrgs:
 var (
 	app = kingpin.New("cryptogen", "Utility for generating Hyperledger Fabric key material")
 
+	bccspProvider = app.Flag("bccsp", "The BCCSP in which the keys of the CAs and of the signing identities are kept, SW or PKCS11, overriding BCCSP.Default of the configuration (default SW)").Enum("SW", "PKCS11")
+	pkcs11Library = app.Flag("pkcs11-library", "The PKCS#11 library of the token used with --bccsp=PKCS11, overriding BCCSP.PKCS11.Library of the configuration").Envar("PKCS11_LIB").String()
+	pkcs11Label   = app.Flag("pkcs11-label", "The label of the token used with --bccsp=PKCS11, overriding BCCSP.PKCS11.Label of the configuration").Envar("PKCS11_LABEL").String()
+	pkcs11Pin     = app.Flag("pkcs11-pin", "The PIN of the token used with --bccsp=PKCS11, overriding BCCSP.PKCS11.Pin of the configuration").Envar("PKCS11_PIN").String()
+
 	gen        = app.Command("generate", "Generate key material")
 	outputDir  = gen.Flag("output", "The output directory in which to place artifacts").Default("crypto-config").String()
 	configFile = gen.Flag("config", "The configuration template to use").File()
+	seed       = gen.Flag("seed", "Derive all keys, serial numbers and signatures from this seed and date the certificates "+seededClock.Format("2006-01-02")+", for reproducible test fixtures only").String()
 
 	showtemplate = app.Command("showtemplate", "Show the default configuration template")
 
+	ext           = app.Command("extend", "Extend existing key material with the orgs, nodes and users missing from it")
+	inputDir      = ext.Flag("input", "The input directory in which the existing artifacts are placed").Default("crypto-config").String()
+	extConfigFile = ext.Flag("config", "The configuration template to use").File()
+
+	rev         = app.Command("revoke", "Revoke an identity and update the CRLs of its organization")
+	revInputDir = rev.Flag("input", "The input directory in which the existing artifacts are placed").Default("crypto-config").String()
+	revOrg      = rev.Flag("org", "The domain of the organization which issued the identity").Required().String()
+	revName     = rev.Flag("name", "The name of the identity to revoke, e.g. User1@org1.example.com").Required().String()
+	crlExpiry   = rev.Flag("crlexpiry", "How long the generated CRL is valid").Default("8760h").Duration()
+
+	ren           = app.Command("renew", "Reissue the certificates of existing nodes and users which are about to expire")
+	renInputDir   = ren.Flag("input", "The input directory in which the existing artifacts are placed").Default("crypto-config").String()
+	renewWindow   = ren.Flag("window", "Renew the certificates expiring within this period").Default("720h").Duration()
+	renewValidity = ren.Flag("validity", "How long the renewed certificates are valid, as long as before if zero").Default("0s").Duration()
+
+	ver           = app.Command("verify", "Check the consistency of existing key material")
+	verInputDir   = ver.Flag("input", "The input directory in which the existing artifacts are placed").Default("crypto-config").String()
+	verConfigFile = ver.Flag("config", "The configuration template from which the artifacts were generated, to check their hostnames and SANs").File()
+	verFormat     = ver.Flag("format", "The format of the report, text or json").Default("text").Enum("text", "json")
+
+	em          = app.Command("emit", "Write connection profiles, and optionally node environments, for existing key material. MSP IDs are the names of the orgs followed by MSP.")
+	emInputDir  = em.Flag("input", "The input directory in which the existing artifacts are placed").Default("crypto-config").String()
+	emOutputDir = em.Flag("output", "The output directory in which to place the connection profiles").Default("connection-profiles").String()
+	emConfig    = em.Flag("config", "The configuration template from which the artifacts were generated").File()
+	emitEnv     = em.Flag("env", "Also write the environment of each peer and orderer").Bool()
+
+	exp          = app.Command("export-ca", "Write a fabric-ca-server home for the CA, the intermediate CAs and the TLS CA of each org of existing key material")
+	expInputDir  = exp.Flag("input", "The input directory in which the existing artifacts are placed").Default("crypto-config").String()
+	expOutputDir = exp.Flag("output", "The output directory in which to place the fabric-ca-server homes").Default("fabric-ca").String()
+	expConfig    = exp.Flag("config", "The configuration template from which the artifacts were generated").File()
+
 	version = app.Command("version", "Show version information")
 )
 
 func main() {
 	kingpin.Version("0.0.1")
-	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	// the commands using keys set up the BCCSP themselves, from the
+	// configuration they read if they take one
+	switch command {
 
 	// "generate" command
 	case gen.FullCommand():
 		generate()
 
+	// "extend" command
+	case ext.FullCommand():
+		extend()
+
+	// "revoke" command
+	case rev.FullCommand():
+		revoke()
+
+	// "renew" command
+	case ren.FullCommand():
+		renew()
+
+	// "verify" command
+	case ver.FullCommand():
+		verify()
+
+	// "emit" command
+	case em.FullCommand():
+		emit()
+
+	// "export-ca" command
+	case exp.FullCommand():
+		exportCA()
+
 	// "showtemplate" command
 	case showtemplate.FullCommand():
 		fmt.Print(defaultConfig)
@@ -224,17 +448,18 @@ func main() {
 }
 
 func getConfig() (*Config, error) {
-	var configData string
+	configData := defaultConfig
 
-	if *configFile != nil {
-		data, err := ioutil.ReadAll(*configFile)
+	for _, file := range []*os.File{*configFile, *extConfigFile, *verConfigFile, *emConfig, *expConfig} {
+		if file == nil {
+			continue
+		}
+		data, err := ioutil.ReadAll(file)
 		if err != nil {
 			return nil, fmt.Errorf("Error reading configuration: %s", err)
 		}
 
 		configData = string(data)
-	} else {
-		configData = defaultConfig
 	}
 
 	config := &Config{}
@@ -246,6 +471,56 @@ func getConfig() (*Config, error) {
 	return config, nil
 }
 
+// bccspConfig returns the BCCSP section of config, if any, overridden by the
+// flags
+func bccspConfig(config *Config) BCCSPSpec {
+	var spec BCCSPSpec
+	if config != nil {
+		spec = config.BCCSP
+	}
+
+	if *bccspProvider != "" {
+		spec.Default = *bccspProvider
+	}
+	if spec.Default == "" {
+		spec.Default = "SW"
+	}
+	if *pkcs11Library != "" {
+		spec.PKCS11.Library = *pkcs11Library
+	}
+	if *pkcs11Label != "" {
+		spec.PKCS11.Label = *pkcs11Label
+	}
+	if *pkcs11Pin != "" {
+		spec.PKCS11.Pin = *pkcs11Pin
+	}
+
+	return spec
+}
+
+// initBCCSP keeps the keys in the PKCS#11 token of spec rather than in the
+// keystores if spec chooses PKCS11
+func initBCCSP(spec BCCSPSpec) {
+	switch spec.Default {
+	case "SW":
+	case "PKCS11":
+		err := csp.InitPKCS11(csp.PKCS11Opts{
+			Library:  spec.PKCS11.Library,
+			Label:    spec.PKCS11.Label,
+			Pin:      spec.PKCS11.Pin,
+			Hash:     spec.PKCS11.Hash,
+			Security: spec.PKCS11.Security,
+		})
+		if err != nil {
+			fmt.Printf("Error initializing PKCS#11 token %s:\n%v\n", spec.PKCS11.Label, err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Error: unknown BCCSP '%s', must be SW or PKCS11\n", spec.Default)
+		os.Exit(1)
+	}
+}
+
 func generate() {
 
 	config, err := getConfig()
@@ -254,6 +529,21 @@ func generate() {
 		os.Exit(-1)
 	}
 
+	bccspSpec := bccspConfig(config)
+	if len(*seed) > 0 {
+		if bccspSpec.Default != "SW" {
+			fmt.Printf("Error: --seed cannot be used with the %s BCCSP\n", bccspSpec.Default)
+			os.Exit(1)
+		}
+		banner := strings.Repeat("*", 78)
+		fmt.Fprintf(os.Stderr, "%s\nWARNING: --seed makes every private key recreatable by anyone knowing the seed.\n"+
+			"WARNING: the output is ONLY for tests and must NEVER be used to run a network.\n%s\n", banner, banner)
+		csp.SetSeed(*seed)
+		ca.SetClock(seededClock)
+	}
+
+	initBCCSP(bccspSpec)
+
 	for _, orgSpec := range config.PeerOrgs {
 		err = renderOrgSpec(&orgSpec, "peer")
 		if err != nil {
@@ -264,277 +554,1984 @@ func generate() {
 	}
 
 	for _, orgSpec := range config.OrdererOrgs {
-		renderOrgSpec(&orgSpec, "orderer")
+		err = renderOrgSpec(&orgSpec, "orderer")
 		if err != nil {
 			fmt.Printf("Error processing orderer configuration: %s", err)
 			os.Exit(-1)
 		}
 		generateOrdererOrg(*outputDir, orgSpec)
 	}
+
+	writeManifest(*outputDir)
 }
 
-func parseTemplate(input string, data interface{}) (string, error) {
+func extend() {
 
-	t, err := template.New("parse").Parse(input)
+	config, err := getConfig()
 	if err != nil {
-		return "", fmt.Errorf("Error parsing template: %s", err)
+		fmt.Printf("Error reading config: %s", err)
+		os.Exit(-1)
 	}
+	initBCCSP(bccspConfig(config))
 
-	output := new(bytes.Buffer)
-	err = t.Execute(output, data)
-	if err != nil {
-		return "", fmt.Errorf("Error executing template: %s", err)
+	for _, orgSpec := range config.PeerOrgs {
+		err = renderOrgSpec(&orgSpec, "peer")
+		if err != nil {
+			fmt.Printf("Error processing peer configuration: %s", err)
+			os.Exit(-1)
+		}
+		extendPeerOrg(*inputDir, orgSpec)
 	}
 
-	return output.String(), nil
-}
-
-func parseTemplateWithDefault(input, defaultInput string, data interface{}) (string, error) {
-
-	// Use the default if the input is an empty string
-	if len(input) == 0 {
-		input = defaultInput
+	for _, orgSpec := range config.OrdererOrgs {
+		err = renderOrgSpec(&orgSpec, "orderer")
+		if err != nil {
+			fmt.Printf("Error processing orderer configuration: %s", err)
+			os.Exit(-1)
+		}
+		extendOrdererOrg(*inputDir, orgSpec)
 	}
 
-	return parseTemplate(input, data)
+	writeManifest(*inputDir)
 }
 
-func renderNodeSpec(domain string, spec *NodeSpec) error {
-	data := SpecData{
-		Hostname: spec.Hostname,
-		Domain:   domain,
+func revoke() {
+
+	initBCCSP(bccspConfig(nil))
+
+	orgDir, err := findOrgDir(*revInputDir, *revOrg)
+	if err != nil {
+		fmt.Printf("Error finding org %s:\n%v\n", *revOrg, err)
+		os.Exit(1)
 	}
 
-	// Process our CommonName
-	cn, err := parseTemplateWithDefault(spec.CommonName, defaultCNTemplate, data)
+	certFile, err := findSignCert(orgDir, *revName)
 	if err != nil {
-		return err
+		fmt.Printf("Error finding identity %s:\n%v\n", *revName, err)
+		os.Exit(1)
+	}
+	cert, err := ca.LoadCertificate(certFile)
+	if err != nil {
+		fmt.Printf("Error loading certificate of %s:\n%v\n", *revName, err)
+		os.Exit(1)
 	}
 
-	spec.CommonName = cn
-	data.CommonName = cn
+	// the identity may have been issued by an intermediate CA, in which case
+	// the intermediate CA keeps track of the revocation
+	signCA, caDir, err := findIssuer(filepath.Join(orgDir, "ca"), cert)
+	if err != nil {
+		fmt.Printf("Error finding signCA of %s:\n%v\n", *revName, err)
+		os.Exit(1)
+	}
 
-	// Save off our original, unprocessed SANS entries
-	origSANS := spec.SANS
+	err = signCA.RevokeCertificate(caDir, *revName, cert)
+	if err != nil {
+		fmt.Printf("Error revoking %s:\n%v\n", *revName, err)
+		os.Exit(1)
+	}
+	crl, err := signCA.GenerateCRL(caDir, *crlExpiry)
+	if err != nil {
+		fmt.Printf("Error generating CRL for org %s:\n%v\n", *revOrg, err)
+		os.Exit(1)
+	}
 
-	// Set our implicit SANS entries for CN/Hostname
-	spec.SANS = []string{cn, spec.Hostname}
+	// every MSP of the org trusts the signing CA
+	mspDirs, err := filepath.Glob(filepath.Join(orgDir, "*", "*", "msp"))
+	if err != nil {
+		fmt.Printf("Error listing MSPs for org %s:\n%v\n", *revOrg, err)
+		os.Exit(1)
+	}
+	mspDirs = append(mspDirs, filepath.Join(orgDir, "msp"))
 
-	// Finally, process any remaining SANS entries
-	for _, _san := range origSANS {
-		san, err := parseTemplate(_san, data)
+	for _, mspDir := range mspDirs {
+		err = msp.ExportCRL(mspDir, signCA, crl)
 		if err != nil {
-			return err
+			fmt.Printf("Error writing CRL to %s:\n%v\n", mspDir, err)
+			os.Exit(1)
 		}
-
-		spec.SANS = append(spec.SANS, san)
 	}
 
-	return nil
+	fmt.Printf("Revoked %s, CRL written to %d MSPs\n", *revName, len(mspDirs))
 }
 
-func renderOrgSpec(orgSpec *OrgSpec, prefix string) error {
-	// First process all of our templated nodes
-	for i := 0; i < orgSpec.Template.Count; i++ {
-		data := HostnameData{
-			Prefix: prefix,
-			Index:  i + orgSpec.Template.Start,
-			Domain: orgSpec.Domain,
-		}
+func renew() {
 
-		hostname, err := parseTemplateWithDefault(orgSpec.Template.Hostname, defaultHostnameTemplate, data)
-		if err != nil {
-			return err
-		}
+	initBCCSP(bccspConfig(nil))
 
-		spec := NodeSpec{
-			Hostname: hostname,
-			SANS:     orgSpec.Template.SANS,
-		}
-		orgSpec.Specs = append(orgSpec.Specs, spec)
+	deadline := time.Now().Add(*renewWindow)
+
+	orgDirs, err := filepath.Glob(filepath.Join(*renInputDir, "*Organizations", "*"))
+	if err != nil {
+		fmt.Printf("Error listing orgs in %s:\n%v\n", *renInputDir, err)
+		os.Exit(1)
 	}
 
-	// Touch up all general node-specs to add the domain
-	for idx, spec := range orgSpec.Specs {
-		err := renderNodeSpec(orgSpec.Domain, &spec)
+	scanned, renewed := 0, 0
+	for _, orgDir := range orgDirs {
+		signCerts, err := filepath.Glob(filepath.Join(orgDir, "*", "*", "msp", "signcerts", "*-cert.pem"))
 		if err != nil {
-			return err
+			fmt.Printf("Error listing certificates in %s:\n%v\n", orgDir, err)
+			os.Exit(1)
+		}
+		tlsCerts, err := filepath.Glob(filepath.Join(orgDir, "*", "*", "tls", "server.crt"))
+		if err != nil {
+			fmt.Printf("Error listing certificates in %s:\n%v\n", orgDir, err)
+			os.Exit(1)
 		}
 
-		orgSpec.Specs[idx] = spec
+		for _, certFile := range append(signCerts, tlsCerts...) {
+			cert, err := ca.LoadCertificate(certFile)
+			if err != nil {
+				fmt.Printf("Error loading certificate %s:\n%v\n", certFile, err)
+				os.Exit(1)
+			}
+			scanned++
+			if cert.NotAfter.After(deadline) {
+				continue
+			}
+
+			if filepath.Base(certFile) == "server.crt" {
+				err = renewTLSCert(orgDir, certFile, cert)
+			} else {
+				err = renewSignCert(orgDir, certFile, cert)
+			}
+			if err != nil {
+				fmt.Printf("Error renewing certificate %s:\n%v\n", certFile, err)
+				os.Exit(1)
+			}
+			renewed++
+		}
 	}
 
-	// Process the CA node-spec in the same manner
-	if len(orgSpec.CA.Hostname) == 0 {
-		orgSpec.CA.Hostname = "ca"
-	}
-	err := renderNodeSpec(orgSpec.Domain, &orgSpec.CA)
-	if err != nil {
-		return err
-	}
+	fmt.Printf("Renewed %d of %d certificates expiring before %s\n", renewed, scanned,
+		deadline.UTC().Format(time.RFC3339))
 
-	return nil
+	if renewed > 0 {
+		writeManifest(*renInputDir)
+	}
 }
 
-func generatePeerOrg(baseDir string, orgSpec OrgSpec) {
-
-	orgName := orgSpec.Domain
+// renewSignCert reissues the signing certificate cert saved in certFile. The
+// copies of cert in the admincerts folders of the MSPs in orgDir are replaced
+// by the new certificate.
+func renewSignCert(orgDir, certFile string, cert *x509.Certificate) error {
 
-	fmt.Println(orgName)
-	// generate CAs
-	orgDir := filepath.Join(baseDir, "peerOrganizations", orgName)
-	caDir := filepath.Join(orgDir, "ca")
-	tlsCADir := filepath.Join(orgDir, "tlsca")
-	mspDir := filepath.Join(orgDir, "msp")
-	peersDir := filepath.Join(orgDir, "peers")
-	usersDir := filepath.Join(orgDir, "users")
-	adminCertsDir := filepath.Join(mspDir, "admincerts")
-	// generate signing CA
-	signCA, err := ca.NewCA(caDir, orgName, orgSpec.CA.CommonName)
+	signCA, _, err := findIssuer(filepath.Join(orgDir, "ca"), cert)
 	if err != nil {
-		fmt.Printf("Error generating signCA for org %s:\n%v\n", orgName, err)
-		os.Exit(1)
+		return err
 	}
-	// generate TLS CA
-	tlsCA, err := ca.NewCA(tlsCADir, orgName, "tls"+orgSpec.CA.CommonName)
+
+	oldCert, err := ioutil.ReadFile(certFile)
 	if err != nil {
-		fmt.Printf("Error generating tlsCA for org %s:\n%v\n", orgName, err)
-		os.Exit(1)
+		return err
 	}
-
-	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA)
+	newCert, err := signCA.RenewCertificate(filepath.Dir(certFile),
+		strings.TrimSuffix(filepath.Base(certFile), "-cert.pem"), cert, *renewValidity)
 	if err != nil {
-		fmt.Printf("Error generating MSP for org %s:\n%v\n", orgName, err)
-		os.Exit(1)
+		return err
 	}
+	reportRenewal(certFile, cert, newCert)
 
-	generateNodes(peersDir, orgSpec.Specs, signCA, tlsCA)
-
-	// TODO: add ability to specify usernames
-	users := []NodeSpec{}
-	for j := 1; j <= orgSpec.Users.Count; j++ {
-		user := NodeSpec{
-			CommonName: fmt.Sprintf("%s%d@%s", userBaseName, j, orgName),
-		}
-
-		users = append(users, user)
-	}
-	// add an admin user
-	adminUser := NodeSpec{
-		CommonName: fmt.Sprintf("%s@%s", adminBaseName, orgName),
+	adminCerts, err := filepath.Glob(filepath.Join(orgDir, "*", "*", "msp", "admincerts", "*"))
+	if err != nil {
+		return err
 	}
-
-	users = append(users, adminUser)
-	generateNodes(usersDir, users, signCA, tlsCA)
-
-	// copy the admin cert to the org's MSP admincerts
-	err = copyAdminCert(usersDir, adminCertsDir, adminUser.CommonName)
+	orgAdminCerts, err := filepath.Glob(filepath.Join(orgDir, "msp", "admincerts", "*"))
 	if err != nil {
-		fmt.Printf("Error copying admin cert for org %s:\n%v\n",
-			orgName, err)
-		os.Exit(1)
+		return err
 	}
-
-	// copy the admin cert to each of the org's peer's MSP admincerts
-	for _, spec := range orgSpec.Specs {
-		err = copyAdminCert(usersDir,
-			filepath.Join(peersDir, spec.CommonName, "msp", "admincerts"), adminUser.CommonName)
+	for _, adminCert := range append(orgAdminCerts, adminCerts...) {
+		data, err := ioutil.ReadFile(adminCert)
 		if err != nil {
-			fmt.Printf("Error copying admin cert for org %s peer %s:\n%v\n",
-				orgName, spec.CommonName, err)
-			os.Exit(1)
+			return err
+		}
+		if bytes.Equal(data, oldCert) {
+			err = copyFile(certFile, adminCert)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Updated %s\n", adminCert)
 		}
 	}
+
+	return nil
 }
 
-func copyAdminCert(usersDir, adminCertsDir, adminUserName string) error {
-	// delete the contents of admincerts
-	err := os.RemoveAll(adminCertsDir)
+// renewTLSCert reissues the TLS certificate cert saved in certFile
+func renewTLSCert(orgDir, certFile string, cert *x509.Certificate) error {
+
+	tlsCA, _, err := findIssuer(filepath.Join(orgDir, "tlsca"), cert)
 	if err != nil {
 		return err
 	}
-	// recreate the admincerts directory
-	err = os.MkdirAll(adminCertsDir, 0755)
+
+	// the certificate is saved under the name of the node, then renamed
+	// like msp.GenerateLocalMSP does
+	tlsDir := filepath.Dir(certFile)
+	name := filepath.Base(filepath.Dir(tlsDir))
+	newCert, err := tlsCA.RenewCertificate(tlsDir, name, cert, *renewValidity)
 	if err != nil {
 		return err
 	}
-	err = copyFile(filepath.Join(usersDir, adminUserName, "msp", "signcerts",
-		adminUserName+"-cert.pem"), filepath.Join(adminCertsDir,
-		adminUserName+"-cert.pem"))
+	err = os.Rename(filepath.Join(tlsDir, name+"-cert.pem"), certFile)
 	if err != nil {
 		return err
 	}
+	reportRenewal(certFile, cert, newCert)
+
 	return nil
+}
 
+func reportRenewal(certFile string, oldCert, newCert *x509.Certificate) {
+	fmt.Printf("Renewed %s, expiry %s -> %s\n", certFile,
+		oldCert.NotAfter.UTC().Format(time.RFC3339), newCert.NotAfter.UTC().Format(time.RFC3339))
 }
 
-func generateNodes(baseDir string, nodes []NodeSpec, signCA *ca.CA, tlsCA *ca.CA) {
+// verifyProblem is an inconsistency found by the verify command
+type verifyProblem struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+}
 
-	for _, node := range nodes {
-		nodeDir := filepath.Join(baseDir, node.CommonName)
-		err := msp.GenerateLocalMSP(nodeDir, node.CommonName, node.SANS, signCA, tlsCA)
+// verifyReport is the outcome of the verify command
+type verifyReport struct {
+	Checked  int             `json:"checked"`
+	Problems []verifyProblem `json:"problems"`
+}
+
+func (report *verifyReport) add(path string, problems []error) {
+	report.Checked++
+	for _, problem := range problems {
+		report.Problems = append(report.Problems, verifyProblem{Path: path, Problem: problem.Error()})
+	}
+}
+
+func verify() {
+
+	report := &verifyReport{Problems: []verifyProblem{}}
+
+	// the SANs the configuration specifies for each node directory
+	nodeSANs := map[string][]string{}
+	if *verConfigFile != nil {
+		config, err := getConfig()
 		if err != nil {
-			fmt.Printf("Error generating local MSP for %s:\n%v\n", node, err)
-			os.Exit(1)
+			fmt.Printf("Error reading config: %s", err)
+			os.Exit(-1)
 		}
+		initBCCSP(bccspConfig(config))
+		nodeSANs = verifyConfig(*verInputDir, config, report)
+	} else {
+		initBCCSP(bccspConfig(nil))
 	}
-}
 
-func generateOrdererOrg(baseDir string, orgSpec OrgSpec) {
+	orgDirs, err := filepath.Glob(filepath.Join(*verInputDir, "*Organizations", "*"))
+	if err != nil {
+		fmt.Printf("Error listing orgs in %s:\n%v\n", *verInputDir, err)
+		os.Exit(1)
+	}
 
-	orgName := orgSpec.Domain
+	for _, orgDir := range orgDirs {
+		mspDir := filepath.Join(orgDir, "msp")
+		report.add(mspDir, msp.VerifyVerifyingMSP(mspDir))
+
+		for _, nodesDir := range []string{"peers", "orderers", "users"} {
+			nodeDirs, err := filepath.Glob(filepath.Join(orgDir, nodesDir, "*"))
+			if err != nil {
+				fmt.Printf("Error listing nodes in %s:\n%v\n", orgDir, err)
+				os.Exit(1)
+			}
+			for _, nodeDir := range nodeDirs {
+				// nodes the configuration does not specify must at least
+				// hold their own name in the SANs of their TLS certificate
+				sans, exists := nodeSANs[nodeDir]
+				if !exists && nodesDir != "users" {
+					sans = []string{filepath.Base(nodeDir)}
+				}
+				report.add(nodeDir, msp.VerifyLocalMSP(nodeDir, sans))
+			}
+		}
+	}
 
-	// generate CAs
-	orgDir := filepath.Join(baseDir, "ordererOrganizations", orgName)
+	if *verFormat == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding report:\n%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	} else {
+		for _, problem := range report.Problems {
+			fmt.Printf("%s: %s\n", problem.Path, problem.Problem)
+		}
+		fmt.Printf("Checked %d MSPs, found %d problems\n", report.Checked, len(report.Problems))
+	}
+
+	if len(report.Problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// verifyConfig adds to report the orgs, nodes and users of config which have
+// no artifacts in baseDir, and returns the SANs of the nodes of config by the
+// directory of their artifacts
+func verifyConfig(baseDir string, config *Config, report *verifyReport) map[string][]string {
+
+	nodeSANs := map[string][]string{}
+	missing := func(path, problem string) {
+		report.Problems = append(report.Problems, verifyProblem{Path: path, Problem: problem})
+	}
+
+	check := func(orgSpecs []OrgSpec, prefix, orgsDir, nodesDir string) {
+		for _, orgSpec := range orgSpecs {
+			err := renderOrgSpec(&orgSpec, prefix)
+			if err != nil {
+				fmt.Printf("Error processing %s configuration: %s", prefix, err)
+				os.Exit(-1)
+			}
+
+			orgDir := filepath.Join(baseDir, orgsDir, orgSpec.Domain)
+			if _, err := os.Stat(orgDir); err != nil {
+				missing(orgDir, "org is missing")
+				continue
+			}
+			for _, spec := range orgSpec.Specs {
+				nodeSANs[filepath.Join(orgDir, nodesDir, spec.CommonName)] = spec.SANS
+			}
+			for _, node := range missingNodes(filepath.Join(orgDir, nodesDir), orgSpec.Specs) {
+				missing(filepath.Join(orgDir, nodesDir, node.CommonName), "node is missing")
+			}
+			users := append(userNodes(orgSpec), adminNodes(orgSpec)...)
+			for _, user := range missingNodes(filepath.Join(orgDir, "users"), users) {
+				missing(filepath.Join(orgDir, "users", user.CommonName), "user is missing")
+			}
+		}
+	}
+
+	check(config.PeerOrgs, "peer", "peerOrganizations", "peers")
+	check(config.OrdererOrgs, "orderer", "ordererOrganizations", "orderers")
+
+	return nodeSANs
+}
+
+// Ports on which the nodes listen in the emitted connection profiles and
+// environments
+const (
+	ordererPort   = 7050
+	peerPort      = 7051
+	peerEventPort = 7053
+	caPort        = 7054
+)
+
+// connectionProfile is a common connection profile, as read by the SDKs
+type connectionProfile struct {
+	Name                   string                         `json:"name" yaml:"name"`
+	Version                string                         `json:"version" yaml:"version"`
+	Client                 profileClient                  `json:"client" yaml:"client"`
+	Organizations          map[string]profileOrganization `json:"organizations" yaml:"organizations"`
+	Orderers               map[string]profileNode         `json:"orderers,omitempty" yaml:"orderers,omitempty"`
+	Peers                  map[string]profileNode         `json:"peers,omitempty" yaml:"peers,omitempty"`
+	CertificateAuthorities map[string]profileNode         `json:"certificateAuthorities,omitempty" yaml:"certificateAuthorities,omitempty"`
+}
+
+type profileClient struct {
+	Organization string `json:"organization" yaml:"organization"`
+}
+
+type profileOrganization struct {
+	MSPID                  string       `json:"mspid" yaml:"mspid"`
+	Peers                  []string     `json:"peers,omitempty" yaml:"peers,omitempty"`
+	CertificateAuthorities []string     `json:"certificateAuthorities,omitempty" yaml:"certificateAuthorities,omitempty"`
+	AdminPrivateKey        *profilePath `json:"adminPrivateKey,omitempty" yaml:"adminPrivateKey,omitempty"`
+	SignedCert             *profilePath `json:"signedCert,omitempty" yaml:"signedCert,omitempty"`
+}
+
+type profileNode struct {
+	URL         string            `json:"url" yaml:"url"`
+	EventURL    string            `json:"eventUrl,omitempty" yaml:"eventUrl,omitempty"`
+	CAName      string            `json:"caName,omitempty" yaml:"caName,omitempty"`
+	GRPCOptions map[string]string `json:"grpcOptions,omitempty" yaml:"grpcOptions,omitempty"`
+	TLSCACerts  profilePEM        `json:"tlsCACerts" yaml:"tlsCACerts"`
+}
+
+type profilePath struct {
+	Path string `json:"path" yaml:"path"`
+}
+
+type profilePEM struct {
+	PEM string `json:"pem" yaml:"pem"`
+}
+
+func emit() {
+
+	config, err := getConfig()
+	if err != nil {
+		fmt.Printf("Error reading config: %s", err)
+		os.Exit(-1)
+	}
+	initBCCSP(bccspConfig(config))
+
+	// the sections shared by the profiles of every org
+	profile := connectionProfile{
+		Version:                "1.0",
+		Organizations:          map[string]profileOrganization{},
+		Orderers:               map[string]profileNode{},
+		Peers:                  map[string]profileNode{},
+		CertificateAuthorities: map[string]profileNode{},
+	}
+
+	peerOrgs, ordererOrgs := []OrgSpec{}, []OrgSpec{}
+	for _, orgSpec := range config.PeerOrgs {
+		err = renderOrgSpec(&orgSpec, "peer")
+		if err != nil {
+			fmt.Printf("Error processing peer configuration: %s", err)
+			os.Exit(-1)
+		}
+		err = addOrgToProfile(&profile, *emInputDir, "peerOrganizations", orgSpec, true)
+		if err != nil {
+			fmt.Printf("Error reading artifacts of org %s:\n%v\n", orgSpec.Domain, err)
+			os.Exit(1)
+		}
+		peerOrgs = append(peerOrgs, orgSpec)
+	}
+
+	for _, orgSpec := range config.OrdererOrgs {
+		err = renderOrgSpec(&orgSpec, "orderer")
+		if err != nil {
+			fmt.Printf("Error processing orderer configuration: %s", err)
+			os.Exit(-1)
+		}
+		err = addOrgToProfile(&profile, *emInputDir, "ordererOrganizations", orgSpec, false)
+		if err != nil {
+			fmt.Printf("Error reading artifacts of org %s:\n%v\n", orgSpec.Domain, err)
+			os.Exit(1)
+		}
+		ordererOrgs = append(ordererOrgs, orgSpec)
+	}
+
+	err = os.MkdirAll(*emOutputDir, 0755)
+	if err != nil {
+		fmt.Printf("Error creating %s:\n%v\n", *emOutputDir, err)
+		os.Exit(1)
+	}
+
+	// each client org gets its own profile
+	for _, orgSpec := range peerOrgs {
+		profile.Name = orgSpec.Domain
+		profile.Client.Organization = orgSpec.Name
+
+		err = writeProfile(filepath.Join(*emOutputDir, orgSpec.Domain), profile)
+		if err != nil {
+			fmt.Printf("Error writing connection profile of org %s:\n%v\n", orgSpec.Domain, err)
+			os.Exit(1)
+		}
+	}
+
+	if !*emitEnv {
+		return
+	}
+	envDir := filepath.Join(*emOutputDir, "env")
+	err = os.MkdirAll(envDir, 0755)
+	if err != nil {
+		fmt.Printf("Error creating %s:\n%v\n", envDir, err)
+		os.Exit(1)
+	}
+	for _, orgSpec := range peerOrgs {
+		for _, spec := range orgSpec.Specs {
+			err = writeEnv(envDir, filepath.Join(*emInputDir, "peerOrganizations", orgSpec.Domain, "peers"),
+				peerMountDir, spec, peerEnv(orgSpec, spec))
+			if err != nil {
+				fmt.Printf("Error writing environment of %s:\n%v\n", spec.CommonName, err)
+				os.Exit(1)
+			}
+		}
+	}
+	for _, orgSpec := range ordererOrgs {
+		for _, spec := range orgSpec.Specs {
+			err = writeEnv(envDir, filepath.Join(*emInputDir, "ordererOrganizations", orgSpec.Domain, "orderers"),
+				ordererMountDir, spec, ordererEnv(orgSpec))
+			if err != nil {
+				fmt.Printf("Error writing environment of %s:\n%v\n", spec.CommonName, err)
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// addOrgToProfile adds the org of orgSpec, whose artifacts are in
+// baseDir/orgsDir, along with its nodes and CA to profile
+func addOrgToProfile(profile *connectionProfile, baseDir, orgsDir string, orgSpec OrgSpec, peers bool) error {
+
+	orgDir := filepath.Join(baseDir, orgsDir, orgSpec.Domain)
+	tlsCACert, err := readTLSCACert(orgDir)
+	if err != nil {
+		return err
+	}
+
+	org := profileOrganization{
+		MSPID:                  mspID(orgSpec),
+		CertificateAuthorities: []string{orgSpec.CA.CommonName},
+	}
+	profile.CertificateAuthorities[orgSpec.CA.CommonName] = profileNode{
+		URL:        fmt.Sprintf("https://%s:%d", orgSpec.CA.CommonName, caPort),
+		CAName:     orgSpec.CA.CommonName,
+		TLSCACerts: profilePEM{PEM: tlsCACert},
+	}
+
+	// the SDKs act as the admin of the org
+	adminUser := adminNode(orgSpec)
+	adminMSPDir, err := filepath.Abs(filepath.Join(orgDir, "users", adminUser.CommonName, "msp"))
+	if err != nil {
+		return err
+	}
+	org.SignedCert = &profilePath{filepath.Join(adminMSPDir, "signcerts", adminUser.CommonName+"-cert.pem")}
+	if _, err := os.Stat(org.SignedCert.Path); err != nil {
+		return err
+	}
+	// keys kept in a PKCS#11 token have no file
+	keyFiles, err := filepath.Glob(filepath.Join(adminMSPDir, "keystore", "*_sk"))
+	if err != nil {
+		return err
+	}
+	if len(keyFiles) > 0 {
+		org.AdminPrivateKey = &profilePath{keyFiles[0]}
+	}
+
+	for _, spec := range orgSpec.Specs {
+		node := profileNode{
+			GRPCOptions: map[string]string{"ssl-target-name-override": spec.CommonName},
+			TLSCACerts:  profilePEM{PEM: tlsCACert},
+		}
+		if peers {
+			node.URL = fmt.Sprintf("grpcs://%s:%d", spec.CommonName, peerPort)
+			node.EventURL = fmt.Sprintf("grpcs://%s:%d", spec.CommonName, peerEventPort)
+			profile.Peers[spec.CommonName] = node
+			org.Peers = append(org.Peers, spec.CommonName)
+		} else {
+			node.URL = fmt.Sprintf("grpcs://%s:%d", spec.CommonName, ordererPort)
+			profile.Orderers[spec.CommonName] = node
+		}
+	}
+
+	profile.Organizations[orgSpec.Name] = org
+	return nil
+}
+
+// readTLSCACert returns the PEM encoded certificate of the TLS CA of the org
+// whose artifacts are in orgDir
+func readTLSCACert(orgDir string) (string, error) {
+
+	certFiles, err := filepath.Glob(filepath.Join(orgDir, "msp", "tlscacerts", "*"))
+	if err != nil {
+		return "", err
+	}
+	if len(certFiles) == 0 {
+		return "", fmt.Errorf("no TLS CA certificate found in %s", orgDir)
+	}
+	data, err := ioutil.ReadFile(certFiles[0])
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// writeProfile writes profile to path in both JSON and YAML
+func writeProfile(path string, profile connectionProfile) error {
+
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path+".json", append(data, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+
+	data, err = yaml.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".yaml", data, 0644)
+}
+
+// Directories of the containers of the nodes in which their MSP and TLS
+// folders are mounted in the emitted environments
+const (
+	peerMountDir    = "/etc/hyperledger/fabric"
+	ordererMountDir = "/var/hyperledger/orderer"
+)
+
+// envVar is a variable of the environment of a node
+type envVar struct {
+	Name  string
+	Value string
+}
+
+// writeEnv writes the environment env of the node of spec, whose artifacts
+// are in nodesDir, to envDir. The paths of env are those of a container in
+// which the MSP and TLS folders of the node are mounted at mountDir, as the
+// header of the file explains.
+func writeEnv(envDir, nodesDir, mountDir string, spec NodeSpec, env []envVar) error {
+
+	nodeDir := filepath.Join(nodesDir, spec.CommonName)
+	if _, err := os.Stat(nodeDir); err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# environment of %s, mount %s at %s\n",
+		spec.CommonName, nodeDir, mountDir)
+	for _, v := range env {
+		fmt.Fprintf(buf, "%s=%s\n", v.Name, v.Value)
+	}
+
+	return ioutil.WriteFile(filepath.Join(envDir, spec.CommonName+".env"), buf.Bytes(), 0644)
+}
+
+// peerEnv returns the environment of a peer whose artifacts are mounted at
+// peerMountDir
+func peerEnv(orgSpec OrgSpec, spec NodeSpec) []envVar {
+
+	dir := peerMountDir
+	address := fmt.Sprintf("%s:%d", spec.CommonName, peerPort)
+	return []envVar{
+		{"CORE_PEER_ID", spec.CommonName},
+		{"CORE_PEER_ADDRESS", address},
+		{"CORE_PEER_GOSSIP_EXTERNALENDPOINT", address},
+		{"CORE_PEER_LOCALMSPID", mspID(orgSpec)},
+		{"CORE_PEER_MSPCONFIGPATH", dir + "/msp"},
+		{"CORE_PEER_TLS_ENABLED", "true"},
+		{"CORE_PEER_TLS_CERT_FILE", dir + "/tls/server.crt"},
+		{"CORE_PEER_TLS_KEY_FILE", dir + "/tls/server.key"},
+		{"CORE_PEER_TLS_ROOTCERT_FILE", dir + "/tls/ca.crt"},
+	}
+}
+
+// ordererEnv returns the environment of an orderer whose artifacts are
+// mounted at ordererMountDir
+func ordererEnv(orgSpec OrgSpec) []envVar {
+
+	dir := ordererMountDir
+	return []envVar{
+		{"ORDERER_GENERAL_LISTENADDRESS", "0.0.0.0"},
+		{"ORDERER_GENERAL_LISTENPORT", fmt.Sprint(ordererPort)},
+		{"ORDERER_GENERAL_LOCALMSPID", mspID(orgSpec)},
+		{"ORDERER_GENERAL_LOCALMSPDIR", dir + "/msp"},
+		{"ORDERER_GENERAL_TLS_ENABLED", "true"},
+		{"ORDERER_GENERAL_TLS_PRIVATEKEY", dir + "/tls/server.key"},
+		{"ORDERER_GENERAL_TLS_CERTIFICATE", dir + "/tls/server.crt"},
+		{"ORDERER_GENERAL_TLS_ROOTCAS", "[" + dir + "/tls/ca.crt]"},
+	}
+}
+
+// mspID returns the MSP ID of the org of orgSpec, which is its name followed
+// by MSP by convention
+func mspID(orgSpec OrgSpec) string {
+	return orgSpec.Name + "MSP"
+}
+
+// caServerConfig is the subset of fabric-ca-server-config.yaml written by
+// export-ca
+type caServerConfig struct {
+	Port         int                 `yaml:"port"`
+	Debug        bool                `yaml:"debug"`
+	TLS          caServerTLS         `yaml:"tls"`
+	CA           caServerCA          `yaml:"ca"`
+	Registry     caServerRegistry    `yaml:"registry"`
+	DB           caServerDB          `yaml:"db"`
+	Affiliations map[string][]string `yaml:"affiliations"`
+	Signing      caServerSigning     `yaml:"signing"`
+}
+
+type caServerTLS struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+type caServerCA struct {
+	Name      string `yaml:"name"`
+	Keyfile   string `yaml:"keyfile"`
+	Certfile  string `yaml:"certfile"`
+	Chainfile string `yaml:"chainfile,omitempty"`
+}
+
+type caServerRegistry struct {
+	MaxEnrollments int                `yaml:"maxenrollments"`
+	Identities     []caServerIdentity `yaml:"identities"`
+}
+
+type caServerIdentity struct {
+	Name        string            `yaml:"name"`
+	Pass        string            `yaml:"pass"`
+	Type        string            `yaml:"type"`
+	Affiliation string            `yaml:"affiliation"`
+	Attrs       map[string]string `yaml:"attrs,omitempty"`
+}
+
+type caServerDB struct {
+	Type       string `yaml:"type"`
+	Datasource string `yaml:"datasource"`
+}
+
+type caServerSigning struct {
+	Default  caServerProfile            `yaml:"default"`
+	Profiles map[string]caServerProfile `yaml:"profiles"`
+}
+
+type caServerProfile struct {
+	Usage        []string              `yaml:"usage"`
+	Expiry       string                `yaml:"expiry"`
+	CAConstraint *caServerCAConstraint `yaml:"caconstraint,omitempty"`
+}
+
+type caServerCAConstraint struct {
+	IsCA       bool `yaml:"isca"`
+	MaxPathLen int  `yaml:"maxpathlen"`
+}
+
+func exportCA() {
+
+	config, err := getConfig()
+	if err != nil {
+		fmt.Printf("Error reading config: %s", err)
+		os.Exit(-1)
+	}
+	initBCCSP(bccspConfig(config))
+
+	for _, orgSpec := range config.PeerOrgs {
+		err = renderOrgSpec(&orgSpec, "peer")
+		if err != nil {
+			fmt.Printf("Error processing peer configuration: %s", err)
+			os.Exit(-1)
+		}
+		exportOrgCAs(filepath.Join(*expInputDir, "peerOrganizations", orgSpec.Domain), orgSpec)
+	}
+
+	for _, orgSpec := range config.OrdererOrgs {
+		err = renderOrgSpec(&orgSpec, "orderer")
+		if err != nil {
+			fmt.Printf("Error processing orderer configuration: %s", err)
+			os.Exit(-1)
+		}
+		exportOrgCAs(filepath.Join(*expInputDir, "ordererOrganizations", orgSpec.Domain), orgSpec)
+	}
+}
+
+// exportOrgCAs writes a fabric-ca-server home for the signing CA, for each
+// intermediate CA and for the TLS CA of the org saved in orgDir. The users of
+// the org found in orgDir are registered with every CA under the same secrets.
+func exportOrgCAs(orgDir string, orgSpec OrgSpec) {
+
+	orgName := orgSpec.Domain
+
+	fmt.Println(orgName)
+	users, err := readManifestNodes(orgDir, filepath.Join(orgDir, "users"))
+	if err != nil {
+		fmt.Printf("Error listing users of org %s:\n%v\n", orgName, err)
+		os.Exit(1)
+	}
+	identities, err := caServerIdentities(orgSpec, users)
+	if err != nil {
+		fmt.Printf("Error creating registered users for org %s:\n%v\n", orgName, err)
+		os.Exit(1)
+	}
+
+	rootCertFile := filepath.Join(orgDir, "ca", orgSpec.CA.CommonName+"-cert.pem")
+	type caHome struct{ homeDir, caDir, name, parentCertFile string }
+	homes := []caHome{
+		{"ca", filepath.Join(orgDir, "ca"), orgSpec.CA.CommonName, ""},
+		{"tlsca", filepath.Join(orgDir, "tlsca"), "tls" + orgSpec.CA.CommonName, ""},
+	}
+	// the intermediate CAs found in orgDir, which were all issued by the root
+	intermediateDirs, err := filepath.Glob(intermediateCADir(filepath.Join(orgDir, "ca"), "*"))
+	if err != nil {
+		fmt.Printf("Error listing intermediate CAs of org %s:\n%v\n", orgName, err)
+		os.Exit(1)
+	}
+	for _, dir := range intermediateDirs {
+		name := filepath.Base(dir)
+		homes = append(homes, caHome{filepath.Join("intermediates", name), dir, name, rootCertFile})
+	}
+
+	for _, home := range homes {
+		err = writeCAServerHome(filepath.Join(*expOutputDir, orgName, home.homeDir),
+			home.caDir, home.name, home.parentCertFile, orgSpec, identities)
+		if err != nil {
+			fmt.Printf("Error exporting CA %s of org %s:\n%v\n", home.name, orgName, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// writeCAServerHome writes to homeDir the certificate and the private key of
+// the CA called name saved in caDir, along with a fabric-ca-server
+// configuration registering identities. The certificate of an intermediate CA
+// is also written followed by the one of its parent in parentCertFile, as the
+// chain the server hands out.
+func writeCAServerHome(homeDir, caDir, name, parentCertFile string, orgSpec OrgSpec,
+	identities []caServerIdentity) error {
+
+	certFile := filepath.Join(caDir, name+"-cert.pem")
+	cert, err := ca.LoadCertificate(certFile)
+	if err != nil {
+		return err
+	}
+	// keys kept in a PKCS#11 token cannot be exported
+	keyFile, err := csp.PrivateKeyFile(caDir, cert.SubjectKeyId)
+	if err != nil {
+		return err
+	}
+
+	keystoreDir := filepath.Join(homeDir, "msp", "keystore")
+	err = os.MkdirAll(keystoreDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = copyFile(certFile, filepath.Join(homeDir, "ca-cert.pem"))
+	if err != nil {
+		return err
+	}
+	err = copyFile(keyFile, filepath.Join(keystoreDir, filepath.Base(keyFile)))
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(filepath.Join(keystoreDir, filepath.Base(keyFile)), 0600)
+	if err != nil {
+		return err
+	}
+	chainFile := ""
+	if parentCertFile != "" {
+		chainFile = "ca-chain.pem"
+		var chain []byte
+		for _, file := range []string{certFile, parentCertFile} {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			chain = append(chain, data...)
+		}
+		err = ioutil.WriteFile(filepath.Join(homeDir, chainFile), chain, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	config := caServerConfig{
+		Port: caPort,
+		CA: caServerCA{
+			Name:     name,
+			Keyfile:   filepath.Join("msp", "keystore", filepath.Base(keyFile)),
+			Certfile:  "ca-cert.pem",
+			Chainfile: chainFile,
+		},
+		Registry: caServerRegistry{
+			MaxEnrollments: -1,
+			Identities:     identities,
+		},
+		DB: caServerDB{
+			Type:       "sqlite3",
+			Datasource: "fabric-ca-server.db",
+		},
+		Affiliations: map[string][]string{affiliation(orgSpec): {}},
+		Signing: caServerSigning{
+			Default: caServerProfile{
+				Usage:  []string{"digital signature"},
+				Expiry: "8760h",
+			},
+			Profiles: map[string]caServerProfile{
+				"ca": {
+					Usage:        []string{"cert sign", "crl sign"},
+					Expiry:       "43800h",
+					CAConstraint: &caServerCAConstraint{IsCA: true},
+				},
+			},
+		},
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	// the configuration holds the secrets of the registered users
+	return ioutil.WriteFile(filepath.Join(homeDir, "fabric-ca-server-config.yaml"), data, 0600)
+}
+
+// caServerIdentities returns the identities registered with the CAs of the
+// org of orgSpec: a bootstrap registrar, the admins and the users of the org
+// which are among users, each with a random secret
+func caServerIdentities(orgSpec OrgSpec, users []manifestNode) ([]caServerIdentity, error) {
+
+	exists := map[string]bool{}
+	for _, user := range users {
+		exists[user.Name] = true
+	}
+
+	roles := "client,user,peer,validator,auditor"
+	identities := []caServerIdentity{{
+		Name: "admin",
+		Type: "client",
+		Attrs: map[string]string{
+			"hf.Registrar.Roles":         roles,
+			"hf.Registrar.DelegateRoles": roles,
+			"hf.Revoker":                 "true",
+			"hf.IntermediateCA":          "true",
+		},
+	}}
+
+	// the admins of the org may register and revoke its users, and everyone
+	// keeps the attributes of its certificate
+	for _, admin := range adminNodes(orgSpec) {
+		if !exists[admin.CommonName] {
+			continue
+		}
+		attrs := map[string]string{
+			"hf.Registrar.Roles": "client,user,peer",
+			"hf.Revoker":         "true",
+		}
+		for name, value := range admin.Attrs {
+			attrs[name] = value
+		}
+		identities = append(identities, caServerIdentity{
+			Name:        admin.CommonName,
+			Type:        "client",
+			Affiliation: affiliation(orgSpec),
+			Attrs:       attrs,
+		})
+	}
+	for _, user := range userNodes(orgSpec) {
+		if !exists[user.CommonName] {
+			continue
+		}
+		identities = append(identities, caServerIdentity{
+			Name:        user.CommonName,
+			Type:        "client",
+			Affiliation: affiliation(orgSpec),
+			Attrs:       user.Attrs,
+		})
+	}
+
+	for i := range identities {
+		secret := make([]byte, 16)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		identities[i].Pass = hex.EncodeToString(secret)
+	}
+
+	return identities, nil
+}
+
+// affiliation returns the affiliation of the users of the org of orgSpec in
+// its CAs
+func affiliation(orgSpec OrgSpec) string {
+	return strings.ToLower(orgSpec.Name)
+}
+
+// name of the manifest written at the root of the output, followed by .json
+// and .yaml
+const manifestFile = "manifest"
+
+// manifest lists the orgs, nodes and users found in an output directory.
+// Paths are relative to the directory.
+type manifest struct {
+	Orgs []manifestOrg `json:"orgs" yaml:"orgs"`
+}
+
+type manifestOrg struct {
+	Domain string         `json:"domain" yaml:"domain"`
+	Type   string         `json:"type" yaml:"type"`
+	MSPDir string         `json:"msp_dir" yaml:"msp_dir"`
+	CA     *manifestCert  `json:"ca" yaml:"ca"`
+	TLSCA  *manifestCert  `json:"tlsca" yaml:"tlsca"`
+	Nodes  []manifestNode `json:"nodes" yaml:"nodes"`
+	Users  []manifestNode `json:"users" yaml:"users"`
+}
+
+type manifestNode struct {
+	Name     string        `json:"name" yaml:"name"`
+	MSPDir   string        `json:"msp_dir" yaml:"msp_dir"`
+	SignCert *manifestCert `json:"signcert" yaml:"signcert"`
+	TLSCert  *manifestCert `json:"tls_cert" yaml:"tls_cert"`
+	TLSKey   string        `json:"tls_key" yaml:"tls_key"`
+}
+
+type manifestCert struct {
+	Path        string    `json:"path" yaml:"path"`
+	Fingerprint string    `json:"sha256_fingerprint" yaml:"sha256_fingerprint"`
+	SKI         string    `json:"ski" yaml:"ski"`
+	Expiry      time.Time `json:"expiry" yaml:"expiry"`
+}
+
+// writeManifest writes the manifest of the artifacts in baseDir, in both JSON
+// and YAML
+func writeManifest(baseDir string) {
+
+	m, err := readManifest(baseDir)
+	if err == nil {
+		var data []byte
+		data, err = json.MarshalIndent(m, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(baseDir, manifestFile+".json"), append(data, '\n'), 0644)
+		}
+	}
+	if err == nil {
+		var data []byte
+		data, err = yaml.Marshal(m)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(baseDir, manifestFile+".yaml"), data, 0644)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error writing manifest of %s:\n%v\n", baseDir, err)
+		os.Exit(1)
+	}
+}
+
+// readManifest lists the orgs found in baseDir along with their nodes and
+// users
+func readManifest(baseDir string) (*manifest, error) {
+
+	m := &manifest{Orgs: []manifestOrg{}}
+	orgTypes := []struct{ orgsDir, orgType, nodesDir string }{
+		{"peerOrganizations", "peer", "peers"},
+		{"ordererOrganizations", "orderer", "orderers"},
+	}
+	for _, orgType := range orgTypes {
+		orgDirs, err := filepath.Glob(filepath.Join(baseDir, orgType.orgsDir, "*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, orgDir := range orgDirs {
+			org := manifestOrg{
+				Domain: filepath.Base(orgDir),
+				Type:   orgType.orgType,
+			}
+			org.MSPDir, err = filepath.Rel(baseDir, filepath.Join(orgDir, "msp"))
+			if err != nil {
+				return nil, err
+			}
+			org.CA, err = readManifestCert(baseDir, filepath.Join(orgDir, "ca", "*-cert.pem"))
+			if err != nil {
+				return nil, err
+			}
+			org.TLSCA, err = readManifestCert(baseDir, filepath.Join(orgDir, "tlsca", "*-cert.pem"))
+			if err != nil {
+				return nil, err
+			}
+			org.Nodes, err = readManifestNodes(baseDir, filepath.Join(orgDir, orgType.nodesDir))
+			if err != nil {
+				return nil, err
+			}
+			org.Users, err = readManifestNodes(baseDir, filepath.Join(orgDir, "users"))
+			if err != nil {
+				return nil, err
+			}
+			m.Orgs = append(m.Orgs, org)
+		}
+	}
+
+	return m, nil
+}
+
+// readManifestNodes lists the nodes or users whose artifacts are in nodesDir
+func readManifestNodes(baseDir, nodesDir string) ([]manifestNode, error) {
+
+	nodeDirs, err := filepath.Glob(filepath.Join(nodesDir, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []manifestNode{}
+	for _, nodeDir := range nodeDirs {
+		node := manifestNode{Name: filepath.Base(nodeDir)}
+		node.MSPDir, err = filepath.Rel(baseDir, filepath.Join(nodeDir, "msp"))
+		if err != nil {
+			return nil, err
+		}
+		node.SignCert, err = readManifestCert(baseDir,
+			filepath.Join(nodeDir, "msp", "signcerts", node.Name+"-cert.pem"))
+		if err != nil {
+			return nil, err
+		}
+		node.TLSCert, err = readManifestCert(baseDir, filepath.Join(nodeDir, "tls", "server.crt"))
+		if err != nil {
+			return nil, err
+		}
+		node.TLSKey, err = filepath.Rel(baseDir, filepath.Join(nodeDir, "tls", "server.key"))
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// readManifestCert describes the only certificate matching pattern
+func readManifestCert(baseDir, pattern string) (*manifestCert, error) {
+
+	certFiles, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(certFiles) != 1 {
+		return nil, fmt.Errorf("expected exactly one certificate matching %s, found %d",
+			pattern, len(certFiles))
+	}
+
+	cert, err := ca.LoadCertificate(certFiles[0])
+	if err != nil {
+		return nil, err
+	}
+	ski, err := csp.SKI(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Rel(baseDir, certFiles[0])
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	return &manifestCert{
+		Path:        path,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		SKI:         hex.EncodeToString(ski),
+		Expiry:      cert.NotAfter.UTC(),
+	}, nil
+}
+
+// findIssuer returns the CA saved in caDir, or the one of its intermediate CAs,
+// which issued cert along with the directory in which it is saved
+func findIssuer(caDir string, cert *x509.Certificate) (*ca.CA, string, error) {
+
+	caName, err := findCAName(caDir)
+	if err != nil {
+		return nil, "", err
+	}
+	rootCA, err := ca.LoadCA(caDir, caName)
+	if err != nil {
+		return nil, "", err
+	}
+	if cert.CheckSignatureFrom(rootCA.SignCert) == nil {
+		return rootCA, caDir, nil
+	}
+
+	intermediateCADirs, err := filepath.Glob(intermediateCADir(caDir, "*"))
+	if err != nil {
+		return nil, "", err
+	}
+	for _, dir := range intermediateCADirs {
+		intermediateCA, err := rootCA.LoadIntermediateCA(dir, filepath.Base(dir))
+		if err != nil {
+			return nil, "", err
+		}
+		if cert.CheckSignatureFrom(intermediateCA.SignCert) == nil {
+			return intermediateCA, dir, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("certificate of %s was not issued by CA %s or its intermediate CAs",
+		cert.Subject.CommonName, caName)
+}
+
+// findOrgDir returns the directory of the peer or orderer org named orgName
+func findOrgDir(baseDir, orgName string) (string, error) {
+
+	for _, orgsDir := range []string{"peerOrganizations", "ordererOrganizations"} {
+		orgDir := filepath.Join(baseDir, orgsDir, orgName)
+		if _, err := os.Stat(orgDir); err == nil {
+			return orgDir, nil
+		}
+	}
+
+	return "", fmt.Errorf("no artifacts found for org %s in %s", orgName, baseDir)
+}
+
+// findCAName returns the name of the CA whose key pair is saved in caDir
+func findCAName(caDir string) (string, error) {
+
+	certFiles, err := filepath.Glob(filepath.Join(caDir, "*-cert.pem"))
+	if err != nil {
+		return "", err
+	}
+	if len(certFiles) != 1 {
+		return "", fmt.Errorf("expected exactly one CA certificate in %s, found %d",
+			caDir, len(certFiles))
+	}
+
+	return strings.TrimSuffix(filepath.Base(certFiles[0]), "-cert.pem"), nil
+}
+
+// intermediateCADir returns the directory of the intermediate CA called name
+// of the org whose signing CA is in caDir
+func intermediateCADir(caDir, name string) string {
+	return filepath.Join(caDir, "intermediates", name)
+}
+
+// findSignCert returns the path to the signing certificate of the node or
+// user called name
+func findSignCert(orgDir, name string) (string, error) {
+
+	for _, nodesDir := range []string{"peers", "orderers", "users"} {
+		certFile := filepath.Join(orgDir, nodesDir, name, "msp", "signcerts", name+"-cert.pem")
+		if _, err := os.Stat(certFile); err == nil {
+			return certFile, nil
+		}
+	}
+
+	return "", fmt.Errorf("no signing certificate found for %s in %s", name, orgDir)
+}
+
+func parseTemplate(input string, data interface{}) (string, error) {
+
+	t, err := template.New("parse").Parse(input)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing template: %s", err)
+	}
+
+	output := new(bytes.Buffer)
+	err = t.Execute(output, data)
+	if err != nil {
+		return "", fmt.Errorf("Error executing template: %s", err)
+	}
+
+	return output.String(), nil
+}
+
+func parseTemplateWithDefault(input, defaultInput string, data interface{}) (string, error) {
+
+	// Use the default if the input is an empty string
+	if len(input) == 0 {
+		input = defaultInput
+	}
+
+	return parseTemplate(input, data)
+}
+
+// inherit sets the fields of spec which were not specified to those of parent
+func (spec *CertSpec) inherit(parent CertSpec) {
+	if spec.Validity == 0 {
+		spec.Validity = parent.Validity
+	}
+	if len(spec.Country) == 0 {
+		spec.Country = parent.Country
+	}
+	if len(spec.Province) == 0 {
+		spec.Province = parent.Province
+	}
+	if len(spec.Locality) == 0 {
+		spec.Locality = parent.Locality
+	}
+	if len(spec.OrganizationalUnit) == 0 {
+		spec.OrganizationalUnit = parent.OrganizationalUnit
+	}
+	if len(spec.StreetAddress) == 0 {
+		spec.StreetAddress = parent.StreetAddress
+	}
+}
+
+// certOptions returns the options of the CA matching spec
+func (spec CertSpec) certOptions() ca.CertOptions {
+	return ca.CertOptions{
+		Country:            spec.Country,
+		Province:           spec.Province,
+		Locality:           spec.Locality,
+		OrganizationalUnit: spec.OrganizationalUnit,
+		StreetAddress:      spec.StreetAddress,
+		Validity:           spec.Validity,
+	}
+}
+
+func (spec NodeSpec) certOptions() ca.CertOptions {
+	opts := spec.CertSpec.certOptions()
+	opts.Attributes = spec.Attrs
+	return opts
+}
+
+func renderNodeSpec(domain, keyAlgorithm string, certSpec CertSpec, spec *NodeSpec) error {
+	data := SpecData{
+		Hostname: spec.Hostname,
+		Domain:   domain,
+	}
+
+	// Inherit the key algorithm if none was specified
+	if len(spec.KeyAlgorithm) == 0 {
+		spec.KeyAlgorithm = keyAlgorithm
+	}
+	err := csp.CheckKeyAlgorithm(spec.KeyAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	// Inherit the certificate settings which were not specified
+	spec.CertSpec.inherit(certSpec)
+	if spec.Validity < 0 {
+		return fmt.Errorf("invalid validity period %s for %s", spec.Validity, spec.Hostname)
+	}
+
+	// Process our CommonName
+	cn, err := parseTemplateWithDefault(spec.CommonName, defaultCNTemplate, data)
+	if err != nil {
+		return err
+	}
+
+	spec.CommonName = cn
+	data.CommonName = cn
+
+	// Save off our original, unprocessed SANS entries
+	origSANS := spec.SANS
+
+	// Set our implicit SANS entries for CN/Hostname
+	spec.SANS = []string{cn, spec.Hostname}
+
+	// Finally, process any remaining SANS entries
+	for _, _san := range origSANS {
+		san, err := parseTemplate(_san, data)
+		if err != nil {
+			return err
+		}
+
+		spec.SANS = append(spec.SANS, san)
+	}
+
+	return nil
+}
+
+func renderOrgSpec(orgSpec *OrgSpec, prefix string) error {
+	// First process all of our templated nodes
+	for i := 0; i < orgSpec.Template.Count; i++ {
+		data := HostnameData{
+			Prefix: prefix,
+			Index:  i + orgSpec.Template.Start,
+			Domain: orgSpec.Domain,
+		}
+
+		hostname, err := parseTemplateWithDefault(orgSpec.Template.Hostname, defaultHostnameTemplate, data)
+		if err != nil {
+			return err
+		}
+
+		spec := NodeSpec{
+			Hostname: hostname,
+			SANS:     orgSpec.Template.SANS,
+		}
+		orgSpec.Specs = append(orgSpec.Specs, spec)
+	}
+
+	// Nodes use the key algorithm of the org unless they override it
+	if len(orgSpec.KeyAlgorithm) == 0 {
+		orgSpec.KeyAlgorithm = csp.DefaultKeyAlgorithm
+	}
+	err := csp.CheckKeyAlgorithm(orgSpec.KeyAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	err = ca.CheckSerialPolicy(orgSpec.SerialPolicy)
+	if err != nil {
+		return err
+	}
+	if orgSpec.Validity < 0 {
+		return fmt.Errorf("invalid validity period %s for org %s", orgSpec.Validity, orgSpec.Name)
+	}
+
+	// Process the intermediate CA node-specs, which take the certificate
+	// settings of the CA rather than those of the org
+	intermediateCAs := map[string]string{}
+	for idx, spec := range orgSpec.IntermediateCAs {
+		if len(spec.Hostname) == 0 {
+			return fmt.Errorf("intermediate CA %d of org %s has no Hostname", idx, orgSpec.Name)
+		}
+		if _, exists := intermediateCAs[spec.Hostname]; exists {
+			return fmt.Errorf("duplicate intermediate CA %s in org %s", spec.Hostname, orgSpec.Name)
+		}
+
+		hostname := spec.Hostname
+		err := renderNodeSpec(orgSpec.Domain, orgSpec.KeyAlgorithm, orgSpec.CA.CertSpec, &spec)
+		if err != nil {
+			return err
+		}
+
+		intermediateCAs[hostname] = spec.CommonName
+		orgSpec.IntermediateCAs[idx] = spec
+	}
+
+	// Touch up all general node-specs to add the domain
+	for idx, spec := range orgSpec.Specs {
+		err := renderNodeSpec(orgSpec.Domain, orgSpec.KeyAlgorithm, orgSpec.CertSpec, &spec)
+		if err != nil {
+			return err
+		}
+
+		// Resolve the intermediate CA issuing the node to its CommonName
+		if len(spec.IntermediateCA) > 0 {
+			cn, exists := intermediateCAs[spec.IntermediateCA]
+			if !exists {
+				return fmt.Errorf("unknown intermediate CA %s for %s", spec.IntermediateCA, spec.Hostname)
+			}
+			spec.IntermediateCA = cn
+		} else {
+			spec.IntermediateCA = defaultIntermediateCA(*orgSpec)
+		}
+
+		orgSpec.Specs[idx] = spec
+	}
+
+	// Check the named users, which must not clash with the generated ones
+	users := map[string]bool{adminBaseName: true}
+	for j := 1; j <= orgSpec.Users.Count; j++ {
+		users[fmt.Sprintf("%s%d", userBaseName, j)] = true
+	}
+	for idx, spec := range orgSpec.Users.Specs {
+		if len(spec.Name) == 0 {
+			return fmt.Errorf("user %d of org %s has no Name", idx, orgSpec.Name)
+		}
+		if users[spec.Name] {
+			return fmt.Errorf("duplicate user %s in org %s", spec.Name, orgSpec.Name)
+		}
+		if spec.Validity < 0 {
+			return fmt.Errorf("invalid validity period %s for user %s", spec.Validity, spec.Name)
+		}
+		users[spec.Name] = true
+	}
+
+	// Process the CA node-spec in the same manner, except that the
+	// certificate settings of the org only apply to the nodes
+	if len(orgSpec.CA.Hostname) == 0 {
+		orgSpec.CA.Hostname = "ca"
+	}
+	err = renderNodeSpec(orgSpec.Domain, orgSpec.KeyAlgorithm, CertSpec{}, &orgSpec.CA)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func generatePeerOrg(baseDir string, orgSpec OrgSpec) {
+
+	orgName := orgSpec.Domain
+
+	fmt.Println(orgName)
+	// generate CAs
+	orgDir := filepath.Join(baseDir, "peerOrganizations", orgName)
 	caDir := filepath.Join(orgDir, "ca")
 	tlsCADir := filepath.Join(orgDir, "tlsca")
 	mspDir := filepath.Join(orgDir, "msp")
-	orderersDir := filepath.Join(orgDir, "orderers")
+	peersDir := filepath.Join(orgDir, "peers")
 	usersDir := filepath.Join(orgDir, "users")
 	adminCertsDir := filepath.Join(mspDir, "admincerts")
 	// generate signing CA
-	signCA, err := ca.NewCA(caDir, orgName, orgSpec.CA.CommonName)
+	signCA, err := ca.NewCA(caDir, orgName, orgSpec.CA.CommonName, orgSpec.CA.KeyAlgorithm,
+		orgSpec.SerialPolicy, orgSpec.CA.certOptions())
 	if err != nil {
 		fmt.Printf("Error generating signCA for org %s:\n%v\n", orgName, err)
 		os.Exit(1)
 	}
 	// generate TLS CA
-	tlsCA, err := ca.NewCA(tlsCADir, orgName, "tls"+orgSpec.CA.CommonName)
+	tlsCA, err := ca.NewCA(tlsCADir, orgName, "tls"+orgSpec.CA.CommonName, orgSpec.CA.KeyAlgorithm,
+		orgSpec.SerialPolicy, orgSpec.CA.certOptions())
 	if err != nil {
 		fmt.Printf("Error generating tlsCA for org %s:\n%v\n", orgName, err)
 		os.Exit(1)
 	}
 
-	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA)
+	// generate intermediate CAs
+	intermediateCAs := generateIntermediateCAs(caDir, orgSpec, signCA)
+
+	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, orgSpec.EnableNodeOUs)
+	if err == nil {
+		err = msp.ExportIntermediateCerts(mspDir, intermediateCAs...)
+	}
 	if err != nil {
 		fmt.Printf("Error generating MSP for org %s:\n%v\n", orgName, err)
 		os.Exit(1)
 	}
 
-	generateNodes(orderersDir, orgSpec.Specs, signCA, tlsCA)
+	generateNodes(peersDir, orgSpec.Specs, signCA, tlsCA, intermediateCAs, msp.PEER, orgSpec.EnableNodeOUs)
+
+	users := userNodes(orgSpec)
+	generateNodes(usersDir, users, signCA, tlsCA, intermediateCAs, msp.CLIENT, orgSpec.EnableNodeOUs)
+
+	// add the admin users
+	admins := adminNodes(orgSpec)
+	generateNodes(usersDir, admins, signCA, tlsCA, intermediateCAs, msp.ADMIN,
+		orgSpec.EnableNodeOUs)
+
+	// copy the admin certs to the org's MSP admincerts
+	err = copyAdminCert(usersDir, adminCertsDir, commonNames(admins)...)
+	if err != nil {
+		fmt.Printf("Error copying admin cert for org %s:\n%v\n",
+			orgName, err)
+		os.Exit(1)
+	}
+
+	// copy the admin certs to each of the org's peer's MSP admincerts
+	for _, spec := range orgSpec.Specs {
+		err = copyAdminCert(usersDir,
+			filepath.Join(peersDir, spec.CommonName, "msp", "admincerts"), commonNames(admins)...)
+		if err != nil {
+			fmt.Printf("Error copying admin cert for org %s peer %s:\n%v\n",
+				orgName, spec.CommonName, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func extendPeerOrg(baseDir string, orgSpec OrgSpec) {
+
+	orgName := orgSpec.Domain
+
+	orgDir := filepath.Join(baseDir, "peerOrganizations", orgName)
+	if _, err := os.Stat(orgDir); os.IsNotExist(err) {
+		// the whole org is new
+		generatePeerOrg(baseDir, orgSpec)
+		return
+	}
+
+	fmt.Println(orgName)
+	caDir := filepath.Join(orgDir, "ca")
+	tlsCADir := filepath.Join(orgDir, "tlsca")
+	mspDir := filepath.Join(orgDir, "msp")
+	peersDir := filepath.Join(orgDir, "peers")
+	usersDir := filepath.Join(orgDir, "users")
+
+	signCA, tlsCA := loadCAs(caDir, tlsCADir, orgSpec)
+	intermediateCAs := extendIntermediateCAs(orgDir, caDir, orgSpec, signCA)
+	adminUser := adminNode(orgSpec)
+	checkNodeExists(usersDir, adminUser)
+
+	peers := missingNodes(peersDir, orgSpec.Specs)
+	generateNodes(peersDir, peers, signCA, tlsCA, intermediateCAs, msp.PEER, orgSpec.EnableNodeOUs)
+
+	users := missingNodes(usersDir, userNodes(orgSpec))
+	generateNodes(usersDir, users, signCA, tlsCA, intermediateCAs, msp.CLIENT, orgSpec.EnableNodeOUs)
+
+	admins := adminNodes(orgSpec)
+	newAdmins := missingNodes(usersDir, admins)
+	generateNodes(usersDir, newAdmins, signCA, tlsCA, intermediateCAs, msp.ADMIN, orgSpec.EnableNodeOUs)
+
+	// new nodes need the CRLs of any revocation done so far
+	copyCRLs(mspDir, peersDir, peers)
+	copyCRLs(mspDir, usersDir, users)
+	copyCRLs(mspDir, usersDir, newAdmins)
+
+	// copy the admin certs to each of the org's new peer's MSP admincerts,
+	// or to the org's MSP and every peer if there are new admins
+	adminPeers := peers
+	if len(newAdmins) > 0 {
+		adminPeers = orgSpec.Specs
+		err := copyAdminCert(usersDir, filepath.Join(mspDir, "admincerts"), commonNames(admins)...)
+		if err != nil {
+			fmt.Printf("Error copying admin cert for org %s:\n%v\n", orgName, err)
+			os.Exit(1)
+		}
+	}
+	for _, spec := range adminPeers {
+		err := copyAdminCert(usersDir,
+			filepath.Join(peersDir, spec.CommonName, "msp", "admincerts"), commonNames(admins)...)
+		if err != nil {
+			fmt.Printf("Error copying admin cert for org %s peer %s:\n%v\n",
+				orgName, spec.CommonName, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func extendOrdererOrg(baseDir string, orgSpec OrgSpec) {
+
+	orgName := orgSpec.Domain
+
+	orgDir := filepath.Join(baseDir, "ordererOrganizations", orgName)
+	if _, err := os.Stat(orgDir); os.IsNotExist(err) {
+		// the whole org is new
+		generateOrdererOrg(baseDir, orgSpec)
+		return
+	}
+
+	caDir := filepath.Join(orgDir, "ca")
+	tlsCADir := filepath.Join(orgDir, "tlsca")
+	mspDir := filepath.Join(orgDir, "msp")
+	orderersDir := filepath.Join(orgDir, "orderers")
+	usersDir := filepath.Join(orgDir, "users")
+
+	signCA, tlsCA := loadCAs(caDir, tlsCADir, orgSpec)
+	intermediateCAs := extendIntermediateCAs(orgDir, caDir, orgSpec, signCA)
+	adminUser := adminNode(orgSpec)
+	checkNodeExists(usersDir, adminUser)
+
+	orderers := missingNodes(orderersDir, orgSpec.Specs)
+	generateNodes(orderersDir, orderers, signCA, tlsCA, intermediateCAs, msp.ORDERER, orgSpec.EnableNodeOUs)
+
+	users := missingNodes(usersDir, userNodes(orgSpec))
+	generateNodes(usersDir, users, signCA, tlsCA, intermediateCAs, msp.CLIENT, orgSpec.EnableNodeOUs)
+
+	admins := adminNodes(orgSpec)
+	newAdmins := missingNodes(usersDir, admins)
+	generateNodes(usersDir, newAdmins, signCA, tlsCA, intermediateCAs, msp.ADMIN, orgSpec.EnableNodeOUs)
+
+	// new nodes need the CRLs of any revocation done so far
+	copyCRLs(mspDir, orderersDir, orderers)
+	copyCRLs(mspDir, usersDir, users)
+	copyCRLs(mspDir, usersDir, newAdmins)
+
+	// copy the admin certs to each of the org's new orderer's MSP admincerts,
+	// or to the org's MSP and every orderer if there are new admins
+	adminOrderers := orderers
+	if len(newAdmins) > 0 {
+		adminOrderers = orgSpec.Specs
+		err := copyAdminCert(usersDir, filepath.Join(mspDir, "admincerts"), commonNames(admins)...)
+		if err != nil {
+			fmt.Printf("Error copying admin cert for org %s:\n%v\n", orgName, err)
+			os.Exit(1)
+		}
+	}
+	for _, spec := range adminOrderers {
+		err := copyAdminCert(usersDir,
+			filepath.Join(orderersDir, spec.CommonName, "msp", "admincerts"), commonNames(admins)...)
+		if err != nil {
+			fmt.Printf("Error copying admin cert for org %s orderer %s:\n%v\n",
+				orgName, spec.CommonName, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// loadCAs loads the signing and TLS CAs previously generated for orgSpec
+func loadCAs(caDir, tlsCADir string, orgSpec OrgSpec) (*ca.CA, *ca.CA) {
+
+	orgName := orgSpec.Domain
+
+	signCA, err := ca.LoadCA(caDir, orgSpec.CA.CommonName)
+	if err != nil {
+		fmt.Printf("Error loading signCA for org %s:\n%v\n", orgName, err)
+		os.Exit(1)
+	}
+	tlsCA, err := ca.LoadCA(tlsCADir, "tls"+orgSpec.CA.CommonName)
+	if err != nil {
+		fmt.Printf("Error loading tlsCA for org %s:\n%v\n", orgName, err)
+		os.Exit(1)
+	}
+
+	return signCA, tlsCA
+}
+
+// generateIntermediateCAs creates the intermediate CAs of orgSpec, issued by
+// signCA, in the order in which they are specified
+func generateIntermediateCAs(caDir string, orgSpec OrgSpec, signCA *ca.CA) []*ca.CA {
+
+	intermediateCAs := []*ca.CA{}
+	for _, spec := range orgSpec.IntermediateCAs {
+		intermediateCA, err := signCA.NewIntermediateCA(intermediateCADir(caDir, spec.CommonName),
+			orgSpec.Domain, spec.CommonName, spec.KeyAlgorithm, orgSpec.SerialPolicy, spec.certOptions())
+		if err != nil {
+			fmt.Printf("Error generating intermediate CA %s for org %s:\n%v\n",
+				spec.CommonName, orgSpec.Domain, err)
+			os.Exit(1)
+		}
+		intermediateCAs = append(intermediateCAs, intermediateCA)
+	}
+
+	return intermediateCAs
+}
+
+// extendIntermediateCAs loads the intermediate CAs of orgSpec previously
+// generated in caDir and creates the missing ones. Since nodes must be able to
+// validate the identities issued by any intermediate CA of the org, the
+// certificates of the created intermediate CAs are added to every MSP in
+// orgDir. The MSPs are left untouched if no intermediate CA was created.
+func extendIntermediateCAs(orgDir, caDir string, orgSpec OrgSpec, signCA *ca.CA) []*ca.CA {
+
+	orgName := orgSpec.Domain
+
+	intermediateCAs := []*ca.CA{}
+	added := []*ca.CA{}
+	for _, spec := range orgSpec.IntermediateCAs {
+		dir := intermediateCADir(caDir, spec.CommonName)
+		var intermediateCA *ca.CA
+		var err error
+		_, err = os.Stat(dir)
+		created := os.IsNotExist(err)
+		if created {
+			intermediateCA, err = signCA.NewIntermediateCA(dir, orgName, spec.CommonName,
+				spec.KeyAlgorithm, orgSpec.SerialPolicy, spec.certOptions())
+		} else {
+			intermediateCA, err = signCA.LoadIntermediateCA(dir, spec.CommonName)
+		}
+		if err != nil {
+			fmt.Printf("Error generating intermediate CA %s for org %s:\n%v\n",
+				spec.CommonName, orgName, err)
+			os.Exit(1)
+		}
+		intermediateCAs = append(intermediateCAs, intermediateCA)
+		if created {
+			added = append(added, intermediateCA)
+		}
+	}
+	if len(added) == 0 {
+		return intermediateCAs
+	}
+
+	mspDirs, err := filepath.Glob(filepath.Join(orgDir, "*", "*", "msp"))
+	if err != nil {
+		fmt.Printf("Error listing MSPs for org %s:\n%v\n", orgName, err)
+		os.Exit(1)
+	}
+	mspDirs = append(mspDirs, filepath.Join(orgDir, "msp"))
+
+	for _, mspDir := range mspDirs {
+		err = msp.ExportIntermediateCerts(mspDir, added...)
+		if err != nil {
+			fmt.Printf("Error writing intermediate certs to %s:\n%v\n", mspDir, err)
+			os.Exit(1)
+		}
+	}
+
+	return intermediateCAs
+}
+
+// issuingCA returns the CA issuing the signing certificate of node
+func issuingCA(node NodeSpec, signCA *ca.CA, intermediateCAs []*ca.CA) *ca.CA {
+
+	for _, intermediateCA := range intermediateCAs {
+		if intermediateCA.Name == node.IntermediateCA {
+			return intermediateCA
+		}
+	}
+
+	return signCA
+}
+
+// missingNodes returns the nodes which have no artifacts in baseDir yet
+func missingNodes(baseDir string, nodes []NodeSpec) []NodeSpec {
+
+	missing := []NodeSpec{}
+	for _, node := range nodes {
+		_, err := os.Stat(filepath.Join(baseDir, node.CommonName))
+		if os.IsNotExist(err) {
+			missing = append(missing, node)
+		}
+	}
+
+	return missing
+}
+
+// checkNodeExists exits if node has no artifacts in baseDir
+func checkNodeExists(baseDir string, node NodeSpec) {
+
+	_, err := os.Stat(filepath.Join(baseDir, node.CommonName))
+	if err != nil {
+		fmt.Printf("Error finding artifacts for %s:\n%v\n", node.CommonName, err)
+		os.Exit(1)
+	}
+}
+
+// defaultIntermediateCA returns the CommonName of the intermediate CA issuing
+// the nodes and users of the org which do not choose one, if there is any
+func defaultIntermediateCA(orgSpec OrgSpec) string {
+	if len(orgSpec.IntermediateCAs) == 0 {
+		return ""
+	}
+	return orgSpec.IntermediateCAs[0].CommonName
+}
+
+// userNodes returns the users of the org which are not admins: the counted
+// ones followed by the named ones
+func userNodes(orgSpec OrgSpec) []NodeSpec {
+
+	users := []NodeSpec{}
+	for j := 1; j <= orgSpec.Users.Count; j++ {
+		user := NodeSpec{
+			CommonName:     fmt.Sprintf("%s%d@%s", userBaseName, j, orgSpec.Domain),
+			KeyAlgorithm:   orgSpec.KeyAlgorithm,
+			IntermediateCA: defaultIntermediateCA(orgSpec),
+			CertSpec:       orgSpec.CertSpec,
+		}
 
-	adminUser := NodeSpec{
-		CommonName: fmt.Sprintf("%s@%s", adminBaseName, orgName),
+		users = append(users, user)
 	}
 
-	// generate an admin for the orderer org
+	return append(users, namedUserNodes(orgSpec, false)...)
+}
+
+// adminNodes returns the admins of the org: Admin followed by the named
+// users flagged as admins
+func adminNodes(orgSpec OrgSpec) []NodeSpec {
+	return append([]NodeSpec{adminNode(orgSpec)}, namedUserNodes(orgSpec, true)...)
+}
+
+// namedUserNodes returns the users of Users.Specs which are admins, or which
+// are not
+func namedUserNodes(orgSpec OrgSpec, admin bool) []NodeSpec {
+
 	users := []NodeSpec{}
-	// add an admin user
-	users = append(users, adminUser)
-	generateNodes(usersDir, users, signCA, tlsCA)
+	for _, spec := range orgSpec.Users.Specs {
+		if spec.Admin != admin {
+			continue
+		}
+		user := NodeSpec{
+			CommonName:     fmt.Sprintf("%s@%s", spec.Name, orgSpec.Domain),
+			KeyAlgorithm:   orgSpec.KeyAlgorithm,
+			IntermediateCA: defaultIntermediateCA(orgSpec),
+			CertSpec:       spec.CertSpec,
+			Attrs:          spec.Attrs,
+		}
+		user.CertSpec.inherit(orgSpec.CertSpec)
+
+		users = append(users, user)
+	}
+
+	return users
+}
+
+// commonNames returns the common names of nodes
+func commonNames(nodes []NodeSpec) []string {
+
+	names := []string{}
+	for _, node := range nodes {
+		names = append(names, node.CommonName)
+	}
+	return names
+}
+
+func adminNode(orgSpec OrgSpec) NodeSpec {
+	return NodeSpec{
+		CommonName:     fmt.Sprintf("%s@%s", adminBaseName, orgSpec.Domain),
+		KeyAlgorithm:   orgSpec.KeyAlgorithm,
+		IntermediateCA: defaultIntermediateCA(orgSpec),
+		CertSpec:       orgSpec.CertSpec,
+	}
+}
+
+// copyCRLs copies the CRLs of the org's MSP in orgMSPDir to the local MSP of
+// each of nodes
+func copyCRLs(orgMSPDir, baseDir string, nodes []NodeSpec) {
+
+	crlFiles, err := filepath.Glob(filepath.Join(orgMSPDir, "crls", "*"))
+	if err != nil || len(crlFiles) == 0 {
+		return
+	}
+
+	for _, node := range nodes {
+		crlsDir := filepath.Join(baseDir, node.CommonName, "msp", "crls")
+		err = os.MkdirAll(crlsDir, 0755)
+		for _, crlFile := range crlFiles {
+			if err == nil {
+				err = copyFile(crlFile, filepath.Join(crlsDir, filepath.Base(crlFile)))
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error copying CRLs for %s:\n%v\n", node.CommonName, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func copyAdminCert(usersDir, adminCertsDir string, adminUserNames ...string) error {
+	// delete the contents of admincerts
+	err := os.RemoveAll(adminCertsDir)
+	if err != nil {
+		return err
+	}
+	// recreate the admincerts directory
+	err = os.MkdirAll(adminCertsDir, 0755)
+	if err != nil {
+		return err
+	}
+	for _, adminUserName := range adminUserNames {
+		err = copyFile(filepath.Join(usersDir, adminUserName, "msp", "signcerts",
+			adminUserName+"-cert.pem"), filepath.Join(adminCertsDir,
+			adminUserName+"-cert.pem"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+
+}
+
+func generateNodes(baseDir string, nodes []NodeSpec, signCA *ca.CA, tlsCA *ca.CA,
+	intermediateCAs []*ca.CA, nodeType int, nodeOUs bool) {
+
+	for _, node := range nodes {
+		nodeDir := filepath.Join(baseDir, node.CommonName)
+		err := msp.GenerateLocalMSP(nodeDir, node.CommonName, node.SANS,
+			issuingCA(node, signCA, intermediateCAs), tlsCA, nodeType, nodeOUs,
+			node.KeyAlgorithm, node.certOptions())
+		if err == nil {
+			// nodes must be able to validate the identities issued by any
+			// intermediate CA of the org
+			err = msp.ExportIntermediateCerts(filepath.Join(nodeDir, "msp"), intermediateCAs...)
+		}
+		if err != nil {
+			fmt.Printf("Error generating local MSP for %s:\n%v\n", node, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func generateOrdererOrg(baseDir string, orgSpec OrgSpec) {
+
+	orgName := orgSpec.Domain
+
+	// generate CAs
+	orgDir := filepath.Join(baseDir, "ordererOrganizations", orgName)
+	caDir := filepath.Join(orgDir, "ca")
+	tlsCADir := filepath.Join(orgDir, "tlsca")
+	mspDir := filepath.Join(orgDir, "msp")
+	orderersDir := filepath.Join(orgDir, "orderers")
+	usersDir := filepath.Join(orgDir, "users")
+	adminCertsDir := filepath.Join(mspDir, "admincerts")
+	// generate signing CA
+	signCA, err := ca.NewCA(caDir, orgName, orgSpec.CA.CommonName, orgSpec.CA.KeyAlgorithm,
+		orgSpec.SerialPolicy, orgSpec.CA.certOptions())
+	if err != nil {
+		fmt.Printf("Error generating signCA for org %s:\n%v\n", orgName, err)
+		os.Exit(1)
+	}
+	// generate TLS CA
+	tlsCA, err := ca.NewCA(tlsCADir, orgName, "tls"+orgSpec.CA.CommonName, orgSpec.CA.KeyAlgorithm,
+		orgSpec.SerialPolicy, orgSpec.CA.certOptions())
+	if err != nil {
+		fmt.Printf("Error generating tlsCA for org %s:\n%v\n", orgName, err)
+		os.Exit(1)
+	}
+
+	// generate intermediate CAs
+	intermediateCAs := generateIntermediateCAs(caDir, orgSpec, signCA)
+
+	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, orgSpec.EnableNodeOUs)
+	if err == nil {
+		err = msp.ExportIntermediateCerts(mspDir, intermediateCAs...)
+	}
+	if err != nil {
+		fmt.Printf("Error generating MSP for org %s:\n%v\n", orgName, err)
+		os.Exit(1)
+	}
+
+	generateNodes(orderersDir, orgSpec.Specs, signCA, tlsCA, intermediateCAs, msp.ORDERER,
+		orgSpec.EnableNodeOUs)
+
+	users := userNodes(orgSpec)
+	generateNodes(usersDir, users, signCA, tlsCA, intermediateCAs, msp.CLIENT, orgSpec.EnableNodeOUs)
+
+	// add the admin users
+	admins := adminNodes(orgSpec)
+	generateNodes(usersDir, admins, signCA, tlsCA, intermediateCAs, msp.ADMIN,
+		orgSpec.EnableNodeOUs)
 
-	// copy the admin cert to the org's MSP admincerts
-	err = copyAdminCert(usersDir, adminCertsDir, adminUser.CommonName)
+	// copy the admin certs to the org's MSP admincerts
+	err = copyAdminCert(usersDir, adminCertsDir, commonNames(admins)...)
 	if err != nil {
 		fmt.Printf("Error copying admin cert for org %s:\n%v\n",
 			orgName, err)
 		os.Exit(1)
 	}
 
-	// copy the admin cert to each of the org's orderers's MSP admincerts
+	// copy the admin certs to each of the org's orderers's MSP admincerts
 	for _, spec := range orgSpec.Specs {
 		err = copyAdminCert(usersDir,
-			filepath.Join(orderersDir, spec.CommonName, "msp", "admincerts"), adminUser.CommonName)
+			filepath.Join(orderersDir, spec.CommonName, "msp", "admincerts"), commonNames(admins)...)
 		if err != nil {
 			fmt.Printf("Error copying admin cert for org %s orderer %s:\n%v\n",
 				orgName, spec.CommonName, err)
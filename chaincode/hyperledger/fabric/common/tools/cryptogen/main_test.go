@@ -0,0 +1,697 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/tools/cryptogen/ca"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const testConfig = `
+OrdererOrgs:
+  - Name: Orderer
+    Domain: example.com
+    IntermediateCAs:
+      - Hostname: ica
+    Specs:
+      - Hostname: orderer
+PeerOrgs:
+  - Name: Org1
+    Domain: org1.example.com
+    IntermediateCAs:
+      - Hostname: ica1
+    Template:
+      Count: 1
+    Users:
+      Count: 1
+`
+
+// testExtendedConfig adds an intermediate CA, a peer and a user to Org1, an
+// orderer to Orderer and a whole org to testConfig
+const testExtendedConfig = `
+OrdererOrgs:
+  - Name: Orderer
+    Domain: example.com
+    IntermediateCAs:
+      - Hostname: ica
+    Specs:
+      - Hostname: orderer
+      - Hostname: orderer2
+PeerOrgs:
+  - Name: Org1
+    Domain: org1.example.com
+    IntermediateCAs:
+      - Hostname: ica1
+      - Hostname: ica2
+    Template:
+      Count: 2
+    Users:
+      Count: 2
+  - Name: Org2
+    Domain: org2.example.com
+    Template:
+      Count: 1
+`
+
+// useConfig points the config flag to a file holding config, and returns a
+// function resetting the flag
+func useConfig(t *testing.T, flag **os.File, config string) func() {
+	file, err := ioutil.TempFile("", "crypto-config")
+	assert.NoError(t, err)
+	_, err = file.WriteString(config)
+	assert.NoError(t, err)
+	_, err = file.Seek(0, 0)
+	assert.NoError(t, err)
+
+	*flag = file
+	return func() {
+		*flag = nil
+		file.Close()
+		os.Remove(file.Name())
+	}
+}
+
+// generateTree generates the artifacts of config in a new directory
+func generateTree(t *testing.T, config string) string {
+	dir, err := ioutil.TempDir("", "cryptogen")
+	assert.NoError(t, err)
+
+	reset := useConfig(t, configFile, config)
+	defer reset()
+	*outputDir = dir
+	generate()
+
+	return dir
+}
+
+// fileState is what must not change about a file which is left untouched
+type fileState struct {
+	hash    string
+	modTime time.Time
+}
+
+// snapshotTree returns the state of every file in dir by its relative path,
+// except the manifests, which list every artifact
+func snapshotTree(t *testing.T, dir string) map[string]fileState {
+	files := map[string]fileState{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(rel, manifestFile+".") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		files[rel] = fileState{hex.EncodeToString(hash[:]), info.ModTime()}
+		return nil
+	})
+	assert.NoError(t, err)
+	return files
+}
+
+func TestExtend(t *testing.T) {
+	dir := generateTree(t, testConfig)
+	defer os.RemoveAll(dir)
+	before := snapshotTree(t, dir)
+
+	reset := useConfig(t, extConfigFile, testExtendedConfig)
+	defer reset()
+	*inputDir = dir
+	extend()
+
+	after := snapshotTree(t, dir)
+	for path, state := range before {
+		assert.Equal(t, state, after[path], "%s was modified", path)
+	}
+
+	for _, path := range []string{
+		"peerOrganizations/org1.example.com/peers/peer1.org1.example.com/msp/signcerts/peer1.org1.example.com-cert.pem",
+		"peerOrganizations/org1.example.com/peers/peer1.org1.example.com/msp/admincerts/Admin@org1.example.com-cert.pem",
+		"peerOrganizations/org1.example.com/users/User2@org1.example.com/msp/signcerts/User2@org1.example.com-cert.pem",
+		// the existing MSPs trust the new intermediate CA
+		"peerOrganizations/org1.example.com/msp/intermediatecerts/ica2.org1.example.com-cert.pem",
+		"peerOrganizations/org1.example.com/peers/peer0.org1.example.com/msp/intermediatecerts/ica2.org1.example.com-cert.pem",
+		"peerOrganizations/org1.example.com/users/Admin@org1.example.com/msp/intermediatecerts/ica2.org1.example.com-cert.pem",
+		"ordererOrganizations/example.com/orderers/orderer2.example.com/tls/server.crt",
+		"peerOrganizations/org2.example.com/peers/peer0.org2.example.com/msp/signcerts/peer0.org2.example.com-cert.pem",
+		"peerOrganizations/org2.example.com/users/Admin@org2.example.com/msp/signcerts/Admin@org2.example.com-cert.pem",
+	} {
+		_, exists := after[filepath.FromSlash(path)]
+		assert.True(t, exists, "%s is missing", path)
+	}
+
+	// the new peer trusts the CA of the existing ones
+	assert.Equal(t,
+		after[filepath.FromSlash("peerOrganizations/org1.example.com/peers/peer0.org1.example.com/msp/cacerts/ca.org1.example.com-cert.pem")].hash,
+		after[filepath.FromSlash("peerOrganizations/org1.example.com/peers/peer1.org1.example.com/msp/cacerts/ca.org1.example.com-cert.pem")].hash)
+}
+
+// testOrdererUsersConfig has an orderer org with users besides Admin
+const testOrdererUsersConfig = `
+OrdererOrgs:
+  - Name: Orderer
+    Domain: example.com
+    Specs:
+      - Hostname: orderer
+    Users:
+      Count: 1
+      Specs:
+        - Name: bob
+          Admin: true
+`
+
+func TestOrdererOrgUsers(t *testing.T) {
+	dir := generateTree(t, testOrdererUsersConfig)
+	defer os.RemoveAll(dir)
+	orgDir := filepath.Join(dir, "ordererOrganizations", "example.com")
+
+	files := snapshotTree(t, dir)
+	for _, path := range []string{
+		"users/User1@example.com/msp/signcerts/User1@example.com-cert.pem",
+		"users/bob@example.com/msp/signcerts/bob@example.com-cert.pem",
+		"msp/admincerts/Admin@example.com-cert.pem",
+		"msp/admincerts/bob@example.com-cert.pem",
+		"orderers/orderer.example.com/msp/admincerts/bob@example.com-cert.pem",
+	} {
+		_, exists := files[filepath.Join("ordererOrganizations", "example.com", filepath.FromSlash(path))]
+		assert.True(t, exists, "%s is missing", path)
+	}
+	_, err := os.Stat(filepath.Join(orgDir, "msp", "admincerts", "User1@example.com-cert.pem"))
+	assert.True(t, os.IsNotExist(err), "User1 should not be an admin")
+
+	// extending with another admin makes it an admin of the existing orderers
+	reset := useConfig(t, extConfigFile, strings.Replace(testOrdererUsersConfig, `
+          Admin: true
+`, `
+          Admin: true
+        - Name: carol
+          Admin: true
+`, 1))
+	defer reset()
+	*inputDir = dir
+	extend()
+
+	for _, path := range []string{
+		"users/carol@example.com/msp/signcerts/carol@example.com-cert.pem",
+		"msp/admincerts/bob@example.com-cert.pem",
+		"msp/admincerts/carol@example.com-cert.pem",
+		"orderers/orderer.example.com/msp/admincerts/carol@example.com-cert.pem",
+	} {
+		_, err := os.Stat(filepath.Join(orgDir, filepath.FromSlash(path)))
+		assert.NoError(t, err, "%s is missing", path)
+	}
+}
+
+// testRenewConfig has a peer and an admin user whose certificates expire
+// within the default renewal window, next to a peer and the Admin whose
+// certificates do not
+const testRenewConfig = `
+PeerOrgs:
+  - Name: Org1
+    Domain: org1.example.com
+    SerialPolicy: sequential
+    Specs:
+      - Hostname: peer0
+        Validity: 240h
+      - Hostname: peer1
+    Users:
+      Specs:
+        - Name: alice
+          Admin: true
+          Validity: 240h
+`
+
+func TestRenew(t *testing.T) {
+	dir := generateTree(t, testRenewConfig)
+	defer os.RemoveAll(dir)
+	orgDir := filepath.Join(dir, "peerOrganizations", "org1.example.com")
+
+	peerCert := filepath.Join(orgDir, "peers", "peer0.org1.example.com", "msp", "signcerts", "peer0.org1.example.com-cert.pem")
+	peerTLSCert := filepath.Join(orgDir, "peers", "peer0.org1.example.com", "tls", "server.crt")
+	aliceCert := filepath.Join(orgDir, "users", "alice@org1.example.com", "msp", "signcerts", "alice@org1.example.com-cert.pem")
+	aliceTLSCert := filepath.Join(orgDir, "users", "alice@org1.example.com", "tls", "server.crt")
+	renewed := map[string]*x509.Certificate{}
+	for _, certFile := range []string{peerCert, peerTLSCert, aliceCert, aliceTLSCert} {
+		cert, err := ca.LoadCertificate(certFile)
+		assert.NoError(t, err)
+		renewed[certFile] = cert
+	}
+	// the next serial numbers of the CAs, which number certificates
+	// sequentially
+	nextSerial := func(caDir string) *big.Int {
+		data, err := ioutil.ReadFile(filepath.Join(orgDir, caDir, "serial"))
+		assert.NoError(t, err)
+		serial, ok := new(big.Int).SetString(strings.TrimSpace(string(data)), 16)
+		assert.True(t, ok)
+		return serial
+	}
+	signSerial, tlsSerial := nextSerial("ca"), nextSerial("tlsca")
+	before := snapshotTree(t, dir)
+
+	*renInputDir = dir
+	*renewWindow = 720 * time.Hour
+	*renewValidity = 480 * time.Hour
+	defer func() { *renewValidity = 0 }()
+	renew()
+
+	after := snapshotTree(t, dir)
+	changed := map[string]bool{}
+	for path, state := range before {
+		if after[path] != state {
+			changed[path] = true
+		}
+	}
+
+	for certFile, oldCert := range renewed {
+		cert, err := ca.LoadCertificate(certFile)
+		assert.NoError(t, err)
+		assert.Equal(t, oldCert.RawSubject, cert.RawSubject)
+		assert.Equal(t, oldCert.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo,
+			"%s was renewed with another key", certFile)
+		assert.Equal(t, 480*time.Hour, cert.NotAfter.Sub(cert.NotBefore), "%s was not renewed", certFile)
+		assert.True(t, cert.SerialNumber.Cmp(oldCert.SerialNumber) > 0)
+		rel, err := filepath.Rel(dir, certFile)
+		assert.NoError(t, err)
+		delete(changed, rel)
+	}
+	// each CA issued the renewed certificates with its next serial numbers
+	assert.Equal(t, 0, new(big.Int).Sub(nextSerial("ca"), signSerial).Cmp(big.NewInt(2)))
+	assert.Equal(t, 0, new(big.Int).Sub(nextSerial("tlsca"), tlsSerial).Cmp(big.NewInt(2)))
+	delete(changed, filepath.Join("peerOrganizations", "org1.example.com", "ca", "serial"))
+	delete(changed, filepath.Join("peerOrganizations", "org1.example.com", "tlsca", "serial"))
+
+	// alice is an admin, so the copies of her certificate are replaced
+	aliceData, err := ioutil.ReadFile(aliceCert)
+	assert.NoError(t, err)
+	adminCerts, err := filepath.Glob(filepath.Join(orgDir, "*", "*", "msp", "admincerts", "alice@org1.example.com-cert.pem"))
+	assert.NoError(t, err)
+	orgAdminCerts, err := filepath.Glob(filepath.Join(orgDir, "msp", "admincerts", "alice@org1.example.com-cert.pem"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, orgAdminCerts)
+	for _, adminCert := range append(orgAdminCerts, adminCerts...) {
+		data, err := ioutil.ReadFile(adminCert)
+		assert.NoError(t, err)
+		assert.Equal(t, aliceData, data, "%s was not updated", adminCert)
+		rel, err := filepath.Rel(dir, adminCert)
+		assert.NoError(t, err)
+		delete(changed, rel)
+	}
+
+	// the other nodes are left untouched
+	assert.Empty(t, changed)
+}
+
+func TestBCCSPConfig(t *testing.T) {
+	reset := useConfig(t, configFile, `
+BCCSP:
+  Default: PKCS11
+  PKCS11:
+    Library: /usr/lib/softhsm/libsofthsm2.so
+    Label: ForFabric
+    Pin: 98765432
+    Hash: SHA3
+    Security: 384
+`)
+	defer reset()
+	config, err := getConfig()
+	assert.NoError(t, err)
+
+	assert.Equal(t, BCCSPSpec{
+		Default: "PKCS11",
+		PKCS11: PKCS11Spec{
+			Library:  "/usr/lib/softhsm/libsofthsm2.so",
+			Label:    "ForFabric",
+			Pin:      "98765432",
+			Hash:     "SHA3",
+			Security: 384,
+		},
+	}, bccspConfig(config))
+
+	// the flags override the config
+	*bccspProvider, *pkcs11Label = "SW", "Other"
+	defer func() { *bccspProvider, *pkcs11Label = "", "" }()
+	spec := bccspConfig(config)
+	assert.Equal(t, "SW", spec.Default)
+	assert.Equal(t, "Other", spec.PKCS11.Label)
+	assert.Equal(t, "98765432", spec.PKCS11.Pin)
+
+	// keys are kept in the keystores by default
+	assert.Equal(t, "SW", bccspConfig(&Config{}).Default)
+}
+
+func TestEmit(t *testing.T) {
+	dir := generateTree(t, testConfig)
+	defer os.RemoveAll(dir)
+	outDir, err := ioutil.TempDir("", "emit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	reset := useConfig(t, emConfig, testConfig)
+	defer reset()
+	*emInputDir = dir
+	*emOutputDir = outDir
+	*emitEnv = true
+	defer func() { *emitEnv = false }()
+	emit()
+
+	// the connection profile of the peer org, in both formats
+	profiles := []connectionProfile{}
+	for _, ext := range []string{".json", ".yaml"} {
+		data, err := ioutil.ReadFile(filepath.Join(outDir, "org1.example.com"+ext))
+		assert.NoError(t, err)
+		profile := connectionProfile{}
+		if ext == ".json" {
+			err = json.Unmarshal(data, &profile)
+		} else {
+			err = yaml.Unmarshal(data, &profile)
+		}
+		assert.NoError(t, err)
+		profiles = append(profiles, profile)
+	}
+	assert.Equal(t, profiles[0], profiles[1])
+	profile := profiles[0]
+	assert.Equal(t, "org1.example.com", profile.Name)
+	assert.Equal(t, "Org1", profile.Client.Organization)
+	assert.Equal(t, "Org1MSP", profile.Organizations["Org1"].MSPID)
+	assert.Equal(t, []string{"peer0.org1.example.com"}, profile.Organizations["Org1"].Peers)
+	assert.Equal(t, "OrdererMSP", profile.Organizations["Orderer"].MSPID)
+	assert.Equal(t, "grpcs://peer0.org1.example.com:7051", profile.Peers["peer0.org1.example.com"].URL)
+	assert.Equal(t, "grpcs://orderer.example.com:7050", profile.Orderers["orderer.example.com"].URL)
+	assert.Contains(t, profile.Orderers["orderer.example.com"].TLSCACerts.PEM, "BEGIN CERTIFICATE")
+	for _, org := range profile.Organizations {
+		_, err = os.Stat(org.SignedCert.Path)
+		assert.NoError(t, err)
+		_, err = os.Stat(org.AdminPrivateKey.Path)
+		assert.NoError(t, err)
+	}
+
+	// the environments of the nodes, including the orderer of the org with
+	// an intermediate CA
+	for _, env := range []struct {
+		node, mountDir string
+		vars           []string
+	}{
+		{"peer0.org1.example.com", peerMountDir, []string{
+			"CORE_PEER_ID=peer0.org1.example.com",
+			"CORE_PEER_LOCALMSPID=Org1MSP",
+			"CORE_PEER_MSPCONFIGPATH=/etc/hyperledger/fabric/msp",
+		}},
+		{"orderer.example.com", ordererMountDir, []string{
+			"ORDERER_GENERAL_LOCALMSPID=OrdererMSP",
+			"ORDERER_GENERAL_LOCALMSPDIR=/var/hyperledger/orderer/msp",
+		}},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(outDir, "env", env.node+".env"))
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		assert.True(t, strings.HasSuffix(lines[0], " at "+env.mountDir), lines[0])
+		for _, v := range env.vars {
+			assert.Contains(t, lines[1:], v)
+		}
+		for _, line := range lines[1:] {
+			assert.False(t, strings.HasPrefix(line, "="), "variable without a name in %s", env.node)
+		}
+	}
+}
+
+// testExportConfig has named users with attributes next to the counted ones
+const testExportConfig = `
+OrdererOrgs:
+  - Name: Orderer
+    Domain: example.com
+    Specs:
+      - Hostname: orderer
+PeerOrgs:
+  - Name: Org1
+    Domain: org1.example.com
+    IntermediateCAs:
+      - Hostname: ica
+    Template:
+      Count: 1
+    Users:
+      Count: 1
+      Specs:
+        - Name: alice
+          Admin: true
+          Attrs:
+            abac.init: "true"
+`
+
+// testExportedConfig adds users to both orgs of testExportConfig, which are
+// not registered with the CAs as long as they are not generated
+const testExportedConfig = `
+OrdererOrgs:
+  - Name: Orderer
+    Domain: example.com
+    Specs:
+      - Hostname: orderer
+    Users:
+      Count: 1
+PeerOrgs:
+  - Name: Org1
+    Domain: org1.example.com
+    IntermediateCAs:
+      - Hostname: ica
+    Template:
+      Count: 1
+    Users:
+      Count: 2
+      Specs:
+        - Name: alice
+          Admin: true
+          Attrs:
+            abac.init: "true"
+        - Name: bob
+`
+
+func TestExportCA(t *testing.T) {
+	dir := generateTree(t, testExportConfig)
+	defer os.RemoveAll(dir)
+	outDir, err := ioutil.TempDir("", "export-ca")
+	assert.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	reset := useConfig(t, expConfig, testExportedConfig)
+	defer reset()
+	*expInputDir = dir
+	*expOutputDir = outDir
+	exportCA()
+
+	// caDir is relative to the tree and homeDir to the output
+	readHome := func(caDir, homeDir, name, affiliation string) caServerConfig {
+		homeDir = filepath.Join(outDir, homeDir)
+
+		// the certificate and the key of the CA, which only its owner reads
+		certFile := filepath.Join(dir, caDir, name+"-cert.pem")
+		cert, err := ca.LoadCertificate(certFile)
+		assert.NoError(t, err)
+		expected, err := ioutil.ReadFile(certFile)
+		assert.NoError(t, err)
+		data, err := ioutil.ReadFile(filepath.Join(homeDir, "ca-cert.pem"))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, data)
+		_, signer, err := csp.LoadPrivateKey(filepath.Join(homeDir, "msp", "keystore"), cert.SubjectKeyId)
+		assert.NoError(t, err)
+		assert.Equal(t, cert.PublicKey, signer.Public())
+
+		configFile := filepath.Join(homeDir, "fabric-ca-server-config.yaml")
+		info, err := os.Stat(configFile)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		data, err = ioutil.ReadFile(configFile)
+		assert.NoError(t, err)
+		config := caServerConfig{}
+		assert.NoError(t, yaml.Unmarshal(data, &config))
+
+		assert.Equal(t, name, config.CA.Name)
+		assert.Equal(t, "ca-cert.pem", config.CA.Certfile)
+		info, err = os.Stat(filepath.Join(homeDir, config.CA.Keyfile))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		assert.Contains(t, config.Affiliations, affiliation)
+		return config
+	}
+
+	signConfig := readHome("peerOrganizations/org1.example.com/ca", "org1.example.com/ca",
+		"ca.org1.example.com", "org1")
+	tlsConfig := readHome("peerOrganizations/org1.example.com/tlsca", "org1.example.com/tlsca",
+		"tlsca.org1.example.com", "org1")
+	icaConfig := readHome("peerOrganizations/org1.example.com/ca/intermediates/ica.org1.example.com",
+		"org1.example.com/intermediates/ica.org1.example.com", "ica.org1.example.com", "org1")
+	ordererConfig := readHome("ordererOrganizations/example.com/ca", "example.com/ca", "ca.example.com", "orderer")
+	assert.Empty(t, signConfig.CA.Chainfile)
+
+	// the intermediate CA hands out its chain up to the root
+	assert.Equal(t, "ca-chain.pem", icaConfig.CA.Chainfile)
+	chain, err := ioutil.ReadFile(filepath.Join(outDir, "org1.example.com", "intermediates",
+		"ica.org1.example.com", icaConfig.CA.Chainfile))
+	assert.NoError(t, err)
+	icaCert, err := ioutil.ReadFile(filepath.Join(dir, "peerOrganizations", "org1.example.com", "ca",
+		"intermediates", "ica.org1.example.com", "ica.org1.example.com-cert.pem"))
+	assert.NoError(t, err)
+	rootCert, err := ioutil.ReadFile(filepath.Join(dir, "peerOrganizations", "org1.example.com", "ca",
+		"ca.org1.example.com-cert.pem"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(icaCert)+string(rootCert), string(chain))
+
+	// the CAs of the org register the same identities under the same secrets
+	assert.Equal(t, signConfig.Registry, tlsConfig.Registry)
+	assert.Equal(t, signConfig.Registry, icaConfig.Registry)
+	identities := map[string]caServerIdentity{}
+	for _, identity := range signConfig.Registry.Identities {
+		assert.Len(t, identity.Pass, 32)
+		identities[identity.Name] = identity
+	}
+	assert.Len(t, identities, 4)
+	assert.Equal(t, "true", identities["admin"].Attrs["hf.Revoker"])
+	assert.NotEmpty(t, identities["admin"].Attrs["hf.Registrar.DelegateRoles"])
+	assert.Equal(t, "true", identities["Admin@org1.example.com"].Attrs["hf.Revoker"])
+	assert.Equal(t, "org1", identities["Admin@org1.example.com"].Affiliation)
+	assert.Equal(t, "true", identities["alice@org1.example.com"].Attrs["hf.Revoker"])
+	assert.Equal(t, "true", identities["alice@org1.example.com"].Attrs["abac.init"])
+	assert.Equal(t, "org1", identities["User1@org1.example.com"].Affiliation)
+	assert.Empty(t, identities["User1@org1.example.com"].Attrs["hf.Revoker"])
+
+	// the orderer org only has its admin
+	identities = map[string]caServerIdentity{}
+	for _, identity := range ordererConfig.Registry.Identities {
+		identities[identity.Name] = identity
+	}
+	assert.Len(t, identities, 2)
+	assert.Contains(t, identities, "Admin@example.com")
+}
+
+func TestSeed(t *testing.T) {
+	*seed = "fixtures"
+	defer func() {
+		*seed = ""
+		csp.SetSeed("")
+		ca.SetClock(time.Time{})
+	}()
+
+	dir1 := generateTree(t, testConfig)
+	defer os.RemoveAll(dir1)
+	dir2 := generateTree(t, testConfig)
+	defer os.RemoveAll(dir2)
+
+	// both trees hold the same files with the same content
+	hashes := func(dir string) map[string]string {
+		files := map[string]string{}
+		for path, state := range snapshotTree(t, dir) {
+			files[path] = state.hash
+		}
+		return files
+	}
+	files := hashes(dir1)
+	assert.NotEmpty(t, files)
+	assert.Equal(t, files, hashes(dir2), "Expected the same tree from the same seed")
+	for _, ext := range []string{".json", ".yaml"} {
+		data1, err := ioutil.ReadFile(filepath.Join(dir1, manifestFile+ext))
+		assert.NoError(t, err)
+		data2, err := ioutil.ReadFile(filepath.Join(dir2, manifestFile+ext))
+		assert.NoError(t, err)
+		assert.Equal(t, string(data1), string(data2), "Expected the same %s", manifestFile+ext)
+	}
+
+	// including the serial numbers and validity periods of the CAs
+	caFile := filepath.Join("peerOrganizations", "org1.example.com", "ca", "ca.org1.example.com-cert.pem")
+	cert1, err := ca.LoadCertificate(filepath.Join(dir1, caFile))
+	assert.NoError(t, err)
+	cert2, err := ca.LoadCertificate(filepath.Join(dir2, caFile))
+	assert.NoError(t, err)
+	assert.Equal(t, cert1.SerialNumber, cert2.SerialNumber)
+	assert.Equal(t, cert1.NotBefore, cert2.NotBefore)
+	assert.False(t, cert1.NotBefore.After(seededClock), "Expected a certificate dated from the seeded clock")
+}
+
+func TestManifest(t *testing.T) {
+	dir := generateTree(t, testConfig)
+	defer os.RemoveAll(dir)
+
+	expected, err := readManifest(dir)
+	assert.NoError(t, err)
+
+	// generate wrote the manifest, in both formats
+	for _, ext := range []string{".json", ".yaml"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, manifestFile+ext))
+		assert.NoError(t, err)
+		m := &manifest{}
+		if ext == ".json" {
+			err = json.Unmarshal(data, m)
+		} else {
+			err = yaml.Unmarshal(data, m)
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, expected, m, "%s does not round trip", manifestFile+ext)
+	}
+
+	// the manifest describes the artifacts of the tree
+	checkCert := func(cert *manifestCert) {
+		if !assert.NotNil(t, cert) {
+			return
+		}
+		loaded, err := ca.LoadCertificate(filepath.Join(dir, cert.Path))
+		assert.NoError(t, err)
+		fingerprint := sha256.Sum256(loaded.Raw)
+		assert.Equal(t, hex.EncodeToString(fingerprint[:]), cert.Fingerprint)
+		ski, err := csp.SKI(loaded.PublicKey)
+		assert.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(ski), cert.SKI)
+		assert.True(t, loaded.NotAfter.Equal(cert.Expiry))
+	}
+	assert.Len(t, expected.Orgs, 2)
+	for _, org := range expected.Orgs {
+		_, err = os.Stat(filepath.Join(dir, org.MSPDir))
+		assert.NoError(t, err)
+		checkCert(org.CA)
+		checkCert(org.TLSCA)
+		assert.Len(t, org.Nodes, 1)
+		for _, node := range append(org.Nodes, org.Users...) {
+			checkCert(node.SignCert)
+			checkCert(node.TLSCert)
+			_, err = os.Stat(filepath.Join(dir, node.TLSKey))
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, "org1.example.com", expected.Orgs[0].Domain)
+	assert.Equal(t, "peer", expected.Orgs[0].Type)
+	assert.Len(t, expected.Orgs[0].Users, 2)
+	assert.Equal(t, "example.com", expected.Orgs[1].Domain)
+	assert.Equal(t, "orderer", expected.Orgs[1].Type)
+	assert.Len(t, expected.Orgs[1].Users, 1)
+}
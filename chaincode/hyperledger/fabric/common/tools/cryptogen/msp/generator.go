@@ -18,6 +18,8 @@ package msp
 import (
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -27,10 +29,56 @@ import (
 	"github.com/hyperledger/fabric/bccsp/factory"
 	"github.com/hyperledger/fabric/common/tools/cryptogen/ca"
 	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
+	fabricmsp "github.com/hyperledger/fabric/msp"
+	"gopkg.in/yaml.v2"
 )
 
+// Types of the nodes of an MSP
+const (
+	CLIENT = iota
+	ORDERER
+	PEER
+	ADMIN
+)
+
+// Organizational units identifying the types of nodes when NodeOUs are enabled
+const (
+	CLIENTOU  = "client"
+	PEEROU    = "peer"
+	ADMINOU   = "admin"
+	ORDEREROU = "orderer"
+)
+
+var nodeOUMap = map[int]string{
+	CLIENT:  CLIENTOU,
+	PEER:    PEEROU,
+	ADMIN:   ADMINOU,
+	ORDERER: ORDEREROU,
+}
+
+// name of the configuration file of an MSP
+const configFile = "config.yaml"
+
+// nodeOUsConfiguration is the content of the configuration file of an MSP
+// enabling NodeOUs
+type nodeOUsConfiguration struct {
+	NodeOUs *nodeOUs `yaml:"NodeOUs,omitempty"`
+}
+
+type nodeOUs struct {
+	Enable              bool                                                  `yaml:"Enable"`
+	ClientOUIdentifier  *fabricmsp.OrganizationalUnitIdentifiersConfiguration `yaml:"ClientOUIdentifier,omitempty"`
+	PeerOUIdentifier    *fabricmsp.OrganizationalUnitIdentifiersConfiguration `yaml:"PeerOUIdentifier,omitempty"`
+	AdminOUIdentifier   *fabricmsp.OrganizationalUnitIdentifiersConfiguration `yaml:"AdminOUIdentifier,omitempty"`
+	OrdererOUIdentifier *fabricmsp.OrganizationalUnitIdentifiersConfiguration `yaml:"OrdererOUIdentifier,omitempty"`
+}
+
+// GenerateLocalMSP creates the local MSP and the TLS artifacts of a node of
+// type nodeType in baseDir. If nodeOUs is set, the organizational unit of
+// nodeType is added to the signing certificate and NodeOUs are enabled in the
+// configuration of the MSP.
 func GenerateLocalMSP(baseDir, name string, sans []string, signCA *ca.CA,
-	tlsCA *ca.CA) error {
+	tlsCA *ca.CA, nodeType int, nodeOUs bool, keyAlgorithm string, opts ca.CertOptions) error {
 
 	// create folder structure
 	mspDir := filepath.Join(baseDir, "msp")
@@ -53,30 +101,50 @@ func GenerateLocalMSP(baseDir, name string, sans []string, signCA *ca.CA,
 	keystore := filepath.Join(mspDir, "keystore")
 
 	// generate private key
-	priv, _, err := csp.GeneratePrivateKey(keystore)
+	priv, _, err := csp.GenerateKey(keystore, keyAlgorithm)
 	if err != nil {
 		return err
 	}
 
 	// get public key
-	ecPubKey, err := csp.GetECPublicKey(priv)
+	pubKey, err := csp.GetPublicKey(priv)
 	if err != nil {
 		return err
 	}
 	// generate X509 certificate using signing CA
+	var ous []string
+	if nodeOUs {
+		ou, exists := nodeOUMap[nodeType]
+		if !exists {
+			return fmt.Errorf("unknown node type %d", nodeType)
+		}
+		ous = []string{ou}
+	}
 	cert, err := signCA.SignCertificate(filepath.Join(mspDir, "signcerts"),
-		name, []string{}, ecPubKey, x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{})
+		name, ous, []string{}, pubKey, x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{}, opts)
 	if err != nil {
 		return err
 	}
 
 	// write artifacts to MSP folders
 
-	// the signing CA certificate goes into cacerts
-	err = x509Export(filepath.Join(mspDir, "cacerts", x509Filename(signCA.Name)), signCA.SignCert)
+	// the root of the signing CA goes into cacerts
+	rootCA := signCA.Root()
+	err = x509Export(filepath.Join(mspDir, "cacerts", x509Filename(rootCA.Name)), rootCA.SignCert)
+	if err != nil {
+		return err
+	}
+	// the intermediate CAs up to the root go into intermediatecerts
+	err = ExportIntermediateCerts(mspDir, signCA)
 	if err != nil {
 		return err
 	}
+	if nodeOUs {
+		err = exportConfig(mspDir)
+		if err != nil {
+			return err
+		}
+	}
 	// the TLS CA certificate goes into tlscacerts
 	err = x509Export(filepath.Join(mspDir, "tlscacerts", x509Filename(tlsCA.Name)), tlsCA.SignCert)
 	if err != nil {
@@ -99,20 +167,24 @@ func GenerateLocalMSP(baseDir, name string, sans []string, signCA *ca.CA,
 		Generate the TLS artifacts in the TLS folder
 	*/
 
-	// generate private key
-	tlsPrivKey, _, err := csp.GeneratePrivateKey(tlsDir)
+	// generate private key, which is read from server.key by the node even
+	// when its signing key is kept in a PKCS#11 token
+	tlsPrivKey, _, err := csp.GenerateSoftwareKey(tlsDir, keyAlgorithm)
 	if err != nil {
 		return err
 	}
 	// get public key
-	tlsPubKey, err := csp.GetECPublicKey(tlsPrivKey)
+	tlsPubKey, err := csp.GetPublicKey(tlsPrivKey)
 	if err != nil {
 		return err
 	}
-	// generate X509 certificate using TLS CA
+	// generate X509 certificate using TLS CA, without the attributes of the
+	// identity
+	tlsOpts := opts
+	tlsOpts.Attributes = nil
 	_, err = tlsCA.SignCertificate(filepath.Join(tlsDir),
-		name, sans, tlsPubKey, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment,
-		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth})
+		name, nil, sans, tlsPubKey, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, tlsOpts)
 	if err != nil {
 		return err
 	}
@@ -136,16 +208,30 @@ func GenerateLocalMSP(baseDir, name string, sans []string, signCA *ca.CA,
 	return nil
 }
 
-func GenerateVerifyingMSP(baseDir string, signCA *ca.CA, tlsCA *ca.CA) error {
+// GenerateVerifyingMSP creates the MSP of an org in baseDir. If nodeOUs is
+// set, NodeOUs are enabled in the configuration of the MSP.
+func GenerateVerifyingMSP(baseDir string, signCA *ca.CA, tlsCA *ca.CA, nodeOUs bool) error {
 
 	// create folder structure and write artifacts to proper locations
 	err := createFolderStructure(baseDir, false)
 	if err == nil {
-		// the signing CA certificate goes into cacerts
-		err = x509Export(filepath.Join(baseDir, "cacerts", x509Filename(signCA.Name)), signCA.SignCert)
+		// the root of the signing CA goes into cacerts
+		rootCA := signCA.Root()
+		err = x509Export(filepath.Join(baseDir, "cacerts", x509Filename(rootCA.Name)), rootCA.SignCert)
+		if err != nil {
+			return err
+		}
+		// the intermediate CAs up to the root go into intermediatecerts
+		err = ExportIntermediateCerts(baseDir, signCA)
 		if err != nil {
 			return err
 		}
+		if nodeOUs {
+			err = exportConfig(baseDir)
+			if err != nil {
+				return err
+			}
+		}
 		// the TLS CA certificate goes into tlscacerts
 		err = x509Export(filepath.Join(baseDir, "tlscacerts", x509Filename(tlsCA.Name)), tlsCA.SignCert)
 		if err != nil {
@@ -166,7 +252,8 @@ func GenerateVerifyingMSP(baseDir string, signCA *ca.CA, tlsCA *ca.CA) error {
 		return err
 	}
 	_, err = signCA.SignCertificate(filepath.Join(baseDir, "admincerts"), signCA.Name,
-		[]string{""}, ecPubKey, x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{})
+		nil, []string{""}, ecPubKey, x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{},
+		ca.CertOptions{})
 	if err != nil {
 		return err
 	}
@@ -174,6 +261,71 @@ func GenerateVerifyingMSP(baseDir string, signCA *ca.CA, tlsCA *ca.CA) error {
 	return nil
 }
 
+// ExportIntermediateCerts writes the certificates of the intermediate CAs
+// between each of cas and its root into the intermediatecerts folder of the
+// MSP in mspDir. Root CAs have no such certificates.
+func ExportIntermediateCerts(mspDir string, cas ...*ca.CA) error {
+
+	for _, intermediateCA := range cas {
+		for intermediate := intermediateCA; intermediate.Parent != nil; intermediate = intermediate.Parent {
+			intermediateDir := filepath.Join(mspDir, "intermediatecerts")
+			err := os.MkdirAll(intermediateDir, 0755)
+			if err != nil {
+				return err
+			}
+			err = x509Export(filepath.Join(intermediateDir, x509Filename(intermediate.Name)),
+				intermediate.SignCert)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ExportCRL writes the DER encoded crl issued by signCA into the crls folder
+// of the MSP in mspDir, replacing any previous CRL of the same CA
+func ExportCRL(mspDir string, signCA *ca.CA, crl []byte) error {
+
+	crlsDir := filepath.Join(mspDir, "crls")
+	err := os.MkdirAll(crlsDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return pemExport(filepath.Join(crlsDir, crlFilename(signCA.Name)), "X509 CRL", crl)
+}
+
+// exportConfig writes the configuration file of the MSP in mspDir enabling
+// NodeOUs. The identifiers carry no certificate: an identity only matches one
+// whose certificate hashes to its whole chain, and the identities of an org
+// may be issued by its root and by any of its intermediate CAs.
+func exportConfig(mspDir string) error {
+
+	ouIdentifier := func(ou string) *fabricmsp.OrganizationalUnitIdentifiersConfiguration {
+		return &fabricmsp.OrganizationalUnitIdentifiersConfiguration{
+			OrganizationalUnitIdentifier: ou,
+		}
+	}
+	config := &nodeOUsConfiguration{
+		NodeOUs: &nodeOUs{
+			Enable:              true,
+			ClientOUIdentifier:  ouIdentifier(CLIENTOU),
+			PeerOUIdentifier:    ouIdentifier(PEEROU),
+			AdminOUIdentifier:   ouIdentifier(ADMINOU),
+			OrdererOUIdentifier: ouIdentifier(ORDEREROU),
+		},
+	}
+
+	configBytes, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(mspDir, configFile), configBytes, 0644)
+}
+
 func createFolderStructure(rootDir string, local bool) error {
 
 	var folders []string
@@ -202,6 +354,10 @@ func x509Filename(name string) string {
 	return name + "-cert.pem"
 }
 
+func crlFilename(name string) string {
+	return name + "-crl.pem"
+}
+
 func x509Export(path string, cert *x509.Certificate) error {
 	return pemExport(path, "CERTIFICATE", cert.Raw)
 }
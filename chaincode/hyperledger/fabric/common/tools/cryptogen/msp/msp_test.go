@@ -16,14 +16,25 @@ limitations under the License.
 package msp_test
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/common/tools/cryptogen/ca"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
 	"github.com/hyperledger/fabric/common/tools/cryptogen/msp"
 	fabricmsp "github.com/hyperledger/fabric/msp"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -38,7 +49,8 @@ func TestGenerateLocalMSP(t *testing.T) {
 
 	cleanup(testDir)
 
-	err := msp.GenerateLocalMSP(testDir, testName, nil, &ca.CA{}, &ca.CA{})
+	err := msp.GenerateLocalMSP(testDir, testName, nil, &ca.CA{}, &ca.CA{}, msp.PEER, false,
+		csp.DefaultKeyAlgorithm, ca.CertOptions{})
 	assert.Error(t, err, "Empty CA should have failed")
 
 	caDir := filepath.Join(testDir, "ca")
@@ -46,13 +58,14 @@ func TestGenerateLocalMSP(t *testing.T) {
 	mspDir := filepath.Join(testDir, "msp")
 
 	// generate signing CA
-	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName)
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
 	assert.NoError(t, err, "Error generating CA")
 	// generate TLS CA
-	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, testCAName)
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
 	assert.NoError(t, err, "Error generating CA")
 	// generate local MSP
-	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA)
+	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA, msp.PEER, false,
+		csp.DefaultKeyAlgorithm, ca.CertOptions{})
 	assert.NoError(t, err, "Failed to generate local MSP")
 
 	// check to see that the right files were generated/saved
@@ -78,29 +91,363 @@ func TestGenerateLocalMSP(t *testing.T) {
 	assert.NoError(t, err, "Error setting up local MSP")
 
 	tlsCA.Name = "test/fail"
-	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA)
+	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA, msp.PEER, false,
+		csp.DefaultKeyAlgorithm, ca.CertOptions{})
 	assert.Error(t, err, "Should have failed with CA name 'test/fail'")
 	signCA.Name = "test/fail"
-	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA)
+	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA, msp.PEER, false,
+		csp.DefaultKeyAlgorithm, ca.CertOptions{})
 	assert.Error(t, err, "Should have failed with CA name 'test/fail'")
 	t.Log(err)
 	cleanup(testDir)
 
 }
 
+func TestGenerateLocalMSPKeyAlgorithms(t *testing.T) {
+
+	cleanup(testDir)
+
+	caDir := filepath.Join(testDir, "ca")
+	tlsCADir := filepath.Join(testDir, "tlsca")
+
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.ECDSAP384, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, testCAName, csp.ECDSAP384, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+
+	for _, algorithm := range csp.KeyAlgorithms {
+		nodeDir := filepath.Join(testDir, algorithm)
+		mspDir := filepath.Join(nodeDir, "msp")
+		tlsDir := filepath.Join(nodeDir, "tls")
+
+		err = msp.GenerateLocalMSP(nodeDir, testName, []string{testName}, signCA, tlsCA, msp.PEER, false,
+			algorithm, ca.CertOptions{})
+		assert.NoError(t, err, "Failed to generate %s local MSP", algorithm)
+
+		// the signing identity matches its key and chains to the signing CA
+		cert, err := ca.LoadCertificate(filepath.Join(mspDir, "signcerts", testName+"-cert.pem"))
+		assert.NoError(t, err, "Failed to load signing certificate")
+		assert.NoError(t, cert.CheckSignatureFrom(signCA.SignCert))
+		keyFiles, err := filepath.Glob(filepath.Join(mspDir, "keystore", "*_sk"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(keyFiles))
+		_, err = tls.X509KeyPair(pemFile(t, filepath.Join(mspDir, "signcerts", testName+"-cert.pem")),
+			pemFile(t, keyFiles[0]))
+		assert.NoError(t, err, "%s signing certificate does not match its key", algorithm)
+
+		// the TLS key pair is usable and chains to the TLS CA
+		tlsCert, err := tls.LoadX509KeyPair(filepath.Join(tlsDir, "server.crt"),
+			filepath.Join(tlsDir, "server.key"))
+		assert.NoError(t, err, "Failed to load %s TLS key pair", algorithm)
+		leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+		assert.NoError(t, err)
+		assert.NoError(t, leaf.CheckSignatureFrom(tlsCA.SignCert))
+		assert.Equal(t, []string{testName}, leaf.DNSNames)
+	}
+
+	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA, msp.PEER, false,
+		"DSA", ca.CertOptions{})
+	assert.Error(t, err, "Unsupported key algorithm should fail")
+	cleanup(testDir)
+}
+
+func TestGenerateLocalMSPCertOptions(t *testing.T) {
+
+	cleanup(testDir)
+
+	caDir := filepath.Join(testDir, "ca")
+	tlsCADir := filepath.Join(testDir, "tlsca")
+
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm,
+		ca.SequentialSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm,
+		ca.SequentialSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+
+	opts := ca.CertOptions{Country: "DE", Validity: 720 * time.Hour}
+	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA, msp.PEER, false,
+		csp.DefaultKeyAlgorithm, opts)
+	assert.NoError(t, err, "Failed to generate local MSP")
+
+	// both the signing and the TLS certificates follow opts
+	for _, certFile := range []string{
+		filepath.Join(testDir, "msp", "signcerts", testName+"-cert.pem"),
+		filepath.Join(testDir, "tls", "server.crt"),
+	} {
+		cert, err := ca.LoadCertificate(certFile)
+		assert.NoError(t, err, "Failed to load "+certFile)
+		assert.Equal(t, []string{"DE"}, cert.Subject.Country)
+		assert.Equal(t, opts.Validity, cert.NotAfter.Sub(cert.NotBefore))
+		assert.Equal(t, int64(2), cert.SerialNumber.Int64())
+	}
+
+	// only the signing certificate holds the attributes of the identity
+	cleanup(testDir)
+	signCA, err = ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	tlsCA, err = ca.NewCA(tlsCADir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	opts = ca.CertOptions{Attributes: map[string]string{"abac.init": "true"}}
+	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA, msp.CLIENT, false,
+		csp.DefaultKeyAlgorithm, opts)
+	assert.NoError(t, err, "Failed to generate local MSP")
+	for certFile, expected := range map[string]bool{
+		filepath.Join(testDir, "msp", "signcerts", testName+"-cert.pem"): true,
+		filepath.Join(testDir, "tls", "server.crt"):                      false,
+	} {
+		cert, err := ca.LoadCertificate(certFile)
+		assert.NoError(t, err, "Failed to load "+certFile)
+		found := false
+		for _, ext := range cert.Extensions {
+			found = found || ext.Id.Equal(ca.AttributesOID)
+		}
+		assert.Equal(t, expected, found, "Attributes extension in "+certFile)
+	}
+	cleanup(testDir)
+}
+
+func TestGenerateMSPIntermediateCAs(t *testing.T) {
+
+	cleanup(testDir)
+
+	caDir := filepath.Join(testDir, "ca")
+	tlsCADir := filepath.Join(testDir, "tlsca")
+	nodeDir := filepath.Join(testDir, "node")
+	mspDir := filepath.Join(testDir, "msp")
+
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, "tls"+testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	// a two level hierarchy of intermediate CAs
+	ica1, err := signCA.NewIntermediateCA(filepath.Join(caDir, "ica1"), testCAOrg, "ica1."+testCAOrg,
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating intermediate CA")
+	ica2, err := ica1.NewIntermediateCA(filepath.Join(caDir, "ica2"), testCAOrg, "ica2."+testCAOrg,
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating intermediate CA")
+
+	err = msp.GenerateLocalMSP(nodeDir, testName, nil, ica2, tlsCA, msp.PEER, false,
+		csp.DefaultKeyAlgorithm,
+		ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate local MSP")
+
+	// only the root goes into cacerts, the rest of the chain into intermediatecerts
+	files := []string{
+		filepath.Join(nodeDir, "msp", "cacerts", testCAName+"-cert.pem"),
+		filepath.Join(nodeDir, "msp", "intermediatecerts", "ica1."+testCAOrg+"-cert.pem"),
+		filepath.Join(nodeDir, "msp", "intermediatecerts", "ica2."+testCAOrg+"-cert.pem"),
+	}
+	for _, file := range files {
+		assert.Equal(t, true, checkForFile(file),
+			"Expected to find file "+file)
+	}
+	assert.Equal(t, false, checkForFile(filepath.Join(nodeDir, "msp", "cacerts", "ica2."+testCAOrg+"-cert.pem")))
+
+	// the identity issued by the intermediate CA is valid for the MSP of the
+	// node, as well as for the verifying MSP of the org once the intermediate
+	// certificates are exported
+	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, false)
+	assert.NoError(t, err, "Failed to generate verifying MSP")
+	err = msp.ExportIntermediateCerts(mspDir, ica1, ica2)
+	assert.NoError(t, err, "Failed to export intermediate certs")
+	// identities issued by a CA which issued intermediate CAs are invalid, so
+	// the throwaway admin signed by the root must be replaced
+	err = os.Remove(filepath.Join(mspDir, "admincerts", testCAName+"-cert.pem"))
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(mspDir, "admincerts", testName+"-cert.pem"),
+		pemFile(t, filepath.Join(nodeDir, "msp", "signcerts", testName+"-cert.pem")), 0644)
+	assert.NoError(t, err)
+
+	serialized, err := proto.Marshal(&mspprotos.SerializedIdentity{
+		Mspid:   testName,
+		IdBytes: pemFile(t, filepath.Join(nodeDir, "msp", "signcerts", testName+"-cert.pem")),
+	})
+	assert.NoError(t, err, "Error serializing identity")
+	for _, dir := range []string{filepath.Join(nodeDir, "msp"), mspDir} {
+		testMSPConfig, err := fabricmsp.GetVerifyingMspConfig(dir, testName)
+		assert.NoError(t, err, "Error parsing MSP config")
+		testMSP, err := fabricmsp.NewBccspMsp()
+		assert.NoError(t, err, "Error creating new BCCSP MSP")
+		err = testMSP.Setup(testMSPConfig)
+		assert.NoError(t, err, "Error setting up MSP in "+dir)
+		id, err := testMSP.DeserializeIdentity(serialized)
+		assert.NoError(t, err, "Error deserializing identity")
+		assert.NoError(t, testMSP.Validate(id), "Identity issued by intermediate CA should be valid")
+	}
+
+	// root CAs have no intermediate certificates
+	err = msp.ExportIntermediateCerts(filepath.Join(testDir, "root"), signCA)
+	assert.NoError(t, err, "Failed to export intermediate certs")
+	assert.Equal(t, false, checkForFile(filepath.Join(testDir, "root", "intermediatecerts")))
+	cleanup(testDir)
+}
+
+func TestGenerateMSPNodeOUs(t *testing.T) {
+
+	cleanup(testDir)
+
+	caDir := filepath.Join(testDir, "ca")
+	tlsCADir := filepath.Join(testDir, "tlsca")
+	mspDir := filepath.Join(testDir, "msp")
+
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, "tls"+testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+
+	expectedConfig := `NodeOUs:
+  Enable: true
+  ClientOUIdentifier:
+    OrganizationalUnitIdentifier: client
+  PeerOUIdentifier:
+    OrganizationalUnitIdentifier: peer
+  AdminOUIdentifier:
+    OrganizationalUnitIdentifier: admin
+  OrdererOUIdentifier:
+    OrganizationalUnitIdentifier: orderer
+`
+
+	// each type of node gets its organizational unit besides the one of the options
+	nodeTypes := map[int]string{
+		msp.CLIENT:  "client",
+		msp.PEER:    "peer",
+		msp.ADMIN:   "admin",
+		msp.ORDERER: "orderer",
+	}
+	for nodeType, ou := range nodeTypes {
+		nodeDir := filepath.Join(testDir, ou)
+		err = msp.GenerateLocalMSP(nodeDir, testName, nil, signCA, tlsCA, nodeType, true,
+			csp.DefaultKeyAlgorithm, ca.CertOptions{OrganizationalUnit: "blockchain"})
+		assert.NoError(t, err, "Failed to generate local MSP")
+
+		cert, err := ca.LoadCertificate(filepath.Join(nodeDir, "msp", "signcerts", testName+"-cert.pem"))
+		assert.NoError(t, err, "Failed to load signing certificate")
+		assert.Equal(t, 2, len(cert.Subject.OrganizationalUnit))
+		assert.Contains(t, cert.Subject.OrganizationalUnit, "blockchain")
+		assert.Contains(t, cert.Subject.OrganizationalUnit, ou)
+		tlsCert, err := ca.LoadCertificate(filepath.Join(nodeDir, "tls", "server.crt"))
+		assert.NoError(t, err, "Failed to load TLS certificate")
+		assert.Equal(t, []string{"blockchain"}, tlsCert.Subject.OrganizationalUnit)
+
+		assert.Equal(t, expectedConfig, string(pemFile(t, filepath.Join(nodeDir, "msp", "config.yaml"))))
+		testMSPConfig, err := fabricmsp.GetVerifyingMspConfig(filepath.Join(nodeDir, "msp"), testName)
+		assert.NoError(t, err, "Error parsing MSP config")
+		testMSP, err := fabricmsp.NewBccspMsp()
+		assert.NoError(t, err, "Error creating new BCCSP MSP")
+		assert.NoError(t, testMSP.Setup(testMSPConfig), "Error setting up MSP")
+	}
+
+	err = msp.GenerateLocalMSP(filepath.Join(testDir, "unknown"), testName, nil, signCA, tlsCA, 42, true,
+		csp.DefaultKeyAlgorithm, ca.CertOptions{})
+	assert.Error(t, err, "Unknown node type should fail")
+
+	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, true)
+	assert.NoError(t, err, "Failed to generate verifying MSP")
+	assert.Equal(t, expectedConfig, string(pemFile(t, filepath.Join(mspDir, "config.yaml"))))
+
+	// without NodeOUs there are neither organizational units nor configuration
+	nodeDir := filepath.Join(testDir, "disabled")
+	err = msp.GenerateLocalMSP(nodeDir, testName, nil, signCA, tlsCA, msp.PEER, false,
+		csp.DefaultKeyAlgorithm, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate local MSP")
+	cert, err := ca.LoadCertificate(filepath.Join(nodeDir, "msp", "signcerts", testName+"-cert.pem"))
+	assert.NoError(t, err, "Failed to load signing certificate")
+	assert.Equal(t, 0, len(cert.Subject.OrganizationalUnit))
+	assert.Equal(t, false, checkForFile(filepath.Join(nodeDir, "msp", "config.yaml")))
+	cleanup(testDir)
+}
+
+func TestGenerateMSPNodeOUsIntermediateCA(t *testing.T) {
+
+	cleanup(testDir)
+
+	caDir := filepath.Join(testDir, "ca")
+	tlsCADir := filepath.Join(testDir, "tlsca")
+	nodeDir := filepath.Join(testDir, "node")
+	mspDir := filepath.Join(testDir, "msp")
+
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, "tls"+testCAName, csp.DefaultKeyAlgorithm,
+		ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	ica, err := signCA.NewIntermediateCA(filepath.Join(caDir, "ica"), testCAOrg, "ica."+testCAOrg,
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating intermediate CA")
+
+	err = msp.GenerateLocalMSP(nodeDir, testName, nil, ica, tlsCA, msp.PEER, true,
+		csp.DefaultKeyAlgorithm, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate local MSP")
+	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, true)
+	assert.NoError(t, err, "Failed to generate verifying MSP")
+	err = msp.ExportIntermediateCerts(mspDir, ica)
+	assert.NoError(t, err, "Failed to export intermediate certs")
+	err = os.Remove(filepath.Join(mspDir, "admincerts", testCAName+"-cert.pem"))
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(mspDir, "admincerts", testName+"-cert.pem"),
+		pemFile(t, filepath.Join(nodeDir, "msp", "signcerts", testName+"-cert.pem")), 0644)
+	assert.NoError(t, err)
+
+	serialized, err := proto.Marshal(&mspprotos.SerializedIdentity{
+		Mspid:   testName,
+		IdBytes: pemFile(t, filepath.Join(nodeDir, "msp", "signcerts", testName+"-cert.pem")),
+	})
+	assert.NoError(t, err, "Error serializing identity")
+
+	// the identity issued by the intermediate CA is classified as a peer by
+	// both the MSP of the node and the MSP of the org
+	for _, dir := range []string{filepath.Join(nodeDir, "msp"), mspDir} {
+		testMSPConfig, err := fabricmsp.GetVerifyingMspConfig(dir, testName)
+		assert.NoError(t, err, "Error parsing MSP config")
+		testMSP, err := fabricmsp.NewBccspMsp()
+		assert.NoError(t, err, "Error creating new BCCSP MSP")
+		assert.NoError(t, testMSP.Setup(testMSPConfig), "Error setting up MSP in "+dir)
+		id, err := testMSP.DeserializeIdentity(serialized)
+		assert.NoError(t, err, "Error deserializing identity")
+		assert.Equal(t, []string{msp.PEEROU}, nodeOUClassification(t, dir, id))
+	}
+
+	// identifying the NodeOUs by the root CA would leave it without a role,
+	// as its chain goes through the intermediate CA
+	config := pemFile(t, filepath.Join(mspDir, "config.yaml"))
+	config = []byte(strings.Replace(string(config), "    OrganizationalUnitIdentifier:",
+		"    Certificate: cacerts/"+testCAName+"-cert.pem\n    OrganizationalUnitIdentifier:", -1))
+	err = ioutil.WriteFile(filepath.Join(mspDir, "config.yaml"), config, 0644)
+	assert.NoError(t, err)
+	testMSPConfig, err := fabricmsp.GetVerifyingMspConfig(mspDir, testName)
+	assert.NoError(t, err, "Error parsing MSP config")
+	testMSP, err := fabricmsp.NewBccspMsp()
+	assert.NoError(t, err, "Error creating new BCCSP MSP")
+	assert.NoError(t, testMSP.Setup(testMSPConfig), "Error setting up MSP")
+	id, err := testMSP.DeserializeIdentity(serialized)
+	assert.NoError(t, err, "Error deserializing identity")
+	assert.Empty(t, nodeOUClassification(t, mspDir, id))
+
+	cleanup(testDir)
+}
+
 func TestGenerateVerifyingMSP(t *testing.T) {
 
 	caDir := filepath.Join(testDir, "ca")
 	tlsCADir := filepath.Join(testDir, "tlsca")
 	mspDir := filepath.Join(testDir, "msp")
 	// generate signing CA
-	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName)
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
 	assert.NoError(t, err, "Error generating CA")
 	// generate TLS CA
-	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, testCAName)
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
 	assert.NoError(t, err, "Error generating CA")
 
-	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA)
+	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, false)
 	assert.NoError(t, err, "Failed to generate verifying MSP")
 
 	// check to see that the right files were generated/saved
@@ -123,19 +470,218 @@ func TestGenerateVerifyingMSP(t *testing.T) {
 	assert.NoError(t, err, "Error setting up verifying MSP")
 
 	tlsCA.Name = "test/fail"
-	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA)
+	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, false)
 	assert.Error(t, err, "Should have failed with CA name 'test/fail'")
 	signCA.Name = "test/fail"
-	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA)
+	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, false)
 	assert.Error(t, err, "Should have failed with CA name 'test/fail'")
 	t.Log(err)
 	cleanup(testDir)
 }
 
+func TestExportCRL(t *testing.T) {
+
+	cleanup(testDir)
+
+	caDir := filepath.Join(testDir, "ca")
+	tlsCADir := filepath.Join(testDir, "tlsca")
+	mspDir := filepath.Join(testDir, "msp")
+
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	err = msp.GenerateLocalMSP(testDir, testName, nil, signCA, tlsCA, msp.PEER, false,
+		csp.DefaultKeyAlgorithm, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate local MSP")
+
+	// revoke the signing identity of the local MSP
+	cert, err := ca.LoadCertificate(filepath.Join(mspDir, "signcerts", testName+"-cert.pem"))
+	assert.NoError(t, err, "Failed to load signing certificate")
+	err = signCA.RevokeCertificate(caDir, testName, cert)
+	assert.NoError(t, err, "Failed to revoke certificate")
+	crl, err := signCA.GenerateCRL(caDir, time.Hour)
+	assert.NoError(t, err, "Failed to generate CRL")
+
+	err = msp.ExportCRL(mspDir, signCA, crl)
+	assert.NoError(t, err, "Failed to export CRL")
+	crlFile := filepath.Join(mspDir, "crls", testCAName+"-crl.pem")
+	assert.Equal(t, true, checkForFile(crlFile),
+		"Expected to find file "+crlFile)
+
+	// the MSP must now reject the revoked identity, which is also its admin
+	testMSPConfig, err := fabricmsp.GetLocalMspConfig(mspDir, nil, testName)
+	assert.NoError(t, err, "Error parsing local MSP config")
+	testMSP, err := fabricmsp.NewBccspMsp()
+	assert.NoError(t, err, "Error creating new BCCSP MSP")
+	err = testMSP.Setup(testMSPConfig)
+	assert.Error(t, err, "Revoked admin should not be valid")
+	assert.Contains(t, err.Error(), "revoked")
+
+	err = msp.ExportCRL(filepath.Join(mspDir, "crls", testCAName+"-crl.pem"), signCA, crl)
+	assert.Error(t, err, "Exporting below a file should fail")
+	cleanup(testDir)
+}
+
+func TestVerifyLocalMSP(t *testing.T) {
+
+	cleanup(testDir)
+
+	caDir := filepath.Join(testDir, "ca")
+	tlsCADir := filepath.Join(testDir, "tlsca")
+	mspDir := filepath.Join(testDir, "msp")
+	sans := []string{testName + "." + testCAOrg, testName}
+
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, "tls"+testCAName, csp.RSA2048, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	err = msp.GenerateLocalMSP(testDir, testName, sans, signCA, tlsCA, msp.PEER, false,
+		csp.DefaultKeyAlgorithm, ca.CertOptions{})
+	assert.NoError(t, err, "Failed to generate local MSP")
+
+	assert.Empty(t, msp.VerifyLocalMSP(testDir, sans), "Expected a consistent local MSP")
+
+	// a SAN missing from the TLS certificate is reported
+	problems := msp.VerifyLocalMSP(testDir, append(sans, "alt."+testCAOrg))
+	assert.Len(t, problems, 1)
+
+	// so is a keystore which does not hold the signing key
+	keyFiles, err := filepath.Glob(filepath.Join(mspDir, "keystore", "*_sk"))
+	assert.NoError(t, err)
+	assert.Len(t, keyFiles, 1)
+	err = os.Rename(filepath.Join(testDir, "tls", "server.key"), keyFiles[0])
+	assert.NoError(t, err, "Failed to replace the signing key")
+	problems = msp.VerifyLocalMSP(testDir, sans)
+	assert.Len(t, problems, 2, "Expected mismatching signing and TLS keys")
+
+	// and certificates which do not chain to the CAs of the MSP
+	err = os.RemoveAll(filepath.Join(mspDir, "cacerts"))
+	assert.NoError(t, err)
+	problems = msp.VerifyLocalMSP(testDir, sans)
+	assert.Len(t, problems, 5, "Expected missing cacerts, admincerts and signcerts chains")
+
+	cleanup(testDir)
+}
+
+func TestVerifyVerifyingMSP(t *testing.T) {
+
+	cleanup(testDir)
+
+	caDir := filepath.Join(testDir, "ca")
+	tlsCADir := filepath.Join(testDir, "tlsca")
+	mspDir := filepath.Join(testDir, "msp")
+
+	signCA, err := ca.NewCA(caDir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	tlsCA, err := ca.NewCA(tlsCADir, testCAOrg, testCAName, csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	err = msp.GenerateVerifyingMSP(mspDir, signCA, tlsCA, false)
+	assert.NoError(t, err, "Failed to generate verifying MSP")
+
+	assert.Empty(t, msp.VerifyVerifyingMSP(mspDir), "Expected a consistent verifying MSP")
+
+	// admin certificates issued by another CA are reported
+	_, err = ca.NewCA(filepath.Join(testDir, "other"), testCAOrg, "other"+testCAName,
+		csp.DefaultKeyAlgorithm, ca.RandomSerials, ca.CertOptions{})
+	assert.NoError(t, err, "Error generating CA")
+	err = os.Rename(filepath.Join(testDir, "other", "other"+testCAName+"-cert.pem"),
+		filepath.Join(mspDir, "admincerts", testCAName+"-cert.pem"))
+	assert.NoError(t, err)
+	assert.Len(t, msp.VerifyVerifyingMSP(mspDir), 1)
+
+	// as are empty folders
+	err = os.RemoveAll(filepath.Join(mspDir, "admincerts"))
+	assert.NoError(t, err)
+	err = os.RemoveAll(filepath.Join(mspDir, "tlscacerts"))
+	assert.NoError(t, err)
+	assert.Len(t, msp.VerifyVerifyingMSP(mspDir), 2)
+
+	cleanup(testDir)
+}
+
+// nodeOUClassification returns the organizational units of the NodeOUs
+// configured in the config.yaml of mspDir which id matches. As in Fabric, an
+// identifier with a certificate only matches identities whose certification
+// chain starts with that certificate.
+func nodeOUClassification(t *testing.T, mspDir string, id fabricmsp.Identity) []string {
+
+	var config struct {
+		NodeOUs struct {
+			ClientOUIdentifier  *fabricmsp.OrganizationalUnitIdentifiersConfiguration `yaml:"ClientOUIdentifier"`
+			PeerOUIdentifier    *fabricmsp.OrganizationalUnitIdentifiersConfiguration `yaml:"PeerOUIdentifier"`
+			AdminOUIdentifier   *fabricmsp.OrganizationalUnitIdentifiersConfiguration `yaml:"AdminOUIdentifier"`
+			OrdererOUIdentifier *fabricmsp.OrganizationalUnitIdentifiersConfiguration `yaml:"OrdererOUIdentifier"`
+		} `yaml:"NodeOUs"`
+	}
+	err := yaml.Unmarshal(pemFile(t, filepath.Join(mspDir, "config.yaml")), &config)
+	assert.NoError(t, err, "Error parsing config.yaml")
+
+	var ous []string
+	for _, identifier := range []*fabricmsp.OrganizationalUnitIdentifiersConfiguration{
+		config.NodeOUs.ClientOUIdentifier,
+		config.NodeOUs.PeerOUIdentifier,
+		config.NodeOUs.AdminOUIdentifier,
+		config.NodeOUs.OrdererOUIdentifier,
+	} {
+		var certifiers []byte
+		if identifier.Certificate != "" {
+			certifiers = certificationChainIdentifier(t, mspDir, identifier.Certificate)
+		}
+		for _, ou := range id.GetOrganizationalUnits() {
+			if ou.OrganizationalUnitIdentifier == identifier.OrganizationalUnitIdentifier &&
+				(certifiers == nil || bytes.Equal(certifiers, ou.CertifiersIdentifier)) {
+				ous = append(ous, ou.OrganizationalUnitIdentifier)
+			}
+		}
+	}
+	return ous
+}
+
+// certificationChainIdentifier hashes the chain from the CA certificate in
+// file up to the root, out of the CA certificates of the MSP in mspDir
+func certificationChainIdentifier(t *testing.T, mspDir, file string) []byte {
+
+	var cas []*x509.Certificate
+	for _, dir := range []string{"cacerts", "intermediatecerts"} {
+		files, err := filepath.Glob(filepath.Join(mspDir, dir, "*.pem"))
+		assert.NoError(t, err)
+		for _, caFile := range files {
+			cert, err := ca.LoadCertificate(caFile)
+			assert.NoError(t, err, "Failed to load CA certificate")
+			cas = append(cas, cert)
+		}
+	}
+
+	cert, err := ca.LoadCertificate(filepath.Join(mspDir, file))
+	assert.NoError(t, err, "Failed to load CA certificate")
+	hash := sha256.New()
+	for cert != nil {
+		hash.Write(cert.Raw)
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			break
+		}
+		issuer := cert
+		cert = nil
+		for _, caCert := range cas {
+			if bytes.Equal(issuer.RawIssuer, caCert.RawSubject) {
+				cert = caCert
+			}
+		}
+	}
+	return hash.Sum(nil)
+}
+
 func cleanup(dir string) {
 	os.RemoveAll(dir)
 }
 
+func pemFile(t *testing.T, file string) []byte {
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err, "Failed to read "+file)
+	return data
+}
+
 func checkForFile(file string) bool {
 	if _, err := os.Stat(file); os.IsNotExist(err) {
 		return false
@@ -0,0 +1,220 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package msp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric/common/tools/cryptogen/ca"
+	"github.com/hyperledger/fabric/common/tools/cryptogen/csp"
+)
+
+// VerifyLocalMSP audits the local MSP and the TLS artifacts of a node
+// generated in baseDir by GenerateLocalMSP. It checks that the signing and
+// TLS certificates match their private keys and chain to the CAs of the MSP,
+// that the MSP has admin certificates, and that the TLS certificate holds
+// every name in sans. Every problem found is returned.
+func VerifyLocalMSP(baseDir string, sans []string) []error {
+
+	mspDir := filepath.Join(baseDir, "msp")
+	tlsDir := filepath.Join(baseDir, "tls")
+
+	problems := verifyCACerts(mspDir)
+
+	// the signing certificate must match a key of the keystore
+	signCerts, err := loadCerts(filepath.Join(mspDir, "signcerts"))
+	if err != nil {
+		problems = append(problems, err)
+	} else if len(signCerts) != 1 {
+		problems = append(problems, fmt.Errorf("signcerts: expected exactly one certificate, found %d",
+			len(signCerts)))
+	} else {
+		// keys kept in a PKCS#11 token only leave a reference in the keystore
+		keystore := filepath.Join(mspDir, "keystore")
+		keyFiles, _ := filepath.Glob(filepath.Join(keystore, "*_sk"))
+		if !matchAnyKey(signCerts[0], keyFiles) && !csp.HasKeyReference(keystore, signCerts[0].PublicKey) {
+			problems = append(problems, fmt.Errorf("keystore: no private key matches the signing certificate"))
+		}
+		problems = append(problems, verifyChain("signcerts", signCerts[0], mspDir, "cacerts")...)
+	}
+
+	// the TLS certificate must match server.key and chain to both the TLS CA
+	// of the node and the TLS CAs of the MSP
+	tlsCert, err := ca.LoadCertificate(filepath.Join(tlsDir, "server.crt"))
+	if err != nil {
+		return append(problems, fmt.Errorf("tls: %s", err))
+	}
+	if !matchAnyKey(tlsCert, []string{filepath.Join(tlsDir, "server.key")}) {
+		problems = append(problems, fmt.Errorf("tls: server.key does not match server.crt"))
+	}
+	tlsCACert, err := ca.LoadCertificate(filepath.Join(tlsDir, "ca.crt"))
+	if err != nil {
+		problems = append(problems, fmt.Errorf("tls: %s", err))
+	} else {
+		roots := x509.NewCertPool()
+		roots.AddCert(tlsCACert)
+		_, err = tlsCert.Verify(x509.VerifyOptions{
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			problems = append(problems, fmt.Errorf("tls: server.crt does not chain to ca.crt: %s", err))
+		}
+	}
+	problems = append(problems, verifyChain("tls", tlsCert, mspDir, "tlscacerts")...)
+
+	for _, san := range sans {
+		if !hasSAN(tlsCert, san) {
+			problems = append(problems, fmt.Errorf("tls: server.crt has no SAN %s", san))
+		}
+	}
+
+	return problems
+}
+
+// VerifyVerifyingMSP audits the MSP of an org generated in baseDir by
+// GenerateVerifyingMSP. It checks that the MSP has CA, TLS CA and admin
+// certificates, and that the admin certificates chain to its CAs. Every
+// problem found is returned.
+func VerifyVerifyingMSP(baseDir string) []error {
+
+	problems := verifyCACerts(baseDir)
+
+	tlsCACerts, err := loadCerts(filepath.Join(baseDir, "tlscacerts"))
+	if err != nil {
+		problems = append(problems, err)
+	} else if len(tlsCACerts) == 0 {
+		problems = append(problems, fmt.Errorf("tlscacerts: no certificate found"))
+	}
+
+	return problems
+}
+
+// verifyCACerts checks the cacerts and admincerts folders of the MSP in mspDir
+func verifyCACerts(mspDir string) []error {
+
+	problems := []error{}
+
+	caCerts, err := loadCerts(filepath.Join(mspDir, "cacerts"))
+	if err != nil {
+		problems = append(problems, err)
+	} else if len(caCerts) == 0 {
+		problems = append(problems, fmt.Errorf("cacerts: no certificate found"))
+	}
+
+	adminCerts, err := loadCerts(filepath.Join(mspDir, "admincerts"))
+	if err != nil {
+		problems = append(problems, err)
+	} else if len(adminCerts) == 0 {
+		problems = append(problems, fmt.Errorf("admincerts: no certificate found"))
+	}
+	for _, adminCert := range adminCerts {
+		problems = append(problems, verifyChain("admincerts", adminCert, mspDir, "cacerts")...)
+	}
+
+	return problems
+}
+
+// verifyChain checks that cert chains to one of the certificates in the
+// rootsDir folder of the MSP in mspDir through its intermediatecerts
+func verifyChain(folder string, cert *x509.Certificate, mspDir, rootsDir string) []error {
+
+	roots, err := loadCerts(filepath.Join(mspDir, rootsDir))
+	if err != nil {
+		return []error{err}
+	}
+	intermediates, err := loadCerts(filepath.Join(mspDir, "intermediatecerts"))
+	if err != nil {
+		return []error{err}
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, root := range roots {
+		opts.Roots.AddCert(root)
+	}
+	for _, intermediate := range intermediates {
+		opts.Intermediates.AddCert(intermediate)
+	}
+
+	_, err = cert.Verify(opts)
+	if err != nil {
+		return []error{fmt.Errorf("%s: certificate of %s does not chain to %s: %s",
+			folder, cert.Subject.CommonName, rootsDir, err)}
+	}
+	return nil
+}
+
+// loadCerts loads every certificate in dir. A missing dir holds no
+// certificates.
+func loadCerts(dir string) ([]*x509.Certificate, error) {
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	certs := []*x509.Certificate{}
+	for _, file := range files {
+		cert, err := ca.LoadCertificate(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filepath.Base(dir), err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// matchAnyKey returns true if one of the private keys saved in keyFiles is
+// the one of cert
+func matchAnyKey(cert *x509.Certificate, keyFiles []string) bool {
+
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false
+	}
+	for _, keyFile := range keyFiles {
+		signer, err := csp.ReadPrivateKey(keyFile)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(certKey, marshalPublicKey(signer.Public())) {
+			return true
+		}
+	}
+	return false
+}
+
+func marshalPublicKey(pub crypto.PublicKey) []byte {
+	raw, _ := x509.MarshalPKIXPublicKey(pub)
+	return raw
+}
+
+func hasSAN(cert *x509.Certificate, san string) bool {
+	for _, name := range cert.DNSNames {
+		if name == san {
+			return true
+		}
+	}
+	return false
+}